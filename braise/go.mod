@@ -2,4 +2,13 @@ module github.com/N0tT1m/braise
 
 go 1.23.3
 
+require (
+	github.com/gorilla/mux v1.8.1
+	github.com/olivere/elastic/v7 v7.0.32
+)
 
+require (
+	github.com/josharian/intern v1.0.0 // indirect
+	github.com/mailru/easyjson v0.7.7 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+)
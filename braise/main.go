@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -18,7 +19,11 @@ import (
 var client *elastic.Client
 
 const (
+	// Alias pantry keeps pointed at the current versioned recipe index
 	IndexName = "recipes"
+
+	// Written by "pantry migrate-ids": one document per retired recipe ID
+	AliasIndexName = "recipe_aliases"
 )
 
 func initElastic() {
@@ -125,7 +130,7 @@ func getAllRecipes(w http.ResponseWriter, r *http.Request) {
 	// Use a simple match_all query without any complex sorting or filtering
 	searchService := client.Search().
 		Index(IndexName).
-		Query(elastic.NewMatchAllQuery()).
+		Query(withMinConfidence(elastic.NewMatchAllQuery(), r)).
 		From(from).
 		Size(size)
 
@@ -171,6 +176,11 @@ func getRecipe(w http.ResponseWriter, r *http.Request) {
 
 	if err != nil {
 		if elastic.IsNotFound(err) {
+			// Links saved before IDs were derived from the URL redirect to the new ID
+			if newID, ok := resolveAlias(id); ok {
+				http.Redirect(w, r, "/api/recipes/"+url.PathEscape(newID), http.StatusMovedPermanently)
+				return
+			}
 			http.Error(w, "Recipe not found", http.StatusNotFound)
 		} else {
 			log.Printf("Error getting recipe: %s", err)
@@ -194,6 +204,28 @@ func getRecipe(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(recipe)
 }
 
+// resolveAlias looks up the current ID of a retired recipe ID
+func resolveAlias(id string) (string, bool) {
+	result, err := client.Get().
+		Index(AliasIndexName).
+		Id(id).
+		Do(context.Background())
+	if err != nil {
+		if !elastic.IsNotFound(err) {
+			log.Printf("Error looking up recipe alias: %s", err)
+		}
+		return "", false
+	}
+
+	var alias struct {
+		NewID string `json:"new_id"`
+	}
+	if err := json.Unmarshal(result.Source, &alias); err != nil || alias.NewID == "" || alias.NewID == id {
+		return "", false
+	}
+	return alias.NewID, true
+}
+
 // Search recipes with query
 func searchRecipes(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query().Get("q")
@@ -229,7 +261,7 @@ func searchRecipes(w http.ResponseWriter, r *http.Request) {
 	// Create search service
 	searchResult, err := client.Search().
 		Index(IndexName).
-		Query(multiMatchQuery).
+		Query(withMinConfidence(multiMatchQuery, r)).
 		From(from).
 		Size(size).
 		Sort("_score", false). // Sort by relevance
@@ -290,7 +322,7 @@ func getRecipesByCategory(w http.ResponseWriter, r *http.Request) {
 	// Create search service
 	searchResult, err := client.Search().
 		Index(IndexName).
-		Query(matchQuery).
+		Query(withMinConfidence(matchQuery, r)).
 		From(from).
 		Size(size).
 		Sort("crawl_date", false). // Sort by date (newest first)
@@ -355,7 +387,7 @@ func getRecentRecipes(w http.ResponseWriter, r *http.Request) {
 	// Create search service
 	searchResult, err := client.Search().
 		Index(IndexName).
-		Query(boolQuery).
+		Query(withMinConfidence(boolQuery, r)).
 		From(from).
 		Size(size).
 		Sort("crawl_date", false). // Sort by date (newest first)
@@ -384,3 +416,16 @@ func getRecentRecipes(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(recipes)
 }
+
+// withMinConfidence narrows a query to recipes whose extraction confidence
+// is at least the min_confidence parameter, when one is given. Recipes
+// crawled before pantry recorded confidence have none and are left out.
+func withMinConfidence(query elastic.Query, r *http.Request) elastic.Query {
+	min, err := strconv.ParseFloat(r.URL.Query().Get("min_confidence"), 64)
+	if err != nil {
+		return query
+	}
+	return elastic.NewBoolQuery().
+		Must(query).
+		Filter(elastic.NewRangeQuery("confidence").Gte(min))
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"search-engine-indexer/src/elasticsearch"
+	"search-engine-indexer/src/frontier"
+	"search-engine-indexer/src/logger"
+)
+
+var (
+	// Set while new recipes are written through the bulk indexer
+	bulkIndexing bool
+
+	// Frontier URL and page state of each recipe waiting in the bulk
+	// indexer, by ID and in the order they were queued, since a print
+	// variant and its canonical page share an ID. The state, with the new
+	// content hash, is only saved once Elasticsearch has the recipe.
+	pendingIndex   = make(map[string][]pendingRecipe)
+	pendingIndexMu sync.Mutex
+
+	// Frontier URLs whose recipe failed to index while a worker was still
+	// crawling them, so the worker marks them failed instead of done
+	indexFailures sync.Map
+)
+
+// pendingRecipe is a recipe queued for indexing and the frontier URL it
+// was crawled from
+type pendingRecipe struct {
+	url   string
+	state frontier.PageState
+}
+
+// trackPendingIndex remembers where a recipe about to be queued came from
+func trackPendingIndex(id, urlStr string, state frontier.PageState) {
+	pendingIndexMu.Lock()
+	pendingIndex[id] = append(pendingIndex[id], pendingRecipe{url: urlStr, state: state})
+	pendingIndexMu.Unlock()
+}
+
+// untrackPendingIndex forgets a recipe from urlStr that wasn't queued
+// after all
+func untrackPendingIndex(id, urlStr string) {
+	pendingIndexMu.Lock()
+	defer pendingIndexMu.Unlock()
+
+	pending := pendingIndex[id]
+	for i := len(pending) - 1; i >= 0; i-- {
+		if pending[i].url == urlStr {
+			pending = append(pending[:i], pending[i+1:]...)
+			break
+		}
+	}
+	if len(pending) == 0 {
+		delete(pendingIndex, id)
+	} else {
+		pendingIndex[id] = pending
+	}
+}
+
+// takePendingIndex returns and forgets the oldest recipe queued under an
+// ID
+func takePendingIndex(id string) (pendingRecipe, bool) {
+	pendingIndexMu.Lock()
+	defer pendingIndexMu.Unlock()
+
+	pending := pendingIndex[id]
+	if len(pending) == 0 {
+		return pendingRecipe{}, false
+	}
+	if len(pending) == 1 {
+		delete(pendingIndex, id)
+	} else {
+		pendingIndex[id] = pending[1:]
+	}
+	return pending[0], true
+}
+
+// startBulkIndexer switches recipe creation to batched bulk writes. If the
+// processor can't be started recipes are written one at a time.
+func startBulkIndexer() {
+	if err := elasticsearch.StartBulkIndexer(onIndexResult); err != nil {
+		logger.WriteWarning(fmt.Sprintf("Failed to start bulk indexer, indexing one recipe at a time: %v", err))
+		return
+	}
+	bulkIndexing = true
+}
+
+// stopBulkIndexer writes out anything still queued and logs the totals
+func stopBulkIndexer() {
+	if bulkIndexing {
+		elasticsearch.StopBulkIndexer()
+		bulkIndexing = false
+	}
+
+	stats := elasticsearch.Stats()
+	logger.WriteInfo(fmt.Sprintf("Indexed %d new recipes (%d already indexed, %d failed, %d queued)",
+		stats.Created, stats.Duplicates, stats.Failed, stats.Queued))
+}
+
+// onIndexResult is called by the bulk indexer for every recipe once its
+// batch has been written. The page state of a recipe that was created, or
+// already indexed, is saved and created recipes are backed up. Failed ones
+// mark their URL failed and keep the old content hash so a retry
+// re-indexes them.
+func onIndexResult(result elasticsearch.BulkResult) {
+	urlStr := result.Page.URL
+	pending, tracked := takePendingIndex(result.Page.ID)
+	if tracked {
+		urlStr = pending.url
+	}
+
+	if tracked && (result.Created || result.Duplicate) {
+		savePageState(urlStr, pending.state)
+	}
+
+	switch {
+	case result.Created:
+		logger.WriteInfo(fmt.Sprintf("Created new recipe: %s - %s", result.Page.ID, result.Page.URL))
+		saveRecipeToFile(result.Page)
+
+	case result.Err != nil:
+		if entry, ok := crawlFrontier.Get(urlStr); ok && entry.State == frontier.StateInFlight {
+			indexFailures.Store(urlStr, true)
+		}
+		crawlFrontier.MarkFailed(urlStr, fmt.Sprintf("index failed: %v", result.Err))
+	}
+}
+
+// indexFailed reports, once, whether the recipe from a URL failed to
+// index while it was being crawled
+func indexFailed(urlStr string) bool {
+	_, failed := indexFailures.LoadAndDelete(urlStr)
+	return failed
+}
@@ -4,81 +4,114 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/teris-io/shortid"
+	"search-engine-indexer/src/canonical"
 	"search-engine-indexer/src/elasticsearch"
+	"search-engine-indexer/src/fixtures"
+	"search-engine-indexer/src/frontier"
 	"search-engine-indexer/src/logger"
+	"search-engine-indexer/src/ratelimit"
 	"search-engine-indexer/src/scraper"
+	"search-engine-indexer/src/sites"
 	"search-engine-indexer/src/structs"
 	"sync"
+	"sync/atomic"
 )
 
 // Global variables
 var (
-	// Queue for URLs to be crawled
-	queue = make(chan string, 1000)
+	// Disk-backed frontier of URLs to be crawled. It records pending,
+	// in-flight, done and failed URLs so a crawl can be resumed.
+	crawlFrontier *frontier.Frontier
+	frontierPath  = "crawl_frontier.db"
 
-	// Set to track URLs that have been added to the queue already
-	// to prevent duplicate crawling
-	queuedURLs = sync.Map{}
+	// Number of workers currently holding or fetching a frontier entry
+	activeCrawls int32
 
-	// Track successfully crawled URLs
-	crawledURLs = sync.Map{}
+	// Recipe site definitions: seeds, URL patterns, selectors and rate limits
+	siteConfig *sites.Config
+	sitesPath  = sites.DefaultPath
 
-	// Semaphore to limit concurrent requests to a domain
-	domainSemaphores = make(map[string]*Semaphore)
-	domainLock       sync.Mutex
+	// Per-host token bucket limiter shared by every fetch. It spaces out
+	// requests, caps concurrency and backs off on 429 and 503.
+	hostLimiter *ratelimit.Limiter
 
 	// Configuration
 	maxCrawlDepth        = 3
 	concurrentWorkers    = 10
 	crawlDelayPerDomain  = 1 * time.Second
 	maxRequestsPerDomain = 5
+	maxPagesPerSite      = 0                // 0 means no per-site page budget
+	crawlTimeout         = 30 * time.Minute // 0 means no timeout
+
+	// Sitemap seeding
+	useSitemaps    = true
+	maxSitemapURLs = 5000 // Recipe URLs queued from sitemaps per domain
 
 	// Debug mode for more verbose logging
 	debugMode = false
+
+	// Age cutoff used by "frontier prune"
+	pruneOlderThan time.Duration
+
+	// Record fetched responses to, or replay them from, these directories
+	recordDir string
+	replayDir string
+
+	// Every recipe stored is also written here as JSON
+	backupDir = "recipe_backups"
 )
 
-// Custom Semaphore implementation for rate limiting
-type Semaphore struct {
-	c chan struct{}
-}
+// hostPolicy returns the base request interval and concurrency for a
+// host: -delay and -max-requests, made politer by the host's rate_limit
+// in sites.yaml
+func hostPolicy(host string) (time.Duration, int) {
+	interval, concurrency := crawlDelayPerDomain, maxRequestsPerDomain
 
-// NewSemaphore creates a new semaphore with the given limit
-func NewSemaphore(limit int) *Semaphore {
-	return &Semaphore{
-		c: make(chan struct{}, limit),
+	if site := siteConfig.Lookup(host); site != nil {
+		if delay := time.Duration(site.RateLimit.Delay); delay > interval {
+			interval = delay
+		}
+		if limit := site.RateLimit.MaxConcurrent; limit > 0 && limit < concurrency {
+			concurrency = limit
+		}
 	}
-}
-
-// Acquire acquires a semaphore token
-func (s *Semaphore) Acquire() {
-	s.c <- struct{}{}
-}
 
-// Release releases a semaphore token
-func (s *Semaphore) Release() {
-	<-s.c
+	return interval, concurrency
 }
 
-// getDomainSemaphore returns a semaphore for the given domain,
-// creating one if it doesn't exist
-func getDomainSemaphore(domain string) *Semaphore {
-	domainLock.Lock()
-	defer domainLock.Unlock()
-
-	if sem, ok := domainSemaphores[domain]; ok {
-		return sem
+// logHostRates logs the current request rate of every host, or only of
+// hosts that are backing off
+func logHostRates(backingOffOnly bool) {
+	if hostLimiter == nil {
+		return
 	}
 
-	sem := NewSemaphore(maxRequestsPerDomain)
-	domainSemaphores[domain] = sem
-	return sem
+	for _, rate := range hostLimiter.Rates() {
+		if backingOffOnly && !rate.BackingOff() {
+			continue
+		}
+		status := ""
+		if rate.BackingOff() {
+			status = " (backing off"
+			if wait := time.Until(rate.BlockedUntil); wait > 0 {
+				status += fmt.Sprintf(", retry after %v", wait.Round(time.Second))
+			}
+			status += ")"
+		}
+		logger.WriteInfo(fmt.Sprintf("  %s: %.2f req/s, one per %v (base %v)%s",
+			rate.Host, rate.PerSecond(), rate.Interval, rate.Base, status))
+	}
 }
 
 // removeDuplicates removes duplicate strings from a slice
@@ -106,19 +139,8 @@ func isRecipeListingPage(urlStr string) bool {
 	// Check path patterns for listing pages
 	path := parsedURL.Path
 
-	// Common listing page patterns
-	listingPatterns := []string{
-		"/recipes/",
-		"/cooking/recipe-ideas/",
-		"/recipe-ideas/",
-		"/recipes-a-z/",
-		"/category/",
-		"/collections/",
-		"/meal-type/",
-		"/cuisines/",
-		"/cooking-method/",
-		"/holidays-events/",
-	}
+	// Listing page patterns for this site, or the defaults from sites.yaml
+	listingPatterns := siteConfig.ListingPatterns(parsedURL.Hostname())
 
 	// Check if the path matches any listing pattern but doesn't have additional segments
 	// that would indicate a specific recipe
@@ -180,39 +202,203 @@ func isLikelyRecipePage(urlStr string) bool {
 	return false
 }
 
-// crawlURL crawls a single URL and extracts recipe data
-func crawlURL(urlStr string, depth int) {
+// queueLinks adds links found on parent to the crawl frontier one level
+// deeper. Links are queued as found and the frontier dedupes them on their
+// canonical URL. Links past maxCrawlDepth, links the frontier has already
+// seen and links for sites that have used up their page budget are
+// dropped.
+func queueLinks(parent frontier.Item, links []string) {
+	depth := parent.Depth + 1
+	if depth > maxCrawlDepth {
+		if len(links) > 0 {
+			logger.WriteInfo(fmt.Sprintf("Not queueing %d links from %s: depth %d exceeds max depth %d",
+				len(links), parent.URL, depth, maxCrawlDepth))
+		}
+		return
+	}
+
+	for _, link := range links {
+		err := crawlFrontier.Add(frontier.Item{
+			URL:    link,
+			Depth:  depth,
+			Parent: parent.URL,
+			Site:   frontierSite(link),
+		})
+		if err == frontier.ErrBudgetExhausted && debugMode {
+			logger.WriteInfo(fmt.Sprintf("Page budget exhausted for %s, dropping %s", frontierSite(link), link))
+		}
+	}
+}
+
+// seedItem builds a depth zero frontier item for a starting URL
+func seedItem(urlStr string) frontier.Item {
+	return frontier.Item{
+		URL:  urlStr,
+		Site: frontierSite(urlStr),
+	}
+}
+
+// frontierSite returns the site a URL counts against in the frontier: its
+// host without www. or amp., so both share one page budget
+func frontierSite(urlStr string) string {
+	return canonical.Host(extractSourceSite(urlStr))
+}
+
+// isSitemapRecipeURL decides whether a URL listed in a sitemap should be
+// queued. Sitemaps list every page on a site, so only recipe detail pages
+// are kept.
+func isSitemapRecipeURL(urlStr string) bool {
+	return isLikelyRecipePage(urlStr) && !isRecipeListingPage(urlStr)
+}
+
+// sitemapDomains returns the unique scheme and host of each start URL
+func sitemapDomains(startURLs []string) []string {
+	domains := []string{}
+	for _, startURL := range startURLs {
+		parsedURL, err := url.Parse(startURL)
+		if err != nil || parsedURL.Host == "" {
+			continue
+		}
+		domains = append(domains, parsedURL.Scheme+"://"+parsedURL.Host)
+	}
+	return removeDuplicates(domains)
+}
+
+// walkRecipeSitemaps finds the sitemaps for a domain and calls fn for up to
+// maxSitemapURLs recipe URLs listed in them, stopping early if fn returns
+// false. It returns how many sitemap URLs were seen in total.
+func walkRecipeSitemaps(domain string, fn func(scraper.SitemapEntry) bool) int {
+	sitemaps := scraper.DiscoverSitemaps(domain)
+	logger.WriteInfo(fmt.Sprintf("Reading %d sitemaps for %s", len(sitemaps), domain))
+
+	seen, matched := 0, 0
+	err := scraper.WalkSitemaps(sitemaps, func(entry scraper.SitemapEntry) bool {
+		seen++
+		if !isSitemapRecipeURL(entry.URL) {
+			return true
+		}
+		matched++
+		if !fn(entry) {
+			return false
+		}
+		return maxSitemapURLs <= 0 || matched < maxSitemapURLs
+	})
+	if err != nil {
+		logger.WriteWarning(fmt.Sprintf("Sitemap walk for %s ended early: %v", domain, err))
+	}
+
+	return seen
+}
+
+// seedFromSitemaps queues the recipe URLs listed in each start URL's
+// sitemaps, along with their lastmod dates
+func seedFromSitemaps(startURLs []string) {
+	for _, domain := range sitemapDomains(startURLs) {
+		queued := 0
+		seen := walkRecipeSitemaps(domain, func(entry scraper.SitemapEntry) bool {
+			err := crawlFrontier.Add(frontier.Item{
+				URL:     entry.URL,
+				Parent:  entry.Sitemap,
+				Site:    frontierSite(entry.URL),
+				LastMod: entry.LastMod,
+			})
+			if err == nil {
+				queued++
+			}
+			return true
+		})
+		logger.WriteInfo(fmt.Sprintf("Queued %d new recipe URLs from %d sitemap entries for %s", queued, seen, domain))
+	}
+}
+
+// crawlURL crawls a single frontier item and extracts recipe data. It
+// returns false if the page could not be fetched at all.
+func crawlURL(item frontier.Item) bool {
+	urlStr, depth := item.URL, item.Depth
+
 	// Check if we've reached maximum crawl depth
 	if depth > maxCrawlDepth {
 		logger.WriteInfo(fmt.Sprintf("Maximum crawl depth reached for URL: %s", urlStr))
-		return
+		return true
 	}
 
-	// Extract domain for rate limiting
 	parsedURL, err := url.Parse(urlStr)
 	if err != nil {
 		logger.WriteError(fmt.Sprintf("Failed to parse URL: %s - %v", urlStr, err))
-		return
+		return false
+	}
+
+	// Honour the Crawl-delay from robots.txt when it asks for more than
+	// we'd wait anyway. The fetcher waits for the host's limiter itself.
+	if hostLimiter != nil {
+		hostLimiter.SetMinInterval(parsedURL.Host, scraper.Robots.CrawlDelay(urlStr))
+	}
+
+	// Fetch conditionally using what we stored from the last fetch
+	state, seen := crawlFrontier.Page(urlStr)
+	response := scraper.FetchPage(urlStr, conditionalHeader(state))
+	if response == nil {
+		logger.WriteError(fmt.Sprintf("Failed to fetch URL: %s", urlStr))
+		return false
 	}
-	domain := parsedURL.Host
 
-	// Apply rate limiting per domain
-	sem := getDomainSemaphore(domain)
-	sem.Acquire()
-	defer sem.Release()
+	now := time.Now()
+	state.LastChecked = now
+
+	if response.StatusCode == http.StatusNotModified {
+		logger.WriteInfo(fmt.Sprintf("Not modified since %s, skipping: %s", state.LastChanged.Format(time.RFC3339), urlStr))
+		savePageState(urlStr, state)
+		return true
+	}
 
-	// Add a delay to avoid overloading the server
-	time.Sleep(crawlDelayPerDomain)
+	if etag := response.Header.Get("ETag"); etag != "" {
+		state.ETag = etag
+	}
+	if lastModified := response.Header.Get("Last-Modified"); lastModified != "" {
+		state.LastModified = lastModified
+	}
+
+	hash := scraper.ContentHash(response.Body)
+	if seen && hash == state.ContentHash {
+		logger.WriteInfo(fmt.Sprintf("Content unchanged since %s, skipping: %s", state.LastChanged.Format(time.RFC3339), urlStr))
+		savePageState(urlStr, state)
+		return true
+	}
+
+	// The new hash is only stored once the page has been processed, so a
+	// page that fails to index is retried in full next time. A recipe
+	// queued in the bulk indexer has its state saved by onIndexResult once
+	// Elasticsearch acknowledges it.
+	state.ContentHash = hash
+	state.LastChanged = now
+	stored := true
+	queued := false
+	defer func() {
+		// A recipe whose bulk write already failed must be re-extracted
+		if _, failed := indexFailures.Load(urlStr); stored && !queued && !failed {
+			savePageState(urlStr, state)
+		}
+	}()
 
 	// Extract links, title and description
-	s := scraper.NewScraper(urlStr)
-	if s == nil {
-		logger.WriteError(fmt.Sprintf("Failed to create scraper for URL: %s", urlStr))
-		return
+	s, err := scraper.NewScraperFromBody(urlStr, response.Body)
+	if err != nil {
+		logger.WriteError(fmt.Sprintf("Failed to create scraper for URL: %s - %v", urlStr, err))
+		stored = false
+		return false
 	}
 
-	// Mark this URL as crawled
-	crawledURLs.Store(urlStr, true)
+	// A page that declares another canonical URL, such as a print or AMP
+	// variant, is indexed under that URL. If the canonical page is already
+	// in the frontier it will be (or was) crawled itself, so skip this copy.
+	pageURL := s.CanonicalURL()
+	if pageURL != canonical.URL(urlStr) {
+		if _, known := crawlFrontier.Get(pageURL); known {
+			logger.WriteInfo(fmt.Sprintf("Skipping %s, canonical URL %s is already in the frontier", urlStr, pageURL))
+			return true
+		}
+		logger.WriteInfo(fmt.Sprintf("Using canonical URL %s for %s", pageURL, urlStr))
+	}
 
 	// Get links for further crawling
 	links := s.Links()
@@ -223,31 +409,20 @@ func crawlURL(urlStr string, depth int) {
 		logger.WriteInfo(fmt.Sprintf("Processing as a recipe listing page: %s", urlStr))
 
 		// For listing pages, just extract links and queue them for crawling
-		for _, link := range links {
-			// Check if we've already queued this URL
-			if _, exists := queuedURLs.Load(link); !exists {
-				// Mark URL as queued
-				queuedURLs.Store(link, true)
-
-				// Add to crawl queue with incremented depth
-				go func(l string, d int) {
-					queue <- l
-				}(link, depth+1)
-			}
-		}
+		queueLinks(item, links)
 
-		return
+		return true
 	}
 
 	// Check if this is a likely recipe page
 	isRecipe := isLikelyRecipePage(urlStr)
 
 	// For recipe pages, extract recipe data
-	recipeData := s.GetRecipeData()
+	extracted := s.GetRecipeData()
+	recipeData := extracted.Values
 
 	// Get basic metadata
 	title := recipeData["title"]
-	description := recipeData["description"]
 	body := s.Body()
 
 	logger.WriteInfo(fmt.Sprintf("Processing potential recipe page: %s", title))
@@ -256,15 +431,16 @@ func crawlURL(urlStr string, depth int) {
 	if debugMode {
 		logger.WriteInfo(fmt.Sprintf("Extracted data for URL %s:", urlStr))
 		for key, value := range recipeData {
-			logger.WriteInfo(fmt.Sprintf("  %s: %s", key, value))
+			if source, ok := extracted.Extraction[key]; ok {
+				logger.WriteInfo(fmt.Sprintf("  %s (%s, %.2f): %s", key, source.Source, source.Confidence, value))
+			} else {
+				logger.WriteInfo(fmt.Sprintf("  %s: %s", key, value))
+			}
 		}
 	}
 
 	// Check if the page exists
-	existsLink, page := elasticsearch.ExistingPage(title)
-
-	// Get a unique ID for new pages
-	id, _ := shortid.Generate()
+	existsLink, page := elasticsearch.ExistingPage(pageURL, title, recipeData["ingredients"], recipeData["instructions"])
 
 	// More flexible data checks
 	hasName := recipeData["name"] != "" || title != ""
@@ -326,100 +502,219 @@ func crawlURL(urlStr string, depth int) {
 		}
 
 		// Even if we skip storing this page, we still queue its links for crawling
-		for _, link := range links {
-			if _, exists := queuedURLs.Load(link); !exists {
-				queuedURLs.Store(link, true)
-				go func(l string, d int) {
-					queue <- l
-				}(link, depth+1)
-			}
-		}
+		queueLinks(item, links)
 
-		return
+		return true
 	}
 
-	// Use title as name if name is missing
-	if recipeData["name"] == "" {
-		recipeData["name"] = title
+	// Recipes that fail validation are held in the quarantine index with
+	// the HTML they were extracted from, and an existing document is kept
+	newPage := recipePage(pageURL, s, extracted, now)
+	if existsLink {
+		newPage.ID = page.ID
+	}
+	if reason := newPage.QuarantineReason(minConfidence); reason != "" {
+		quarantineRecipe(newPage, reason, response)
+		queueLinks(item, links)
+		return true
 	}
+	releaseQuarantined(newPage.ID)
 
 	if !existsLink {
-		// Create the new page in the database
-		newPage := structs.Page{
-			ID:           id,
-			Title:        title,
-			Description:  description,
-			Body:         body,
-			URL:          urlStr,
-			Image:        recipeData["image"],
-			Name:         recipeData["name"],
-			PrepTime:     recipeData["prep_time"],
-			CookTime:     recipeData["cook_time"],
-			TotalTime:    recipeData["total_time"],
-			Calories:     recipeData["calories"],
-			Servings:     recipeData["servings"],
-			Ingredients:  recipeData["ingredients"],
-			Instructions: recipeData["instructions"],
-			SourceSite:   extractSourceSite(urlStr),
-			CrawlDate:    time.Now(),
+		// With bulk indexing the outcome, and the backup, come later
+		// through onIndexResult
+		if bulkIndexing {
+			trackPendingIndex(newPage.ID, urlStr, state)
+			queued = true
 		}
 
 		success := elasticsearch.CreatePage(newPage)
 		if !success {
+			untrackPendingIndex(newPage.ID, urlStr)
+			queued = false
 			logger.WriteError(fmt.Sprintf("Failed to create page for URL: %s", urlStr))
+			stored = false
 
 			// Even if storing fails, still queue links for crawling
-			for _, link := range links {
-				if _, exists := queuedURLs.Load(link); !exists {
-					queuedURLs.Store(link, true)
-					go func(l string, d int) {
-						queue <- l
-					}(link, depth+1)
-				}
-			}
+			queueLinks(item, links)
 
-			return
+			return true
 		}
 
-		logger.WriteInfo(fmt.Sprintf("Created new recipe: %s - %s", newPage.ID, urlStr))
+		if !bulkIndexing {
+			logger.WriteInfo(fmt.Sprintf("Created new recipe: %s - %s", newPage.ID, pageURL))
 
-		// Save a copy to the filesystem for backup
-		saveRecipeToFile(newPage)
+			// Save a copy to the filesystem for backup
+			saveRecipeToFile(newPage)
+		}
 	} else {
 		// Update the page in database
-		params := map[string]interface{}{
-			"title":        title,
-			"description":  description,
-			"body":         body,
-			"image":        recipeData["image"],
-			"name":         recipeData["name"],
-			"prep_time":    recipeData["prep_time"],
-			"cook_time":    recipeData["cook_time"],
-			"total_time":   recipeData["total_time"],
-			"calories":     recipeData["calories"],
-			"servings":     recipeData["servings"],
-			"ingredients":  recipeData["ingredients"],
-			"instructions": recipeData["instructions"],
-			"source_site":  extractSourceSite(urlStr),
+		params := recipeParams(newPage)
+
+		// The HTML changed but the recipe may not have, e.g. when only ads
+		// or comments differ. Leave the document alone in that case.
+		if pageMatches(page, params) {
+			logger.WriteInfo(fmt.Sprintf("Recipe unchanged for page %s (%s)", page.ID, title))
+			queueLinks(item, links)
+			return true
 		}
+		params["last_changed"] = now
 
 		success := elasticsearch.UpdatePage(page.ID, params)
 		if !success {
 			logger.WriteError(fmt.Sprintf("Failed to update page for URL: %s", urlStr))
+			stored = false
 		} else {
 			logger.WriteInfo(fmt.Sprintf("Updated page %s (%s)", page.ID, title))
 		}
 	}
 
 	// Queue new links for crawling
-	for _, link := range links {
-		if _, exists := queuedURLs.Load(link); !exists {
-			queuedURLs.Store(link, true)
-			go func(l string, d int) {
-				queue <- l
-			}(link, depth+1)
+	queueLinks(item, links)
+
+	return true
+}
+
+// recipePage builds the page stored for the recipe extracted from the
+// page at pageURL, named after the page title when no name was found
+func recipePage(pageURL string, s *scraper.Scraper, extracted *scraper.RecipeData, now time.Time) structs.Page {
+	recipeData := extracted.Values
+
+	name := recipeData["name"]
+	if name == "" {
+		name = recipeData["title"]
+	}
+
+	// IDs are derived from the canonical URL so a re-crawl produces the
+	// same ID
+	p := structs.Page{
+		ID:           canonical.ID(pageURL),
+		Title:        recipeData["title"],
+		Description:  recipeData["description"],
+		Body:         s.Body(),
+		URL:          pageURL,
+		Image:        recipeData["image"],
+		Name:         name,
+		PrepTime:     recipeData["prep_time"],
+		CookTime:     recipeData["cook_time"],
+		TotalTime:    recipeData["total_time"],
+		Calories:     recipeData["calories"],
+		Servings:     recipeData["servings"],
+		Ingredients:  recipeData["ingredients"],
+		Instructions: recipeData["instructions"],
+		SourceSite:   extractSourceSite(pageURL),
+		CrawlDate:    now,
+		LastChanged:  now,
+	}
+	p.SetRecipeDetails(recipeData)
+	p.SetPluginIngredients(recipeData)
+	p.SetExtraction(extracted.Extraction)
+	return p
+}
+
+// recipeParams are the UpdatePage parameters that store p's recipe over
+// an existing document
+func recipeParams(p structs.Page) map[string]interface{} {
+	params := map[string]interface{}{
+		"title":        p.Title,
+		"description":  p.Description,
+		"body":         p.Body,
+		"image":        p.Image,
+		"name":         p.Name,
+		"prep_time":    p.PrepTime,
+		"cook_time":    p.CookTime,
+		"total_time":   p.TotalTime,
+		"calories":     p.Calories,
+		"servings":     p.Servings,
+		"ingredients":  p.Ingredients,
+		"instructions": p.Instructions,
+		"source_site":  p.SourceSite,
+		"categories":   p.Categories,
+		"cuisines":     p.Cuisines,
+		"keywords":     p.Keywords,
+		"diets":        p.Diets,
+		"author":       p.Author,
+		"rating_value": p.RatingValue,
+		"rating_count": p.RatingCount,
+		"video_url":    p.VideoURL,
+		"nutrition":    p.Nutrition,
+		"notes":        p.Notes,
+
+		"ingredient_plugin": p.IngredientPlugin,
+		"extraction":        p.Extraction,
+		"confidence":        p.Confidence,
+	}
+
+	// Ingredient parts read from a recipe plugin's markup are stored as
+	// they are; otherwise UpdatePage parses the lines
+	if p.IngredientPlugin != "" {
+		params["ingredient_items"] = p.IngredientItems
+	}
+
+	return params
+}
+
+// conditionalHeader builds If-None-Match and If-Modified-Since headers
+// from a URL's stored state
+func conditionalHeader(state frontier.PageState) http.Header {
+	header := http.Header{}
+	if state.ETag != "" {
+		header.Set("If-None-Match", state.ETag)
+	}
+	if state.LastModified != "" {
+		header.Set("If-Modified-Since", state.LastModified)
+	}
+	return header
+}
+
+// savePageState records a URL's validators, content hash and check times
+func savePageState(urlStr string, state frontier.PageState) {
+	if err := crawlFrontier.SetPage(urlStr, state); err != nil {
+		logger.WriteError(fmt.Sprintf("Failed to save page state for %s: %v", urlStr, err))
+	}
+}
+
+// pageMatches reports whether an existing page already holds the values
+// in an update
+func pageMatches(page structs.Page, params map[string]interface{}) bool {
+	current := map[string]interface{}{
+		"title":        page.Title,
+		"description":  page.Description,
+		"body":         page.Body,
+		"image":        page.Image,
+		"name":         page.Name,
+		"prep_time":    page.PrepTime,
+		"cook_time":    page.CookTime,
+		"total_time":   page.TotalTime,
+		"calories":     page.Calories,
+		"servings":     page.Servings,
+		"ingredients":  page.Ingredients,
+		"instructions": page.Instructions,
+		"source_site":  page.SourceSite,
+		"categories":   page.Categories,
+		"cuisines":     page.Cuisines,
+		"keywords":     page.Keywords,
+		"diets":        page.Diets,
+		"author":       page.Author,
+		"rating_value": page.RatingValue,
+		"rating_count": page.RatingCount,
+		"video_url":    page.VideoURL,
+		"nutrition":    page.Nutrition,
+		"notes":        page.Notes,
+
+		"ingredient_plugin": page.IngredientPlugin,
+		"ingredient_items":  page.IngredientItems,
+		"extraction":        page.Extraction,
+		"confidence":        page.Confidence,
+	}
+
+	// Lists and pointers compare by value
+	for field, value := range params {
+		if !reflect.DeepEqual(current[field], value) {
+			return false
 		}
 	}
+	return true
 }
 
 // Helper function to extract source site from URL
@@ -431,21 +726,29 @@ func extractSourceSite(urlStr string) string {
 	return parsedURL.Host
 }
 
-// saveRecipeToFile saves a backup of the recipe to a JSON file
+// backupFilename returns the backup path for a page, based on its ID and title
+func backupFilename(page structs.Page) string {
+	safeName := strings.ReplaceAll(page.Title, " ", "_")
+	safeName = strings.ReplaceAll(safeName, "/", "_")
+	safeName = strings.ReplaceAll(safeName, "\\", "_")
+	safeName = strings.ReplaceAll(safeName, ":", "_")
+	return filepath.Join(backupDir, fmt.Sprintf("%s_%s.json", page.ID, safeName))
+}
+
+// saveRecipeToFile saves a backup of the recipe to a JSON file, or to the
+// crawl's snapshot with -backup-format=snapshot
 func saveRecipeToFile(page structs.Page) {
+	if crawlSnapshot != nil && saveRecipeToSnapshot(page) {
+		return
+	}
+
 	// Create backups directory if it doesn't exist
-	backupDir := "recipe_backups"
 	if err := os.MkdirAll(backupDir, 0755); err != nil {
 		logger.WriteError(fmt.Sprintf("Failed to create backup directory: %v", err))
 		return
 	}
 
-	// Create a filename based on the page ID and title
-	safeName := strings.ReplaceAll(page.Title, " ", "_")
-	safeName = strings.ReplaceAll(safeName, "/", "_")
-	safeName = strings.ReplaceAll(safeName, "\\", "_")
-	safeName = strings.ReplaceAll(safeName, ":", "_")
-	filename := filepath.Join(backupDir, fmt.Sprintf("%s_%s.json", page.ID, safeName))
+	filename := backupFilename(page)
 
 	// Marshal the page data to JSON
 	jsonData, err := json.MarshalIndent(page, "", "  ")
@@ -463,20 +766,83 @@ func saveRecipeToFile(page structs.Page) {
 	logger.WriteInfo(fmt.Sprintf("Saved recipe backup to %s", filename))
 }
 
-// worker function processes URLs from the queue
-func worker(wg *sync.WaitGroup, id int) {
+// worker function processes URLs from the frontier until stop is closed.
+// With exitWhenIdle it also returns once no work is pending and no other
+// worker is still crawling.
+func worker(wg *sync.WaitGroup, id int, stop <-chan struct{}, exitWhenIdle bool) {
 	logger.WriteInfo(fmt.Sprintf("Worker %d started", id))
 
-	for link := range queue {
-		// Extract depth from metadata or default to 0
-		depth := 0
-		crawlURL(link, depth)
+	for !stopped(stop) {
+		// Count ourselves as active before popping so idle workers don't
+		// exit while we hold an entry that may produce more links
+		atomic.AddInt32(&activeCrawls, 1)
+		entry, ok := crawlFrontier.Next()
+		if !ok {
+			if atomic.AddInt32(&activeCrawls, -1) == 0 && exitWhenIdle {
+				break
+			}
+			select {
+			case <-stop:
+			case <-time.After(500 * time.Millisecond):
+			}
+			continue
+		}
+
+		if !scraper.Robots.Allowed(entry.URL) {
+			logger.WriteWarning(fmt.Sprintf("Skipping URL disallowed by robots.txt: %s", entry.URL))
+			crawlFrontier.MarkBlocked(entry.URL, "disallowed by robots.txt")
+			atomic.AddInt32(&activeCrawls, -1)
+			continue
+		}
+
+		if crawlURL(entry.Item) && !indexFailed(entry.URL) {
+			crawlFrontier.MarkDone(entry.URL)
+		} else if current, _ := crawlFrontier.Get(entry.URL); current.State == frontier.StateInFlight {
+			// A failed bulk write has already recorded its own reason
+			crawlFrontier.MarkFailed(entry.URL, "fetch failed")
+		}
+		atomic.AddInt32(&activeCrawls, -1)
 	}
 
 	logger.WriteInfo(fmt.Sprintf("Worker %d finished", id))
 	wg.Done()
 }
 
+// stopped reports whether stop has been closed
+func stopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// notifyShutdown returns a channel that receives SIGINT and SIGTERM
+func notifyShutdown() chan os.Signal {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	return signals
+}
+
+// stopWorkers asks the workers to finish the URL they are on and waits for
+// them. A second signal exits without waiting for the workers, once the
+// recipes already queued are written; the interrupted URLs are left
+// in-flight and requeued on the next start.
+func stopWorkers(stop chan struct{}, finished <-chan struct{}, signals <-chan os.Signal) {
+	close(stop)
+	logger.WriteInfo("Waiting for workers to finish their current URL...")
+
+	select {
+	case <-finished:
+	case sig := <-signals:
+		logger.WriteWarning(fmt.Sprintf("Received %v again, exiting without waiting for workers", sig))
+		stopBulkIndexer()
+		crawlFrontier.Close()
+		os.Exit(1)
+	}
+}
+
 // checkIndexPresence ensures the Elasticsearch index exists
 func checkIndexPresence() {
 	elasticsearch.NewElasticSearchClient()
@@ -486,58 +852,94 @@ func checkIndexPresence() {
 		elasticsearch.CreateIndex(elasticsearch.IndexName)
 	} else {
 		logger.WriteInfo("Elasticsearch index already exists")
+		elasticsearch.CheckIndexVersion()
+		elasticsearch.EnsureDuplicateMapping()
+	}
+}
+
+// openFrontier opens the crawl frontier at frontierPath
+func openFrontier() bool {
+	f, err := frontier.Open(frontierPath)
+	if err != nil {
+		logger.WriteError(fmt.Sprintf("Failed to open crawl frontier: %v", err))
+		return false
 	}
+	crawlFrontier = f
+	return true
 }
 
-// startCrawling initializes workers and begins crawling from the starting URL
+// startCrawling initializes workers and begins crawling from the starting URL.
+// Work left pending or in-flight by a previous run is resumed first.
 func startCrawling(startURLs []string) {
 	// Ensure Elasticsearch index exists
 	checkIndexPresence()
 
-	var wg sync.WaitGroup
+	if !openFrontier() {
+		return
+	}
+	defer crawlFrontier.Close()
 
-	// Set up termination channel with timeout
-	timeout := 30 * time.Minute
-	done := make(chan bool)
+	// Anything in-flight was interrupted by a crash or timeout
+	if resumed, err := crawlFrontier.Requeue(frontier.StateInFlight); err != nil {
+		logger.WriteError(fmt.Sprintf("Failed to requeue in-flight URLs: %v", err))
+	} else if resumed > 0 {
+		logger.WriteInfo(fmt.Sprintf("Resuming %d interrupted URLs from %s", resumed, frontierPath))
+	}
 
-	// Send initial URLs to channel
+	// Add initial URLs to the frontier
+	crawlFrontier.SiteBudget = maxPagesPerSite
 	for _, startURL := range startURLs {
-		go func(url string) {
-			queuedURLs.Store(url, true)
-			queue <- url
-		}(startURL)
+		crawlFrontier.Add(seedItem(startURL))
+	}
+	if useSitemaps {
+		seedFromSitemaps(startURLs)
 	}
 
+	startBulkIndexer()
+	startBackups()
+
 	// Create worker pool
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
 	wg.Add(concurrentWorkers)
 	for i := 1; i <= concurrentWorkers; i++ {
-		go worker(&wg, i)
+		go worker(&wg, i, stop, true)
 	}
 
-	// Set up timeout
-	go func() {
-		time.Sleep(timeout)
-		logger.WriteInfo(fmt.Sprintf("Crawler timeout reached after %v", timeout))
-		done <- true
-	}()
-
-	// Wait for workers to finish or timeout
+	// Wait for workers to finish, the timeout or a shutdown signal
+	finished := make(chan struct{})
 	go func() {
 		wg.Wait()
-		done <- true
+		close(finished)
 	}()
 
-	// Wait for done signal
-	<-done
+	var timeout <-chan time.Time
+	if crawlTimeout > 0 {
+		timeout = time.After(crawlTimeout)
+	}
+	signals := notifyShutdown()
+
+	select {
+	case <-finished:
+	case <-timeout:
+		logger.WriteInfo(fmt.Sprintf("Crawler timeout reached after %v", crawlTimeout))
+		stopWorkers(stop, finished, signals)
+	case sig := <-signals:
+		logger.WriteInfo(fmt.Sprintf("Received %v, stopping crawl", sig))
+		stopWorkers(stop, finished, signals)
+	}
 
-	// Print summary
-	var crawledCount int
-	crawledURLs.Range(func(_, _ interface{}) bool {
-		crawledCount++
-		return true
-	})
+	// Write out the last batch before reporting
+	stopBulkIndexer()
+	stopBackups()
 
-	logger.WriteInfo(fmt.Sprintf("Crawling completed. Processed %d URLs.", crawledCount))
+	// Print summary
+	counts := crawlFrontier.Counts()
+	logger.WriteInfo(fmt.Sprintf("Crawling completed. Processed %d URLs (%d failed, %d blocked by robots.txt, %d still pending).",
+		counts[frontier.StateDone], counts[frontier.StateFailed], counts[frontier.StateBlocked],
+		counts[frontier.StatePending]+counts[frontier.StateInFlight]))
+	logger.WriteInfo("Request rates per host:")
+	logHostRates(false)
 }
 
 // deleteIndex removes the Elasticsearch index
@@ -546,50 +948,392 @@ func deleteIndex() {
 	elasticsearch.DeleteIndex()
 }
 
-// getPopularRecipeSites returns URLs for popular recipe sites
+// getPopularRecipeSites returns the seed URLs of every configured site
 func getPopularRecipeSites() []string {
-	return []string{
-		"https://pinchofyum.com/recipes",
-		"https://minimalistbaker.com/recipes", 
-		"https://cookieandkate.com/recipes",
-		"https://loveandlemons.com/recipes",
-		"https://smittenkitchen.com/recipes",
-		"https://seriouseats.com/recipes",
-		"https://halfbakedharvest.com/category/recipes",
-		"https://101cookbooks.com/recipes",
-		"https://food52.com/recipes",
-		"https://budgetbytes.com/category/recipes",
-		"https://thewoksoflife.com/recipes",
-		"https://www.delish.com/cooking/recipe-ideas/",
-		"https://www.allrecipes.com/recipes/",
-		"https://www.foodnetwork.com/recipes",
-		"https://www.epicurious.com/recipes",
-		"https://www.simplyrecipes.com/recipes/",
-	}
+	return siteConfig.Seeds()
 }
 
 // startRecipeCrawling starts crawling popular recipe sites
 func startRecipeCrawling() {
-	sites := getPopularRecipeSites()
-	fmt.Printf("Starting to crawl %d popular recipe sites...\n", len(sites))
+	startURLs := getPopularRecipeSites()
+	fmt.Printf("Starting to crawl %d popular recipe sites...\n", len(startURLs))
 	
 	logger.WriteInfo(fmt.Sprintf("Starting crawler with parameters:"))
 	logger.WriteInfo(fmt.Sprintf("  Workers: %d", concurrentWorkers))
 	logger.WriteInfo(fmt.Sprintf("  Max Depth: %d", maxCrawlDepth))
 	logger.WriteInfo(fmt.Sprintf("  Delay: %v", crawlDelayPerDomain))
 	logger.WriteInfo(fmt.Sprintf("  Max Requests Per Domain: %d", maxRequestsPerDomain))
+	logger.WriteInfo(fmt.Sprintf("  Max Pages Per Site: %d", maxPagesPerSite))
 	logger.WriteInfo(fmt.Sprintf("  Debug Mode: %t", debugMode))
-	logger.WriteInfo(fmt.Sprintf("  Starting URLs: %v", sites))
+	logger.WriteInfo(fmt.Sprintf("  User-Agent: %s", scraper.UserAgent))
+	logger.WriteInfo(fmt.Sprintf("  Starting URLs: %v", startURLs))
 
-	startCrawling(sites)
+	startCrawling(startURLs)
+}
 
-	// Print summary
-	var crawledCount int
-	crawledURLs.Range(func(_, _ interface{}) bool {
-		crawledCount++
+// loadSites reads the site definition file and hands the sites to the
+// scraper and the indexer
+func loadSites() bool {
+	cfg, err := sites.Load(sitesPath)
+	if err != nil {
+		fmt.Println("Failed to load site definitions:", err)
+		return false
+	}
+
+	siteConfig = cfg
+	scraper.SetSites(cfg.Sites)
+	scraper.SetDefaultExtractionOrder(cfg.Defaults.ExtractionOrder)
+	elasticsearch.SetRecipeSites(cfg.Sites)
+	logger.WriteInfo(fmt.Sprintf("Loaded %d site definitions from %s", len(cfg.Sites), sitesPath))
+
+	return true
+}
+
+// configureFetcher switches the scraper to record or replay mode when
+// -record or -replay was given. Live fetches go through the per-host rate
+// limiter; replayed ones don't need to.
+func configureFetcher() bool {
+	if recordDir != "" && replayDir != "" {
+		fmt.Println("Use either -record or -replay, not both")
+		return false
+	}
+
+	if replayDir != "" {
+		f, err := scraper.NewReplayFetcher(replayDir)
+		if err != nil {
+			fmt.Println("Failed to set up replay:", err)
+			return false
+		}
+		scraper.SetFetcher(f)
+		logger.WriteInfo(fmt.Sprintf("Replaying recorded responses from %s", replayDir))
+	} else if recordDir != "" {
+		f, err := scraper.NewRecordingFetcher(scraper.DefaultFetcher, recordDir)
+		if err != nil {
+			fmt.Println("Failed to set up recording:", err)
+			return false
+		}
+		scraper.SetFetcher(f)
+		logger.WriteInfo(fmt.Sprintf("Recording fetched responses to %s", recordDir))
+	}
+
+	if replayDir == "" {
+		hostLimiter = ratelimit.New(hostPolicy)
+		scraper.SetFetcher(scraper.NewLimitedFetcher(scraper.DefaultFetcher, hostLimiter))
+	}
+
+	return true
+}
+
+// runVerifyFixtures re-extracts every saved fixture page and diffs the
+// result field by field against its golden file. With update set the
+// golden files are regenerated instead. It returns false if any fixture
+// failed.
+func runVerifyFixtures(dir string, update bool) bool {
+	all, err := fixtures.Load(dir)
+	if err != nil {
+		fmt.Println("Failed to load fixtures:", err)
+		return false
+	}
+
+	passed, failed := 0, 0
+	for _, f := range all {
+		if update {
+			if err := f.Update(); err != nil {
+				fmt.Printf("ERROR %s/%s: %v\n", f.Domain, f.Name, err)
+				failed++
+				continue
+			}
+			fmt.Printf("UPDATED %s/%s\n", f.Domain, f.Name)
+			passed++
+			continue
+		}
+
+		diffs, err := f.Verify()
+		if err != nil {
+			fmt.Printf("ERROR %s/%s: %v\n", f.Domain, f.Name, err)
+			failed++
+			continue
+		}
+		if len(diffs) == 0 {
+			fmt.Printf("PASS  %s/%s\n", f.Domain, f.Name)
+			passed++
+			continue
+		}
+
+		fmt.Printf("FAIL  %s/%s (%s)\n", f.Domain, f.Name, f.URL)
+		for _, diff := range diffs {
+			fmt.Printf("    %s:\n      expected: %q\n      actual:   %q\n", diff.Field, diff.Expected, diff.Actual)
+		}
+		failed++
+	}
+
+	if missing := fixtures.MissingDomains(all); len(missing) > 0 {
+		fmt.Printf("\nRecipe sites without fixtures: %s\n", strings.Join(missing, ", "))
+	}
+	if uncaptured := fixtures.Uncaptured(all); len(uncaptured) > 0 {
+		names := make([]string, 0, len(uncaptured))
+		for _, f := range uncaptured {
+			names = append(names, f.Domain+"/"+f.Name)
+		}
+		fmt.Printf("\nFixtures not captured from the live site (run capture-fixture --all): %s\n", strings.Join(names, ", "))
+	}
+
+	fmt.Printf("\n%d fixtures, %d passed, %d failed\n", len(all), passed, failed)
+	return verifyIngredientFixtures(dir, update) && failed == 0
+}
+
+// runRecaptureFixtures replaces every fixture page in dir with the live
+// page at its URL and regenerates its golden file. Pass -record=DIR to
+// keep the raw responses as well.
+func runRecaptureFixtures(dir string) bool {
+	all, err := fixtures.Load(dir)
+	if err != nil {
+		fmt.Println("Failed to load fixtures:", err)
+		return false
+	}
+
+	captured, failed := 0, 0
+	for _, f := range all {
+		if err := f.Recapture(); err != nil {
+			fmt.Printf("ERROR %s/%s: %v\n", f.Domain, f.Name, err)
+			failed++
+			continue
+		}
+		fmt.Printf("CAPTURED %s/%s\n", f.Domain, f.Name)
+		captured++
+	}
+
+	fmt.Printf("\n%d fixtures, %d captured, %d failed\n", len(all), captured, failed)
+	return failed == 0
+}
+
+// verifyIngredientFixtures re-parses the golden ingredient lines and
+// prints the ones that no longer parse as expected
+func verifyIngredientFixtures(dir string, update bool) bool {
+	cases, err := fixtures.LoadIngredientCases(dir)
+	if err != nil {
+		fmt.Println("Failed to load ingredient lines:", err)
+		return false
+	}
+	if len(cases) == 0 {
+		return true
+	}
+
+	if update {
+		if err := fixtures.UpdateIngredientCases(dir, cases); err != nil {
+			fmt.Println("Failed to update ingredient lines:", err)
+			return false
+		}
+		fmt.Printf("UPDATED %d ingredient lines in %s\n", len(cases), fixtures.IngredientsFile)
+		return true
+	}
+
+	failed := 0
+	for _, c := range cases {
+		diffs := c.Verify()
+		if len(diffs) == 0 {
+			continue
+		}
+
+		fmt.Printf("FAIL  ingredient %q\n", c.Line)
+		for _, diff := range diffs {
+			fmt.Printf("    %s:\n      expected: %q\n      actual:   %q\n", diff.Field, diff.Expected, diff.Actual)
+		}
+		failed++
+	}
+
+	fmt.Printf("%d ingredient lines, %d passed, %d failed\n", len(cases), len(cases)-failed, failed)
+	return failed == 0
+}
+
+// runSitemapCommand does a dry run of sitemap seeding for a domain and
+// prints what would be queued
+func runSitemapCommand(domain string) {
+	if !strings.HasPrefix(domain, "http") {
+		domain = "https://" + domain
+	}
+
+	fmt.Println("Sitemaps:")
+	for _, sitemap := range scraper.DiscoverSitemaps(domain) {
+		fmt.Printf("  %s\n", sitemap)
+	}
+
+	matched := 0
+	seen := walkRecipeSitemaps(domain, func(entry scraper.SitemapEntry) bool {
+		matched++
+		lastMod := "-"
+		if !entry.LastMod.IsZero() {
+			lastMod = entry.LastMod.Format("2006-01-02")
+		}
+		fmt.Printf("  %s  %s\n", lastMod, entry.URL)
 		return true
 	})
-	fmt.Printf("Crawling completed. Processed %d URLs.\n", crawledCount)
+
+	fmt.Printf("\nWould queue %d recipe URLs out of %d sitemap entries (limit %d)\n", matched, seen, maxSitemapURLs)
+}
+
+// runFrontierCommand inspects and maintains the crawl frontier
+func runFrontierCommand(args []string) {
+	if !openFrontier() {
+		fmt.Println("Failed to open crawl frontier:", frontierPath)
+		return
+	}
+	defer crawlFrontier.Close()
+
+	action := "stats"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action = args[0]
+	}
+
+	// formatEntry renders a frontier entry on one line
+	formatEntry := func(entry frontier.Entry) string {
+		line := fmt.Sprintf("[%s, depth %d, attempts %d, %s] %s",
+			entry.State, entry.Depth, entry.Attempts, entry.UpdatedAt.Format(time.RFC3339), entry.URL)
+		if entry.Error != "" {
+			line += " - " + entry.Error
+		}
+		return line
+	}
+
+	// parseState reads a frontier state from the argument list
+	parseState := func() (frontier.State, bool) {
+		if len(args) < 2 {
+			fmt.Println("Please provide a state: pending, in_flight, done, failed or blocked")
+			return "", false
+		}
+		for _, state := range frontier.States {
+			if string(state) == args[1] {
+				return state, true
+			}
+		}
+		fmt.Println("Unknown state:", args[1])
+		return "", false
+	}
+
+	switch action {
+	case "stats":
+		counts := crawlFrontier.Counts()
+		fmt.Printf("Frontier: %s\n", frontierPath)
+		for _, state := range frontier.States {
+			fmt.Printf("  %-10s %d\n", state, counts[state])
+		}
+
+	case "list":
+		state, ok := parseState()
+		if !ok {
+			return
+		}
+		limit := 50
+		if len(args) >= 3 && !strings.HasPrefix(args[2], "-") {
+			fmt.Sscanf(args[2], "%d", &limit)
+		}
+		for _, entry := range crawlFrontier.List(state, limit) {
+			fmt.Println("  " + formatEntry(entry))
+		}
+
+	case "sites":
+		for site, count := range crawlFrontier.SiteCounts() {
+			fmt.Printf("  %-30s %d\n", site, count)
+		}
+
+	case "show":
+		if len(args) < 2 {
+			fmt.Println("Please provide a URL to show")
+			return
+		}
+		entry, ok := crawlFrontier.Get(args[1])
+		if !ok {
+			fmt.Println("URL is not in the frontier:", args[1])
+			return
+		}
+		fmt.Println(formatEntry(entry))
+		fmt.Printf("  Site: %s\n", entry.Site)
+		fmt.Printf("  Discovered: %s\n", entry.DiscoveredAt.Format(time.RFC3339))
+		if !entry.LastMod.IsZero() {
+			fmt.Printf("  Sitemap lastmod: %s\n", entry.LastMod.Format(time.RFC3339))
+		}
+		if state, ok := crawlFrontier.Page(entry.URL); ok {
+			fmt.Printf("  Last checked: %s\n", state.LastChecked.Format(time.RFC3339))
+			fmt.Printf("  Last changed: %s\n", state.LastChanged.Format(time.RFC3339))
+			if state.ETag != "" {
+				fmt.Printf("  ETag: %s\n", state.ETag)
+			}
+			if state.LastModified != "" {
+				fmt.Printf("  Last-Modified: %s\n", state.LastModified)
+			}
+			fmt.Printf("  Content hash: %s\n", state.ContentHash)
+		}
+		fmt.Println("  Referrers:")
+		for _, ancestor := range crawlFrontier.Ancestors(entry.URL) {
+			fmt.Printf("    <- %s\n", ancestor.URL)
+		}
+		fmt.Printf("  Discovered %d links\n", len(crawlFrontier.Children(entry.URL)))
+
+	case "tree":
+		if len(args) < 2 {
+			fmt.Println("Please provide a root URL")
+			return
+		}
+		maxLevels := maxCrawlDepth
+		if len(args) >= 3 && !strings.HasPrefix(args[2], "-") {
+			fmt.Sscanf(args[2], "%d", &maxLevels)
+		}
+		root, ok := crawlFrontier.Get(args[1])
+		if !ok {
+			fmt.Println("URL is not in the frontier:", args[1])
+			return
+		}
+
+		var printTree func(entry frontier.Entry, level int)
+		printTree = func(entry frontier.Entry, level int) {
+			fmt.Println(strings.Repeat("  ", level) + formatEntry(entry))
+			if level >= maxLevels {
+				return
+			}
+			for _, child := range crawlFrontier.Children(entry.URL) {
+				printTree(child, level+1)
+			}
+		}
+		printTree(root, 0)
+
+	case "prune":
+		state, ok := parseState()
+		if !ok {
+			return
+		}
+		var cutoff time.Time
+		if pruneOlderThan > 0 {
+			cutoff = time.Now().Add(-pruneOlderThan)
+		}
+		removed, err := crawlFrontier.Prune(state, cutoff)
+		if err != nil {
+			fmt.Println("Failed to prune frontier:", err)
+			return
+		}
+		fmt.Printf("Pruned %d %s URLs\n", removed, state)
+
+	case "retry":
+		// "retry done" re-checks finished URLs; unchanged pages are skipped
+		// cheaply using their stored ETag, Last-Modified and content hash
+		state := frontier.StateFailed
+		if len(args) >= 2 && !strings.HasPrefix(args[1], "-") {
+			state = frontier.State(args[1])
+		}
+		if state != frontier.StateFailed && state != frontier.StateDone && state != frontier.StateBlocked {
+			fmt.Println("Only failed, done or blocked URLs can be retried")
+			return
+		}
+		moved, err := crawlFrontier.Requeue(state)
+		if err != nil {
+			fmt.Printf("Failed to requeue %s URLs: %v\n", state, err)
+			return
+		}
+		fmt.Printf("Requeued %d %s URLs\n", moved, state)
+
+	default:
+		fmt.Println("Unknown frontier action:", action)
+		fmt.Println("Valid actions are: stats, list, sites, show, tree, prune, retry")
+	}
 }
 
 // main function handles command line arguments and starts the crawler
@@ -614,13 +1358,71 @@ func main() {
 		fmt.Println("\tgo run *.go index URL")
 		fmt.Println()
 		fmt.Println("3. If you want to crawl with custom parameters:")
-		fmt.Println("\tgo run *.go index URL -workers=20 -depth=5 -delay=2 -debug=true")
+		fmt.Println("\tgo run *.go index URL -workers=20 -depth=5 -delay=2 -max-pages-per-site=500 -timeout=1h -debug=true")
 		fmt.Println()
 		fmt.Println("4. If you want to delete the pages index from elastic search:")
 		fmt.Println("\tgo run *.go delete")
 		fmt.Println()
 		fmt.Println("5. If you want to test a specific URL:")
 		fmt.Println("\tgo run *.go test-url URL")
+		fmt.Println()
+		fmt.Println("6. If you want to inspect or prune the crawl frontier:")
+		fmt.Println("\tgo run *.go frontier [stats|list STATE [LIMIT]|sites|show URL|tree URL [LEVELS]|prune STATE [-older-than=24h]|retry [STATE]]")
+		fmt.Println()
+		fmt.Println("7. If you want to preview the recipe URLs a site's sitemaps would queue:")
+		fmt.Println("\tgo run *.go sitemap DOMAIN [-sitemap-limit=5000]")
+		fmt.Println()
+		fmt.Println("8. If you want to check extraction against the saved fixture pages:")
+		fmt.Println("\tgo run *.go verify-fixtures [DIR] [--update]")
+		fmt.Println("\tgo run *.go capture-fixture URL")
+		fmt.Println("\tgo run *.go capture-fixture --all [DIR] [-record=DIR]")
+		fmt.Println()
+		fmt.Println("9. If you want to keep crawling and re-check sites on a schedule until stopped:")
+		fmt.Println("\tgo run *.go serve [-recrawl-interval=24h] [-refresh-limit=1000]")
+		fmt.Println()
+		fmt.Println("10. If you want to fingerprint every stored recipe and regroup near-duplicates:")
+		fmt.Println("\tgo run *.go dedupe")
+		fmt.Println()
+		fmt.Println("11. If you want to move recipes to IDs derived from their canonical URL:")
+		fmt.Println("\tgo run *.go migrate-ids")
+		fmt.Println()
+		fmt.Println("12. If you want to load recipe backups back into the index:")
+		fmt.Println("\tgo run *.go restore [DIR] [-dry-run] [-site=DOMAIN]")
+		fmt.Println()
+		fmt.Println("13. If you want to write recipes to compressed snapshots:")
+		fmt.Println("\tgo run *.go snapshot [-incremental] [-snapshot-dir=DIR]")
+		fmt.Println("\tgo run *.go snapshot convert [DIR]")
+		fmt.Println("\tgo run *.go snapshot list")
+		fmt.Println()
+		fmt.Println("14. If you want to move recipes to an index built from the current mapping:")
+		fmt.Println("\tgo run *.go migrate [status|rollback]")
+		fmt.Println()
+		fmt.Println("15. If you want to fill in numeric time, calorie and servings fields and structured ingredients on stored recipes:")
+		fmt.Println("\tgo run *.go backfill")
+		fmt.Println()
+		fmt.Println("16. If you want to inspect, re-extract or drop recipes that failed validation:")
+		fmt.Println("\tgo run *.go quarantine [list|retry|promote|purge] [ID...] [-reason=REASON] [-site=DOMAIN]")
+		fmt.Println("\tgo run *.go quarantine purge [-older-than=720h|-all]")
+		fmt.Println()
+		fmt.Println("Crawls also seed from each site's sitemaps (disable with -sitemaps=false).")
+		fmt.Println()
+		fmt.Println("Any command that fetches pages can store responses with -record=DIR")
+		fmt.Println("and later run offline against them with -replay=DIR.")
+		fmt.Println()
+		fmt.Println("Requests identify as", scraper.UserAgent, "(override with -user-agent=UA)")
+		fmt.Println("and URLs disallowed by robots.txt are skipped.")
+		fmt.Println()
+		fmt.Println("Sites, seeds, selectors and rate limits are read from sites.yaml")
+		fmt.Println("(override with -sites=PATH; .json files are also accepted).")
+		fmt.Println()
+		fmt.Println("New recipes are indexed in bulk batches (-bulk-size=500, -bulk-flush=5s)")
+		fmt.Println("and backed up to recipe_backups, or to a snapshot with -backup-format=snapshot.")
+		fmt.Println()
+		fmt.Println("Recipes that fail validation, or whose confidence is below -min-confidence=0.2,")
+		fmt.Println("go to the recipes_quarantine index with their HTML in quarantine_html.")
+		fmt.Println()
+		fmt.Println("Crawl progress is stored in crawl_frontier.db (override with -frontier=PATH)")
+		fmt.Println("and interrupted crawls resume from it automatically.")
 		return
 	}
 
@@ -637,11 +1439,67 @@ func main() {
 			crawlDelayPerDomain = time.Duration(delay * float64(time.Second))
 		} else if strings.HasPrefix(arg, "-max-requests=") {
 			fmt.Sscanf(arg[14:], "%d", &maxRequestsPerDomain)
+		} else if strings.HasPrefix(arg, "-max-pages-per-site=") {
+			fmt.Sscanf(arg[20:], "%d", &maxPagesPerSite)
 		} else if strings.HasPrefix(arg, "-debug=") {
 			fmt.Sscanf(arg[7:], "%t", &debugMode)
+		} else if strings.HasPrefix(arg, "-sitemaps=") {
+			fmt.Sscanf(arg[10:], "%t", &useSitemaps)
+		} else if strings.HasPrefix(arg, "-sitemap-limit=") {
+			fmt.Sscanf(arg[15:], "%d", &maxSitemapURLs)
+		} else if strings.HasPrefix(arg, "-user-agent=") {
+			scraper.SetUserAgent(arg[12:])
+		} else if strings.HasPrefix(arg, "-record=") {
+			recordDir = arg[8:]
+		} else if strings.HasPrefix(arg, "-replay=") {
+			replayDir = arg[8:]
+		} else if strings.HasPrefix(arg, "-sites=") {
+			sitesPath = arg[7:]
+		} else if strings.HasPrefix(arg, "-frontier=") {
+			frontierPath = arg[10:]
+		} else if strings.HasPrefix(arg, "-recrawl-interval=") {
+			if d, err := time.ParseDuration(arg[18:]); err == nil && d > 0 {
+				recrawlInterval = d
+			}
+		} else if strings.HasPrefix(arg, "-refresh-limit=") {
+			fmt.Sscanf(arg[15:], "%d", &maxRefreshPerSite)
+		} else if strings.HasPrefix(arg, "-timeout=") {
+			if d, err := time.ParseDuration(arg[9:]); err == nil {
+				crawlTimeout = d
+			}
+		} else if strings.HasPrefix(arg, "-bulk-size=") {
+			fmt.Sscanf(arg[11:], "%d", &elasticsearch.BulkActions)
+		} else if strings.HasPrefix(arg, "-bulk-flush=") {
+			if d, err := time.ParseDuration(arg[12:]); err == nil && d > 0 {
+				elasticsearch.BulkFlushInterval = d
+			}
+		} else if arg == "-dry-run" {
+			restoreDryRun = true
+		} else if strings.HasPrefix(arg, "-dry-run=") {
+			fmt.Sscanf(arg[9:], "%t", &restoreDryRun)
+		} else if strings.HasPrefix(arg, "-site=") {
+			restoreSite = arg[6:]
+		} else if arg == "-incremental" {
+			snapshotIncremental = true
+		} else if strings.HasPrefix(arg, "-snapshot-dir=") {
+			snapshotDir = arg[14:]
+		} else if strings.HasPrefix(arg, "-backup-format=") {
+			backupFormat = arg[15:]
+		} else if strings.HasPrefix(arg, "-min-confidence=") {
+			fmt.Sscanf(arg[16:], "%g", &minConfidence)
+		} else if strings.HasPrefix(arg, "-quarantine-dir=") {
+			quarantineDir = arg[16:]
+		} else if strings.HasPrefix(arg, "-older-than=") {
+			if d, err := time.ParseDuration(arg[12:]); err == nil {
+				pruneOlderThan = d
+			}
 		}
 	}
 
+	if !loadSites() || !configureFetcher() {
+		return
+	}
+
 	switch args[1] {
 	case "recipes":
 		startRecipeCrawling()
@@ -661,7 +1519,9 @@ func main() {
 		logger.WriteInfo(fmt.Sprintf("  Max Depth: %d", maxCrawlDepth))
 		logger.WriteInfo(fmt.Sprintf("  Delay: %v", crawlDelayPerDomain))
 		logger.WriteInfo(fmt.Sprintf("  Max Requests Per Domain: %d", maxRequestsPerDomain))
+		logger.WriteInfo(fmt.Sprintf("  Max Pages Per Site: %d", maxPagesPerSite))
 		logger.WriteInfo(fmt.Sprintf("  Debug Mode: %t", debugMode))
+		logger.WriteInfo(fmt.Sprintf("  User-Agent: %s", scraper.UserAgent))
 		logger.WriteInfo(fmt.Sprintf("  Starting URLs: %v", startURLs))
 
 		// Start crawling each URL
@@ -669,13 +1529,131 @@ func main() {
 		fmt.Printf("Max crawl depth: %d\n", maxCrawlDepth)
 		startCrawling(startURLs)
 
-		// Print summary
-		var crawledCount int
-		crawledURLs.Range(func(_, _ interface{}) bool {
-			crawledCount++
-			return true
-		})
-		fmt.Printf("Crawling completed. Processed %d URLs.\n", crawledCount)
+	case "serve":
+		runServe()
+
+	case "frontier":
+		runFrontierCommand(args[2:])
+
+	case "verify-fixtures":
+		dir := fixtures.DefaultDir
+		update := false
+		for _, arg := range args[2:] {
+			if arg == "--update" || arg == "-update" {
+				update = true
+			} else if !strings.HasPrefix(arg, "-") {
+				dir = arg
+			}
+		}
+		if !runVerifyFixtures(dir, update) {
+			os.Exit(1)
+		}
+
+	case "capture-fixture":
+		if len(args) > 2 && (args[2] == "--all" || args[2] == "-all") {
+			dir := fixtures.DefaultDir
+			for _, arg := range args[3:] {
+				if !strings.HasPrefix(arg, "-") {
+					dir = arg
+				}
+			}
+			if !runRecaptureFixtures(dir) {
+				os.Exit(1)
+			}
+			return
+		}
+		if len(args) < 3 || strings.HasPrefix(args[2], "-") {
+			fmt.Println("Please provide a URL to capture")
+			return
+		}
+		f, err := fixtures.Capture(fixtures.DefaultDir, args[2])
+		if err != nil {
+			fmt.Println("Failed to capture fixture:", err)
+			return
+		}
+		fmt.Printf("Saved fixture %s and golden file %s\n", f.HTMLPath, f.GoldenPath)
+
+	case "sitemap":
+		if len(args) < 3 || strings.HasPrefix(args[2], "-") {
+			fmt.Println("Please provide a domain, e.g. go run *.go sitemap www.allrecipes.com")
+			return
+		}
+		runSitemapCommand(args[2])
+
+	case "dedupe":
+		checkIndexPresence()
+		updated, groups, err := elasticsearch.RegroupDuplicates()
+		if err != nil {
+			logger.WriteError(fmt.Sprintf("Failed to regroup duplicates: %v", err))
+			fmt.Println("Failed to regroup duplicates:", err)
+			return
+		}
+		logger.WriteInfo(fmt.Sprintf("Regrouped duplicates: %d documents updated, %d duplicate groups", updated, groups))
+		fmt.Printf("Updated %d documents, found %d groups of near-duplicate recipes\n", updated, groups)
+
+	case "migrate":
+		ok := false
+		switch {
+		case len(args) >= 3 && args[2] == "status":
+			ok = printIndexVersions()
+		case len(args) >= 3 && args[2] == "rollback":
+			ok = runMigrateRollback()
+		case len(args) >= 3 && !strings.HasPrefix(args[2], "-"):
+			fmt.Println("Unknown migrate command, valid options are: status, rollback")
+		default:
+			ok = runMigrate()
+		}
+		if !ok {
+			os.Exit(1)
+		}
+
+	case "migrate-ids":
+		runMigrateIDs()
+
+	case "backfill":
+		checkIndexPresence()
+		updated, err := elasticsearch.BackfillDerivedFields()
+		if err != nil {
+			logger.WriteError(fmt.Sprintf("Failed to backfill derived fields: %v", err))
+			fmt.Println("Failed to backfill derived fields:", err)
+			os.Exit(1)
+		}
+		logger.WriteInfo(fmt.Sprintf("Backfilled numeric fields and ingredient items on %d recipes", updated))
+		fmt.Printf("Updated numeric fields and ingredient items on %d recipes\n", updated)
+
+	case "quarantine":
+		if !runQuarantineCommand(args[2:]) {
+			os.Exit(1)
+		}
+
+	case "restore":
+		dir := backupDir
+		if len(args) >= 3 && !strings.HasPrefix(args[2], "-") {
+			dir = args[2]
+		}
+		if !runRestore(dir) {
+			os.Exit(1)
+		}
+
+	case "snapshot":
+		ok := false
+		switch {
+		case len(args) >= 3 && args[2] == "convert":
+			dir := backupDir
+			if len(args) >= 4 && !strings.HasPrefix(args[3], "-") {
+				dir = args[3]
+			}
+			ok = runSnapshotConvert(dir)
+		case len(args) >= 3 && args[2] == "list":
+			ok = runSnapshotList()
+		case len(args) >= 3 && !strings.HasPrefix(args[2], "-"):
+			fmt.Println("Unknown snapshot command, valid options are: convert, list")
+		default:
+			ok = runSnapshot()
+		}
+		if !ok {
+			os.Exit(1)
+		}
 
 	case "delete":
 		deleteIndex()
@@ -703,7 +1681,8 @@ func main() {
 		fmt.Println("Scraper created successfully")
 
 		// Extract recipe data
-		recipeData := s.GetRecipeData()
+		extracted := s.GetRecipeData()
+		recipeData := extracted.Values
 
 		// Print recipe data
 		fmt.Println("Recipe Data:")
@@ -716,11 +1695,23 @@ func main() {
 		fmt.Printf("  Total Time: %s\n", recipeData["total_time"])
 		fmt.Printf("  Calories: %s\n", recipeData["calories"])
 		fmt.Printf("  Servings: %s\n", recipeData["servings"])
-
-		// Print ingredients
+		fmt.Printf("  Categories: %s\n", recipeData["categories"])
+		fmt.Printf("  Cuisines: %s\n", recipeData["cuisines"])
+		fmt.Printf("  Keywords: %s\n", recipeData["keywords"])
+		fmt.Printf("  Diets: %s\n", recipeData["diets"])
+		fmt.Printf("  Author: %s\n", recipeData["author"])
+		fmt.Printf("  Rating: %s (%s ratings)\n", recipeData["rating_value"], recipeData["rating_count"])
+		fmt.Printf("  Video: %s\n", recipeData["video_url"])
+		fmt.Printf("  Recipe plugin: %s\n", recipeData["ingredient_plugin"])
+
+		// Print ingredients, under the plugin's group headings
 		fmt.Println("  Ingredients:")
 		ingredients := strings.Split(recipeData["ingredients"], ";")
+		groups := strings.Split(recipeData["ingredient_groups"], ";")
 		for i, ingredient := range ingredients {
+			if i < len(groups) && groups[i] != "" && (i == 0 || groups[i] != groups[i-1]) {
+				fmt.Printf("    %s\n", groups[i])
+			}
 			fmt.Printf("    %d. %s\n", i+1, ingredient)
 		}
 
@@ -731,10 +1722,32 @@ func main() {
 			fmt.Printf("    %d. %s\n", i+1, instruction)
 		}
 
+		// Print where each field came from
+		fmt.Println("  Field sources:")
+		fields := make([]string, 0, len(extracted.Extraction))
+		for field := range extracted.Extraction {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			source := extracted.Extraction[field]
+			fmt.Printf("    %s: %s (%.2f)\n", field, source.Source, source.Confidence)
+		}
+		candidate := recipePage(s.CanonicalURL(), s, extracted, time.Now())
+		fmt.Printf("  Recipe confidence: %.2f\n", *candidate.Confidence)
+		if reason := candidate.QuarantineReason(minConfidence); reason != "" {
+			fmt.Printf("  Would be quarantined: %s\n", reason)
+		}
+
 		// Check if this is a recipe listing or detail page
 		fmt.Println("\nURL Analysis:")
+		fmt.Printf("  Canonical URL: %s\n", s.CanonicalURL())
 		fmt.Printf("  Is recipe listing page: %t\n", isRecipeListingPage(testURL))
 		fmt.Printf("  Is likely recipe page: %t\n", isLikelyRecipePage(testURL))
+		fmt.Printf("  Allowed by robots.txt: %t\n", scraper.Robots.Allowed(testURL))
+		if delay := scraper.Robots.CrawlDelay(testURL); delay > 0 {
+			fmt.Printf("  Robots.txt crawl delay: %v\n", delay)
+		}
 
 		// Check minimum data requirements
 		hasName := recipeData["name"] != "" || recipeData["title"] != ""
@@ -778,6 +1791,6 @@ func main() {
 
 	default:
 		fmt.Println("Unknown option:", args[1])
-		fmt.Println("Valid options are: recipes, index, delete, test-url")
+		fmt.Println("Valid options are: recipes, index, serve, dedupe, migrate, migrate-ids, backfill, quarantine, restore, snapshot, delete, test-url, frontier, sitemap, verify-fixtures, capture-fixture")
 	}
 }
\ No newline at end of file
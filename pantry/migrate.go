@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+
+	"search-engine-indexer/src/elasticsearch"
+	"search-engine-indexer/src/logger"
+)
+
+// runMigrate moves the recipes to an index built from the current mapping
+// and swaps the alias over to it
+func runMigrate() bool {
+	elasticsearch.NewElasticSearchClient()
+
+	result, err := elasticsearch.Migrate()
+	if err != nil {
+		logger.WriteError(fmt.Sprintf("Failed to migrate index: %v", err))
+		fmt.Println("Failed to migrate index:", err)
+		return false
+	}
+	if result.From == result.To {
+		fmt.Printf("%s already points at %s, nothing to migrate\n", elasticsearch.IndexName, result.To)
+		return true
+	}
+
+	logger.WriteInfo(fmt.Sprintf("Migrated %d recipes from %s to %s (%d caught up during the copy)",
+		result.Docs, result.From, result.To, result.CaughtUp))
+	fmt.Printf("Migrated %d recipes from %s to %s (%d caught up during the copy)\n",
+		result.Docs, result.From, result.To, result.CaughtUp)
+	return true
+}
+
+// runMigrateRollback points the alias back at the previous index version
+func runMigrateRollback() bool {
+	elasticsearch.NewElasticSearchClient()
+
+	from, to, err := elasticsearch.Rollback()
+	if err != nil {
+		logger.WriteError(fmt.Sprintf("Failed to roll back index: %v", err))
+		fmt.Println("Failed to roll back index:", err)
+		return false
+	}
+	fmt.Printf("%s rolled back from %s to %s\n", elasticsearch.IndexName, from, to)
+	return true
+}
+
+// printIndexVersions lists every version of the recipe index
+func printIndexVersions() bool {
+	elasticsearch.NewElasticSearchClient()
+
+	versions, legacy, err := elasticsearch.IndexVersions()
+	if err != nil {
+		fmt.Println("Failed to list index versions:", err)
+		return false
+	}
+
+	fmt.Printf("Mapping version %d (%s)\n", elasticsearch.MappingVersion, elasticsearch.VersionedIndex(elasticsearch.MappingVersion))
+	if legacy {
+		fmt.Printf("  %-16s unversioned, run \"migrate\" to move it behind the alias\n", elasticsearch.IndexName)
+	}
+	for _, version := range versions {
+		live := ""
+		if version.Live {
+			live = " <- " + elasticsearch.IndexName
+		}
+		fmt.Printf("  %-16s %8d recipes%s\n", version.Name, version.Docs, live)
+	}
+	if !legacy && len(versions) == 0 {
+		fmt.Println("  No recipe indices")
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"search-engine-indexer/src/canonical"
+	"search-engine-indexer/src/elasticsearch"
+	"search-engine-indexer/src/logger"
+	"search-engine-indexer/src/structs"
+)
+
+// runMigrateIDs moves every recipe in the index and in the backup
+// directory to the ID derived from its canonical URL. Retired IDs are kept
+// in the alias index so old links can be redirected.
+func runMigrateIDs() {
+	checkIndexPresence()
+
+	aliases, err := elasticsearch.MigrateIDs()
+	if err != nil {
+		logger.WriteError(fmt.Sprintf("Failed to migrate recipe IDs: %v", err))
+		fmt.Println("Failed to migrate recipe IDs:", err)
+		return
+	}
+	logger.WriteInfo(fmt.Sprintf("Migrated %d recipe IDs, aliases stored in %s", len(aliases), elasticsearch.AliasIndexName))
+	fmt.Printf("Migrated %d recipe IDs, aliases stored in %s\n", len(aliases), elasticsearch.AliasIndexName)
+
+	renamed, err := migrateBackupIDs(aliases)
+	if err != nil {
+		logger.WriteError(fmt.Sprintf("Failed to migrate backups in %s: %v", backupDir, err))
+		fmt.Println("Failed to migrate backups:", err)
+		return
+	}
+	logger.WriteInfo(fmt.Sprintf("Rewrote %d backup files in %s", renamed, backupDir))
+	fmt.Printf("Rewrote %d backup files in %s\n", renamed, backupDir)
+}
+
+// migrateBackupIDs rewrites backup files under their URL-derived ID and
+// renames duplicate groups using aliases. When two backups end up with the
+// same name the most recently crawled one is kept.
+func migrateBackupIDs(aliases map[string]string) (int, error) {
+	files, err := filepath.Glob(filepath.Join(backupDir, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+
+	rewritten := 0
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return rewritten, err
+		}
+
+		var page structs.Page
+		if err := json.Unmarshal(data, &page); err != nil || page.URL == "" {
+			logger.WriteWarning(fmt.Sprintf("Skipping backup %s, not a recipe", file))
+			continue
+		}
+
+		newID := canonical.ID(page.URL)
+		newGroup, regrouped := aliases[page.DuplicateGroup]
+		if newID == page.ID && !regrouped {
+			continue
+		}
+		page.ID = newID
+		if regrouped {
+			page.DuplicateGroup = newGroup
+		}
+
+		target := backupFilename(page)
+		if target != file {
+			if existing, ok := readBackup(target); ok && existing.CrawlDate.After(page.CrawlDate) {
+				if err := os.Remove(file); err != nil {
+					return rewritten, err
+				}
+				rewritten++
+				continue
+			}
+		}
+
+		jsonData, err := json.MarshalIndent(page, "", "  ")
+		if err != nil {
+			return rewritten, err
+		}
+		if err := os.WriteFile(target, jsonData, 0644); err != nil {
+			return rewritten, err
+		}
+		if target != file {
+			if err := os.Remove(file); err != nil {
+				return rewritten, err
+			}
+		}
+		rewritten++
+	}
+
+	return rewritten, nil
+}
+
+// readBackup reads a backup file, reporting false if it doesn't exist or
+// can't be parsed
+func readBackup(file string) (structs.Page, bool) {
+	var page structs.Page
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return page, false
+	}
+	if err := json.Unmarshal(data, &page); err != nil {
+		return page, false
+	}
+	return page, true
+}
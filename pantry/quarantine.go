@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"search-engine-indexer/src/canonical"
+	"search-engine-indexer/src/elasticsearch"
+	"search-engine-indexer/src/logger"
+	"search-engine-indexer/src/scraper"
+	"search-engine-indexer/src/sites"
+	"search-engine-indexer/src/structs"
+)
+
+var (
+	// Recipes whose name, ingredients or instructions are trusted less
+	// than this are quarantined rather than indexed
+	minConfidence = 0.2
+
+	// The HTML of every quarantined recipe is kept here, stored as -record
+	// would store it so the directory can also be replayed
+	quarantineDir = "quarantine_html"
+
+	// Only list, retry or purge recipes quarantined for this reason, from
+	// this domain and its subdomains, or quarantined longer ago than this
+	quarantineReason    string
+	quarantineSite      string
+	quarantineOlderThan time.Duration
+
+	// Purge every quarantined recipe
+	quarantineAll bool
+)
+
+// quarantineRecipe holds back a recipe that failed validation, keeping the
+// response it was extracted from so it can be retried
+func quarantineRecipe(p structs.Page, reason string, response *scraper.Response) {
+	htmlRef, err := scraper.StoreResponse(quarantineDir, response)
+	if err != nil {
+		logger.WriteWarning(fmt.Sprintf("Failed to save HTML of quarantined recipe %s: %v", p.URL, err))
+	}
+
+	if err := elasticsearch.QuarantinePage(p, reason, htmlRef); err != nil {
+		logger.WriteError(fmt.Sprintf("Failed to quarantine recipe %s: %v", p.URL, err))
+		return
+	}
+	logger.WriteWarning(fmt.Sprintf("Quarantined recipe %s (%s): %s", p.ID, reason, p.URL))
+}
+
+// releaseQuarantined drops the quarantine entry of a recipe that now
+// passes validation, if it has one. The entry is only looked up while
+// something is quarantined.
+func releaseQuarantined(id string) {
+	if !elasticsearch.AnyQuarantined() {
+		return
+	}
+
+	entry, err := elasticsearch.GetQuarantined(id)
+	if err != nil {
+		logger.WriteWarning(fmt.Sprintf("Failed to check quarantine for %s: %v", id, err))
+		return
+	}
+	if entry != nil {
+		removeQuarantined(*entry)
+	}
+}
+
+// removeQuarantined deletes a quarantine entry and its stored HTML
+func removeQuarantined(entry elasticsearch.QuarantinedRecipe) bool {
+	if err := elasticsearch.RemoveQuarantined(entry.ID); err != nil {
+		logger.WriteError(fmt.Sprintf("Failed to remove quarantined recipe %s: %v", entry.ID, err))
+		return false
+	}
+
+	if entry.HTMLRef != "" {
+		if err := scraper.RemoveStoredResponse(entry.HTMLRef); err != nil {
+			logger.WriteWarning(fmt.Sprintf("Failed to remove HTML of quarantined recipe %s: %v", entry.ID, err))
+		}
+	}
+	return true
+}
+
+// runQuarantineCommand lists, retries, promotes or purges quarantined
+// recipes. Commands act on the recipe IDs given, or else on every recipe
+// matching -reason and -site.
+func runQuarantineCommand(args []string) bool {
+	action := "list"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action = args[0]
+		args = args[1:]
+	}
+
+	ids := []string{}
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "-reason="):
+			quarantineReason = arg[8:]
+		case strings.HasPrefix(arg, "-site="):
+			quarantineSite = arg[6:]
+		case strings.HasPrefix(arg, "-older-than="):
+			if d, err := time.ParseDuration(arg[12:]); err == nil {
+				quarantineOlderThan = d
+			}
+		case arg == "-all":
+			quarantineAll = true
+		case !strings.HasPrefix(arg, "-"):
+			ids = append(ids, arg)
+		}
+	}
+
+	// Retried and promoted recipes are written to the pages index
+	switch action {
+	case "list", "purge":
+		if elasticsearch.NewElasticSearchClient() == nil {
+			fmt.Println("Failed to connect to Elasticsearch")
+			return false
+		}
+	case "retry", "promote":
+		checkIndexPresence()
+	default:
+		fmt.Println("Unknown quarantine command, valid options are: list, retry, promote, purge")
+		return false
+	}
+
+	if action == "promote" && len(ids) == 0 {
+		fmt.Println("Please provide the IDs of the recipes to promote")
+		return false
+	}
+	if action == "purge" && len(ids) == 0 && !quarantineAll && quarantineOlderThan == 0 &&
+		quarantineReason == "" && quarantineSite == "" {
+		fmt.Println("Please provide the IDs of the recipes to purge, or -older-than=DUR, -reason=REASON, -site=DOMAIN or -all")
+		return false
+	}
+
+	entries, ok := quarantineEntries(ids)
+	if !ok {
+		return false
+	}
+
+	switch action {
+	case "list":
+		for _, entry := range entries {
+			confidence := "-"
+			if entry.Page.Confidence != nil {
+				confidence = fmt.Sprintf("%.2f", *entry.Page.Confidence)
+			}
+			fmt.Printf("[%s, attempts %d, confidence %s, %s] %s %s\n",
+				entry.Reason, entry.Attempts, confidence, entry.QuarantinedAt.Format(time.RFC3339), entry.ID, entry.URL)
+			if entry.HTMLRef != "" {
+				fmt.Println("  html:", entry.HTMLRef)
+			}
+		}
+		fmt.Printf("%d quarantined recipes\n", len(entries))
+
+	case "retry":
+		released := 0
+		for _, entry := range entries {
+			if retryQuarantined(entry) {
+				released++
+			}
+		}
+		fmt.Printf("Indexed %d of %d quarantined recipes\n", released, len(entries))
+
+	case "promote":
+		promoted := 0
+		for _, entry := range entries {
+			if !storeRecipe(entry.Page) {
+				fmt.Printf("  failed to index %s: %s\n", entry.ID, entry.URL)
+				continue
+			}
+			if removeQuarantined(entry) {
+				promoted++
+			}
+		}
+		fmt.Printf("Promoted %d of %d quarantined recipes\n", promoted, len(entries))
+
+	case "purge":
+		var cutoff time.Time
+		if quarantineOlderThan > 0 {
+			cutoff = time.Now().Add(-quarantineOlderThan)
+		}
+		purged := 0
+		for _, entry := range entries {
+			if !cutoff.IsZero() && entry.QuarantinedAt.After(cutoff) {
+				continue
+			}
+			if removeQuarantined(entry) {
+				purged++
+			}
+		}
+		fmt.Printf("Purged %d quarantined recipes\n", purged)
+	}
+
+	return true
+}
+
+// quarantineEntries returns the quarantined recipes with the given IDs, or
+// every one matching -reason and -site when no IDs are given
+func quarantineEntries(ids []string) ([]elasticsearch.QuarantinedRecipe, bool) {
+	entries := []elasticsearch.QuarantinedRecipe{}
+
+	if len(ids) > 0 {
+		for _, id := range ids {
+			entry, err := elasticsearch.GetQuarantined(id)
+			if err != nil {
+				fmt.Printf("Failed to read quarantined recipe %s: %v\n", id, err)
+				return nil, false
+			}
+			if entry == nil {
+				fmt.Println("Recipe is not quarantined:", id)
+				continue
+			}
+			entries = append(entries, *entry)
+		}
+		return entries, true
+	}
+
+	all, err := elasticsearch.QuarantinedRecipes(quarantineReason)
+	if err != nil {
+		fmt.Println("Failed to list quarantined recipes:", err)
+		return nil, false
+	}
+	for _, entry := range all {
+		if quarantineMatchesSite(entry.URL) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, true
+}
+
+// quarantineMatchesSite reports whether a recipe URL passes the -site
+// filter
+func quarantineMatchesSite(pageURL string) bool {
+	if quarantineSite == "" {
+		return true
+	}
+
+	parsedURL, err := url.Parse(pageURL)
+	if err != nil {
+		return false
+	}
+	return sites.MatchesDomain(canonical.Host(parsedURL.Hostname()), canonical.Host(quarantineSite))
+}
+
+// retryQuarantined re-extracts a quarantined recipe from its stored HTML
+// with the current site definitions. A recipe that now passes validation
+// is indexed and leaves quarantine; one that still fails has its reason
+// and attempts updated.
+func retryQuarantined(entry elasticsearch.QuarantinedRecipe) bool {
+	body, err := os.ReadFile(entry.HTMLRef)
+	if err != nil {
+		fmt.Printf("  no stored HTML for %s: %v\n", entry.ID, err)
+		return false
+	}
+
+	s, err := scraper.NewScraperFromBody(entry.URL, body)
+	if err != nil {
+		fmt.Printf("  failed to parse HTML for %s: %v\n", entry.ID, err)
+		return false
+	}
+
+	p := recipePage(entry.URL, s, s.GetRecipeData(), time.Now())
+	p.ID = entry.ID
+	if reason := p.QuarantineReason(minConfidence); reason != "" {
+		if err := elasticsearch.QuarantinePage(p, reason, entry.HTMLRef); err != nil {
+			logger.WriteError(fmt.Sprintf("Failed to update quarantined recipe %s: %v", entry.ID, err))
+		}
+		fmt.Printf("  still quarantined (%s): %s\n", reason, entry.URL)
+		return false
+	}
+
+	if !storeRecipe(p) {
+		fmt.Printf("  failed to index %s: %s\n", entry.ID, entry.URL)
+		return false
+	}
+	fmt.Printf("  indexed %s: %s\n", p.ID, p.URL)
+	return removeQuarantined(entry)
+}
+
+// storeRecipe writes a recipe leaving quarantine to the pages index,
+// updating the stored copy if the URL is already indexed
+func storeRecipe(p structs.Page) bool {
+	if exists, current := elasticsearch.PageByURL(p.URL); exists {
+		params := recipeParams(p)
+		params["last_changed"] = time.Now()
+		return elasticsearch.UpdatePage(current.ID, params)
+	}
+
+	if !elasticsearch.CreatePage(p) {
+		return false
+	}
+	saveRecipeToFile(p)
+	return true
+}
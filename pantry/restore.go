@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"search-engine-indexer/src/canonical"
+	"search-engine-indexer/src/elasticsearch"
+	"search-engine-indexer/src/logger"
+	"search-engine-indexer/src/sites"
+	"search-engine-indexer/src/snapshot"
+	"search-engine-indexer/src/structs"
+)
+
+var (
+	// Validate and report without writing to Elasticsearch
+	restoreDryRun bool
+
+	// Only restore recipes from this domain and its subdomains
+	restoreSite string
+)
+
+// restoreCandidate is the backup file chosen for one canonical URL
+type restoreCandidate struct {
+	file      string
+	crawlDate time.Time
+}
+
+// restoreReport collects what happened to every backup file
+type restoreReport struct {
+	// snapshots is set when restoring from snapshots, where files counts
+	// records rather than backup files
+	snapshots bool
+
+	files    int
+	filtered int
+	corrupt  map[string]string
+
+	// rejected maps each file, or record, whose recipe fails the checks
+	// that would quarantine it during a crawl to the reason. These are
+	// left out rather than indexed.
+	rejected map[string]string
+
+	// duplicates maps each skipped file to the ID of its URL, and chosen
+	// holds the file kept for every ID
+	duplicates map[string]string
+	chosen     map[string]restoreCandidate
+
+	restored int
+	existing int
+	failed   int
+}
+
+// runRestore loads the recipe backups in dir into the index. Files are read
+// twice: once to validate them and pick the newest backup of each URL, and
+// once to load the chosen ones, so only one recipe is in memory at a time.
+// A directory of snapshots is restored from its segments instead.
+func runRestore(dir string) bool {
+	if snapshot.IsSnapshotDir(dir) {
+		return runRestoreSnapshots(dir)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		fmt.Println("Failed to list backups:", err)
+		return false
+	}
+	if len(files) == 0 {
+		fmt.Printf("No backup files found in %s\n", dir)
+		return false
+	}
+
+	// First pass: validate every file and keep the newest copy of each URL
+	report := chooseBackups(files)
+
+	if restoreDryRun {
+		report.restored = len(report.chosen)
+		printRestoreReport(report, true)
+		return len(report.corrupt) == 0
+	}
+
+	if !startRestoreIndexer(&report) {
+		return false
+	}
+
+	// Second pass: load the chosen files
+	for _, candidate := range report.chosen {
+		page, err := readRestoreFile(candidate.file)
+		if err != nil {
+			report.corrupt[candidate.file] = err.Error()
+			continue
+		}
+		elasticsearch.RestorePage(page)
+	}
+	elasticsearch.StopBulkIndexer()
+
+	logger.WriteInfo(fmt.Sprintf("Restored %d recipes from %s (%d already indexed, %d failed, %d corrupt, %d duplicate files)",
+		report.restored, dir, report.existing, report.failed, len(report.corrupt), len(report.duplicates)))
+	printRestoreReport(report, false)
+	return report.failed == 0 && len(report.corrupt) == 0
+}
+
+// runRestoreSnapshots loads the recipes in a directory of snapshots into the
+// index. Snapshots are read newest first so the latest copy of each recipe
+// is the one restored and older copies are counted as duplicates.
+func runRestoreSnapshots(dir string) bool {
+	manifests, err := snapshot.List(dir)
+	if err != nil {
+		fmt.Println("Failed to read snapshots:", err)
+		return false
+	}
+
+	report := restoreReport{
+		snapshots:  true,
+		corrupt:    make(map[string]string),
+		rejected:   make(map[string]string),
+		duplicates: make(map[string]string),
+		chosen:     make(map[string]restoreCandidate),
+	}
+
+	if !restoreDryRun && !startRestoreIndexer(&report) {
+		return false
+	}
+
+	for i := len(manifests) - 1; i >= 0; i-- {
+		for _, segment := range manifests[i].Segments {
+			if err := snapshot.Verify(dir, segment); err != nil {
+				report.corrupt[segment.File] = err.Error()
+				continue
+			}
+
+			err := snapshot.ReadSegment(dir, segment, func(page structs.Page) error {
+				report.files++
+				record := fmt.Sprintf("%s: %s", segment.File, page.URL)
+				if err := validateRestorePage(page); err != nil {
+					report.corrupt[record] = err.Error()
+					return nil
+				}
+				if !restoreMatchesSite(page.URL) {
+					report.filtered++
+					return nil
+				}
+				if reason := page.QuarantineReason(minConfidence); reason != "" {
+					report.rejected[record] = reason
+					return nil
+				}
+
+				id := canonical.ID(page.URL)
+				if _, ok := report.chosen[id]; ok {
+					report.duplicates[record] = id
+					return nil
+				}
+				report.chosen[id] = restoreCandidate{file: segment.File, crawlDate: page.CrawlDate}
+
+				if !restoreDryRun {
+					elasticsearch.RestorePage(page)
+				}
+				return nil
+			})
+			if err != nil {
+				report.corrupt[segment.File] = err.Error()
+			}
+		}
+	}
+
+	if restoreDryRun {
+		report.restored = len(report.chosen)
+		printRestoreReport(report, true)
+		return len(report.corrupt) == 0
+	}
+	elasticsearch.StopBulkIndexer()
+
+	logger.WriteInfo(fmt.Sprintf("Restored %d recipes from snapshots in %s (%d already indexed, %d failed, %d corrupt, %d duplicate records)",
+		report.restored, dir, report.existing, report.failed, len(report.corrupt), len(report.duplicates)))
+	printRestoreReport(report, false)
+	return report.failed == 0 && len(report.corrupt) == 0
+}
+
+// chooseBackups validates every backup file and picks the newest copy of
+// each canonical URL
+func chooseBackups(files []string) restoreReport {
+	sort.Strings(files)
+
+	report := restoreReport{
+		files:      len(files),
+		corrupt:    make(map[string]string),
+		rejected:   make(map[string]string),
+		duplicates: make(map[string]string),
+		chosen:     make(map[string]restoreCandidate),
+	}
+
+	for _, file := range files {
+		page, err := readRestoreFile(file)
+		if err != nil {
+			report.corrupt[file] = err.Error()
+			continue
+		}
+		if !restoreMatchesSite(page.URL) {
+			report.filtered++
+			continue
+		}
+
+		// An older copy that passes is restored in place of a newer one
+		// that doesn't
+		if reason := page.QuarantineReason(minConfidence); reason != "" {
+			report.rejected[file] = reason
+			continue
+		}
+
+		id := canonical.ID(page.URL)
+		if current, ok := report.chosen[id]; ok {
+			if !page.CrawlDate.After(current.crawlDate) {
+				report.duplicates[file] = id
+				continue
+			}
+			report.duplicates[current.file] = id
+		}
+		report.chosen[id] = restoreCandidate{file: file, crawlDate: page.CrawlDate}
+	}
+
+	return report
+}
+
+// startRestoreIndexer starts the bulk indexer, counting each outcome in
+// report
+func startRestoreIndexer(report *restoreReport) bool {
+	checkIndexPresence()
+
+	var mu sync.Mutex
+	err := elasticsearch.StartBulkIndexer(func(result elasticsearch.BulkResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case result.Created:
+			report.restored++
+		case result.Duplicate:
+			report.existing++
+		default:
+			report.failed++
+		}
+	})
+	if err != nil {
+		logger.WriteError(fmt.Sprintf("Failed to start bulk indexer: %v", err))
+		fmt.Println("Failed to start bulk indexer:", err)
+		return false
+	}
+	return true
+}
+
+// readRestoreFile reads a backup file and checks it holds a usable recipe
+func readRestoreFile(file string) (structs.Page, error) {
+	var page structs.Page
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return page, err
+	}
+	if err := json.Unmarshal(data, &page); err != nil {
+		return page, fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	return page, validateRestorePage(page)
+}
+
+// validateRestorePage checks a backed up page can be read as a recipe.
+// Whether it is good enough to index is QuarantineReason's call.
+func validateRestorePage(page structs.Page) error {
+	parsedURL, err := url.Parse(page.URL)
+	switch {
+	case page.URL == "":
+		return fmt.Errorf("missing url")
+	case err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "":
+		return fmt.Errorf("url %q is not an absolute http(s) URL", page.URL)
+	case page.Title == "" && page.Name == "":
+		return fmt.Errorf("missing title and name")
+	}
+
+	return nil
+}
+
+// restoreMatchesSite reports whether a recipe URL passes the -site filter
+func restoreMatchesSite(pageURL string) bool {
+	if restoreSite == "" {
+		return true
+	}
+
+	parsedURL, err := url.Parse(pageURL)
+	if err != nil {
+		return false
+	}
+	return sites.MatchesDomain(canonical.Host(parsedURL.Hostname()), canonical.Host(restoreSite))
+}
+
+// printRestoreReport prints the totals followed by every corrupt and
+// duplicate file
+func printRestoreReport(report restoreReport, dryRun bool) {
+	if dryRun {
+		fmt.Println("Dry run, nothing was written")
+	}
+	if report.snapshots {
+		fmt.Printf("  Snapshot records:  %d\n", report.files)
+	} else {
+		fmt.Printf("  Backup files:      %d\n", report.files)
+	}
+	if dryRun {
+		fmt.Printf("  Would restore:     %d\n", report.restored)
+	} else {
+		fmt.Printf("  Restored:          %d\n", report.restored)
+		fmt.Printf("  Already indexed:   %d\n", report.existing)
+		fmt.Printf("  Failed:            %d\n", report.failed)
+	}
+	if restoreSite != "" {
+		fmt.Printf("  Other sites:       %d\n", report.filtered)
+	}
+	fmt.Printf("  Corrupt files:     %d\n", len(report.corrupt))
+	fmt.Printf("  Failed validation: %d\n", len(report.rejected))
+	if report.snapshots {
+		fmt.Printf("  Duplicate records: %d\n", len(report.duplicates))
+	} else {
+		fmt.Printf("  Duplicate files:   %d\n", len(report.duplicates))
+	}
+
+	if len(report.corrupt) > 0 {
+		fmt.Println("\nCorrupt files:")
+		for _, file := range sortedKeys(report.corrupt) {
+			fmt.Printf("  %s: %s\n", file, report.corrupt[file])
+		}
+	}
+
+	if len(report.rejected) > 0 {
+		fmt.Println("\nFailed validation, not restored:")
+		for _, file := range sortedKeys(report.rejected) {
+			fmt.Printf("  %s: %s\n", file, report.rejected[file])
+		}
+	}
+
+	if len(report.duplicates) > 0 {
+		fmt.Println("\nDuplicates (same canonical URL as a newer backup):")
+		for _, file := range sortedKeys(report.duplicates) {
+			fmt.Printf("  %s (kept %s)\n", file, filepath.Base(report.chosen[report.duplicates[file]].file))
+		}
+	}
+}
+
+// sortedKeys returns the keys of a string map in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"search-engine-indexer/src/frontier"
+	"search-engine-indexer/src/logger"
+	"search-engine-indexer/src/scraper"
+	"search-engine-indexer/src/sites"
+)
+
+var (
+	// Recrawl interval for sites that don't set recrawl_interval
+	recrawlInterval = 24 * time.Hour
+
+	// Most stale recipes re-checked per site on each scheduled run
+	maxRefreshPerSite = 1000
+
+	// How often the scheduler looks for sites that are due
+	scheduleTick = time.Minute
+)
+
+// runServe runs the crawler as a long-lived daemon. Workers keep pulling
+// from the frontier while a scheduler re-checks each site's seeds, sitemaps
+// and stale recipes every recrawl interval. Never-seen URLs are always
+// crawled before refreshes. SIGINT or SIGTERM lets the workers finish the
+// URL they are on before exiting.
+func runServe() {
+	checkIndexPresence()
+
+	if !openFrontier() {
+		return
+	}
+	defer crawlFrontier.Close()
+
+	if resumed, err := crawlFrontier.Requeue(frontier.StateInFlight); err != nil {
+		logger.WriteError(fmt.Sprintf("Failed to requeue in-flight URLs: %v", err))
+	} else if resumed > 0 {
+		logger.WriteInfo(fmt.Sprintf("Resuming %d interrupted URLs from %s", resumed, frontierPath))
+	}
+	crawlFrontier.SiteBudget = maxPagesPerSite
+
+	logger.WriteInfo(fmt.Sprintf("Serving with %d workers, default recrawl interval %v", concurrentWorkers, recrawlInterval))
+	fmt.Printf("Serving with %d workers, press Ctrl+C to stop\n", concurrentWorkers)
+
+	startBulkIndexer()
+	startBackups()
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(concurrentWorkers)
+	for i := 1; i <= concurrentWorkers; i++ {
+		go worker(&wg, i, stop, false)
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	// Every site is due on startup; recently checked pages are skipped by
+	// the stale check so a restart only re-reads seeds and sitemaps
+	scheduled := make(chan struct{})
+	go func() {
+		defer close(scheduled)
+
+		ticker := time.NewTicker(scheduleTick)
+		defer ticker.Stop()
+
+		nextRun := make(map[string]time.Time)
+		for {
+			scheduleRecrawls(nextRun, stop)
+			logHostRates(true)
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	signals := notifyShutdown()
+	sig := <-signals
+	logger.WriteInfo(fmt.Sprintf("Received %v, shutting down", sig))
+	fmt.Println("Shutting down, waiting for in-flight URLs...")
+	stopWorkers(stop, finished, signals)
+	<-scheduled
+	stopBulkIndexer()
+	stopBackups()
+
+	counts := crawlFrontier.Counts()
+	logger.WriteInfo(fmt.Sprintf("Stopped with %d URLs pending", counts[frontier.StatePending]))
+}
+
+// scheduleRecrawls queues work for every site whose recrawl interval has
+// passed
+func scheduleRecrawls(nextRun map[string]time.Time, stop <-chan struct{}) {
+	now := time.Now()
+
+	for i := range siteConfig.Sites {
+		site := &siteConfig.Sites[i]
+		if len(site.Seeds) == 0 || now.Before(nextRun[site.Domain]) {
+			continue
+		}
+		if stopped(stop) {
+			return
+		}
+
+		interval := siteRecrawlInterval(site)
+		refreshSite(site, interval, stop)
+		nextRun[site.Domain] = now.Add(interval)
+	}
+}
+
+// siteRecrawlInterval returns the site's recrawl interval, falling back to
+// -recrawl-interval
+func siteRecrawlInterval(site *sites.Site) time.Duration {
+	if site.RecrawlInterval > 0 {
+		return time.Duration(site.RecrawlInterval)
+	}
+	return recrawlInterval
+}
+
+// refreshSite queues a site's seeds, any sitemap URLs that are new or whose
+// lastmod is newer than our last check, and recipes not checked within
+// interval. Sitemap walking is cut short once stop is closed.
+func refreshSite(site *sites.Site, interval time.Duration, stop <-chan struct{}) {
+	added, refreshed := 0, 0
+
+	// queue adds a never-seen URL or queues a known one for a re-check
+	queue := func(item frontier.Item, refresh bool) {
+		err := crawlFrontier.Add(item)
+		if err == nil {
+			added++
+			return
+		}
+		if !errors.Is(err, frontier.ErrKnown) || !refresh {
+			return
+		}
+		if ok, _ := crawlFrontier.Refresh(item.URL, item.LastMod); ok {
+			refreshed++
+		}
+	}
+
+	// Seeds are listing pages, so they are re-checked every run to pick up
+	// new recipes. Conditional requests keep this cheap.
+	for _, seed := range site.Seeds {
+		queue(seedItem(seed), true)
+	}
+
+	if useSitemaps {
+		for _, domain := range sitemapDomains(site.Seeds) {
+			walkRecipeSitemaps(domain, func(entry scraper.SitemapEntry) bool {
+				if stopped(stop) {
+					return false
+				}
+
+				item := frontier.Item{
+					URL:     entry.URL,
+					Parent:  entry.Sitemap,
+					Site:    frontierSite(entry.URL),
+					LastMod: entry.LastMod,
+				}
+
+				changed := false
+				if !entry.LastMod.IsZero() {
+					state, seen := crawlFrontier.Page(entry.URL)
+					changed = !seen || entry.LastMod.After(state.LastChecked)
+				}
+				queue(item, changed)
+				return true
+			})
+		}
+	}
+
+	if stopped(stop) {
+		return
+	}
+
+	stale := crawlFrontier.Stale(time.Now().Add(-interval), maxRefreshPerSite, func(entry frontier.Entry) bool {
+		return sites.MatchesDomain(entry.Site, site.Domain) && isLikelyRecipePage(entry.URL)
+	})
+	for _, entry := range stale {
+		if ok, _ := crawlFrontier.Refresh(entry.URL, time.Time{}); ok {
+			refreshed++
+		}
+	}
+
+	logger.WriteInfo(fmt.Sprintf("Scheduled %s: %d new URLs, %d re-checks (%d stale), next run in %v",
+		site.Domain, added, refreshed, len(stale), interval))
+}
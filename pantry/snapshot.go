@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"search-engine-indexer/src/elasticsearch"
+	"search-engine-indexer/src/logger"
+	"search-engine-indexer/src/snapshot"
+	"search-engine-indexer/src/structs"
+)
+
+var (
+	// Directory snapshots are written to and read from
+	snapshotDir = snapshot.DefaultDir
+
+	// Only snapshot recipes changed since the last snapshot
+	snapshotIncremental bool
+
+	// How crawls back up new recipes: "files" writes one JSON file per
+	// recipe to backupDir, "snapshot" appends them to a snapshot
+	backupFormat = "files"
+
+	// Snapshot receiving this crawl's new recipes in snapshot backup mode
+	crawlSnapshot *snapshot.Writer
+)
+
+// runSnapshot writes the recipes in the index to a new snapshot, or only
+// those changed since the last snapshot with -incremental
+func runSnapshot() bool {
+	opts := snapshot.Options{Source: snapshot.SourceIndex, Until: time.Now().UTC()}
+
+	if snapshotIncremental {
+		base, err := snapshot.LatestBase(snapshotDir)
+		if err != nil {
+			fmt.Println("Failed to read snapshots:", err)
+			return false
+		}
+		if base == nil {
+			fmt.Printf("No complete snapshot in %s to build on, taking a full snapshot\n", snapshotDir)
+		} else {
+			opts.Incremental = true
+			opts.Base = base.ID
+			opts.Since = base.Until
+		}
+	}
+
+	checkIndexPresence()
+
+	w, err := snapshot.Create(snapshotDir, opts)
+	if err != nil {
+		fmt.Println("Failed to create snapshot:", err)
+		return false
+	}
+
+	err = elasticsearch.EachPage(opts.Since, w.Write)
+	manifest, closeErr := w.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		logger.WriteError(fmt.Sprintf("Snapshot %s failed: %v", w.ID(), err))
+		fmt.Println("Snapshot failed:", err)
+		return false
+	}
+
+	logger.WriteInfo(fmt.Sprintf("Wrote snapshot %s with %d recipes in %d segments", manifest.ID, manifest.Count, len(manifest.Segments)))
+	printManifest(manifest)
+	return true
+}
+
+// runSnapshotConvert writes the per-file backups in dir to a new snapshot,
+// keeping the newest backup of each canonical URL. The backup files are
+// left in place.
+func runSnapshotConvert(dir string) bool {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil || len(files) == 0 {
+		fmt.Printf("No backup files found in %s\n", dir)
+		return false
+	}
+
+	report := chooseBackups(files)
+
+	// Write oldest first so segments cover consecutive time ranges
+	candidates := make([]restoreCandidate, 0, len(report.chosen))
+	for _, candidate := range report.chosen {
+		candidates = append(candidates, candidate)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].crawlDate.Equal(candidates[j].crawlDate) {
+			return candidates[i].file < candidates[j].file
+		}
+		return candidates[i].crawlDate.Before(candidates[j].crawlDate)
+	})
+
+	var until time.Time
+	if len(candidates) > 0 {
+		until = candidates[len(candidates)-1].crawlDate
+	}
+
+	w, err := snapshot.Create(snapshotDir, snapshot.Options{Source: snapshot.SourceBackups, Until: until})
+	if err != nil {
+		fmt.Println("Failed to create snapshot:", err)
+		return false
+	}
+
+	for _, candidate := range candidates {
+		page, readErr := readRestoreFile(candidate.file)
+		if readErr != nil {
+			report.corrupt[candidate.file] = readErr.Error()
+			continue
+		}
+		if err = w.Write(page); err != nil {
+			break
+		}
+	}
+	manifest, closeErr := w.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		logger.WriteError(fmt.Sprintf("Converting %s to snapshot %s failed: %v", dir, w.ID(), err))
+		fmt.Println("Conversion failed:", err)
+		return false
+	}
+
+	logger.WriteInfo(fmt.Sprintf("Converted %d backup files from %s to snapshot %s (%d recipes, %d corrupt, %d duplicate files)",
+		report.files, dir, manifest.ID, manifest.Count, len(report.corrupt), len(report.duplicates)))
+	printManifest(manifest)
+	fmt.Printf("  Backup files:      %d\n", report.files)
+	fmt.Printf("  Corrupt files:     %d\n", len(report.corrupt))
+	fmt.Printf("  Duplicate files:   %d\n", len(report.duplicates))
+	for _, file := range sortedKeys(report.corrupt) {
+		fmt.Printf("  %s: %s\n", file, report.corrupt[file])
+	}
+	return len(report.corrupt) == 0
+}
+
+// runSnapshotList prints every snapshot in the snapshot directory and
+// verifies their segment checksums
+func runSnapshotList() bool {
+	manifests, err := snapshot.List(snapshotDir)
+	if err != nil {
+		fmt.Println("Failed to read snapshots:", err)
+		return false
+	}
+	if len(manifests) == 0 {
+		fmt.Printf("No snapshots in %s\n", snapshotDir)
+		return true
+	}
+
+	ok := true
+	for _, manifest := range manifests {
+		printManifest(manifest)
+		for _, segment := range manifest.Segments {
+			if err := snapshot.Verify(snapshotDir, segment); err != nil {
+				fmt.Printf("  CORRUPT: %s: %v\n", segment.File, err)
+				ok = false
+			}
+		}
+		fmt.Println()
+	}
+	return ok
+}
+
+// printManifest prints a one-snapshot summary
+func printManifest(m *snapshot.Manifest) {
+	kind := "full"
+	if m.Incremental {
+		kind = "incremental since " + m.Since.Format(time.RFC3339)
+	}
+	status := ""
+	if !m.Complete {
+		status = " (incomplete)"
+	}
+
+	fmt.Printf("Snapshot %s%s: %s, from %s\n", m.ID, status, kind, m.Source)
+	fmt.Printf("  Recipes:           %d in %d segments\n", m.Count, len(m.Segments))
+	if m.Count > 0 {
+		fmt.Printf("  Changed:           %s to %s\n", m.From.Format(time.RFC3339), m.To.Format(time.RFC3339))
+	}
+}
+
+// startBackups opens this crawl's snapshot when backing up to snapshots.
+// If it can't be created recipes are backed up to files instead.
+func startBackups() {
+	if backupFormat != "snapshot" {
+		return
+	}
+
+	opts := snapshot.Options{Source: snapshot.SourceCrawl, Incremental: true, Since: time.Now().UTC()}
+	if manifests, err := snapshot.List(snapshotDir); err == nil && len(manifests) > 0 {
+		opts.Base = manifests[len(manifests)-1].ID
+	}
+
+	w, err := snapshot.Create(snapshotDir, opts)
+	if err != nil {
+		logger.WriteWarning(fmt.Sprintf("Failed to create backup snapshot, backing up to %s: %v", backupDir, err))
+		return
+	}
+	crawlSnapshot = w
+	logger.WriteInfo(fmt.Sprintf("Backing up new recipes to snapshot %s in %s", w.ID(), snapshotDir))
+}
+
+// stopBackups finishes this crawl's snapshot
+func stopBackups() {
+	if crawlSnapshot == nil {
+		return
+	}
+
+	manifest, err := crawlSnapshot.Close()
+	crawlSnapshot = nil
+	if err != nil {
+		logger.WriteError(fmt.Sprintf("Failed to finish backup snapshot %s: %v", manifest.ID, err))
+		return
+	}
+	logger.WriteInfo(fmt.Sprintf("Backed up %d new recipes to snapshot %s", manifest.Count, manifest.ID))
+}
+
+// saveRecipeToSnapshot appends a recipe to this crawl's snapshot, falling
+// back to a backup file if the write fails
+func saveRecipeToSnapshot(page structs.Page) bool {
+	if err := crawlSnapshot.Write(page); err != nil {
+		logger.WriteError(fmt.Sprintf("Failed to write recipe to snapshot %s: %v", crawlSnapshot.ID(), err))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,222 @@
+package canonical
+
+// URL canonicalization so the same recipe isn't crawled or indexed twice
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+)
+
+// trackingParams are query parameters that never change the page content
+var trackingParams = map[string]bool{
+	"fbclid":     true,
+	"gclid":      true,
+	"gclsrc":     true,
+	"dclid":      true,
+	"msclkid":    true,
+	"yclid":      true,
+	"igshid":     true,
+	"mc_cid":     true,
+	"mc_eid":     true,
+	"_ga":        true,
+	"_gl":        true,
+	"replytocom": true,
+}
+
+// variantParams select print or AMP renderings of the same page
+var variantParams = map[string]bool{
+	"amp":   true,
+	"print": true,
+}
+
+// variantSegments are trailing path segments for print and AMP renderings,
+// e.g. /chicken-soup/print/ or /chicken-soup/amp/
+var variantSegments = map[string]bool{
+	"amp":   true,
+	"print": true,
+}
+
+// URL returns the canonical form of a URL, used to recognise variants of
+// the same page and to derive its ID. It's a key, not an address: pages
+// are fetched at the URL they were found under, since a site may not serve
+// the canonical host or scheme. The canonical form is:
+//   - https, lower-case host without www. or amp., no default port
+//   - no fragment, tracking parameters or print/AMP parameters
+//   - remaining query parameters sorted
+//   - no duplicate or trailing slashes, and no /amp/ or /print/ variant segments
+//
+// Strings that don't parse as absolute http(s) URLs are returned unchanged.
+func URL(raw string) string {
+	parsedURL, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || parsedURL.Host == "" {
+		return raw
+	}
+
+	scheme := strings.ToLower(parsedURL.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return raw
+	}
+
+	// The http and https copies of a page are treated as the same page
+	parsedURL.Scheme = "https"
+	parsedURL.User = nil
+	parsedURL.Host = Host(parsedURL.Host)
+	parsedURL.Fragment = ""
+	parsedURL.RawFragment = ""
+
+	parsedURL.Path = cleanPath(parsedURL.Path)
+	parsedURL.RawPath = ""
+	parsedURL.RawQuery = cleanQuery(parsedURL.Query())
+
+	return parsedURL.String()
+}
+
+// ID returns the document ID for a recipe URL: the first 16 hex digits of
+// the SHA-256 of its canonical form, so re-crawling a page always produces
+// the same ID
+func ID(raw string) string {
+	sum := sha256.Sum256([]byte(URL(raw)))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Host lower-cases a host and drops the www. or amp. prefix and any
+// default port
+func Host(host string) string {
+	host = strings.ToLower(host)
+	host = strings.TrimSuffix(host, ":443")
+	host = strings.TrimSuffix(host, ":80")
+	host = strings.TrimPrefix(host, "www.")
+	host = strings.TrimPrefix(host, "amp.")
+	return host
+}
+
+// cleanPath collapses slashes, removes print and AMP segments and the
+// trailing slash
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+
+	segments := strings.Split(path.Clean("/"+p), "/")
+	kept := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		if segment == "" {
+			continue
+		}
+		lower := strings.ToLower(segment)
+		// Trailing /amp or /print, and a leading /amp/ prefix
+		if variantSegments[lower] && (i == len(segments)-1 || (lower == "amp" && len(kept) == 0)) {
+			continue
+		}
+		kept = append(kept, segment)
+	}
+
+	return "/" + strings.Join(kept, "/")
+}
+
+// cleanQuery drops tracking and variant parameters and sorts the rest
+func cleanQuery(values url.Values) string {
+	for key := range values {
+		lower := strings.ToLower(key)
+		if strings.HasPrefix(lower, "utm_") || trackingParams[lower] || variantParams[lower] {
+			values.Del(key)
+		}
+	}
+
+	if len(values) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		for _, value := range values[key] {
+			parts = append(parts, url.QueryEscape(key)+"="+url.QueryEscape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// Absolute resolves href against the page it was found on, dropping the
+// fragment but otherwise leaving it as written, so it can be fetched. It
+// returns an empty string for links that aren't http(s).
+func Absolute(base, href string) string {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return ""
+	}
+	ref, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return ""
+	}
+
+	resolved := baseURL.ResolveReference(ref)
+	if resolved.Scheme != "http" && resolved.Scheme != "https" {
+		return ""
+	}
+	resolved.Fragment = ""
+	resolved.RawFragment = ""
+
+	return resolved.String()
+}
+
+// Resolve resolves href against the page it was found on and
+// canonicalizes the result. It returns an empty string for links that
+// aren't http(s).
+func Resolve(base, href string) string {
+	resolved := Absolute(base, href)
+	if resolved == "" {
+		return ""
+	}
+	return URL(resolved)
+}
+
+// Declared picks the canonical URL a page declares through
+// <link rel="canonical"> or og:url. The declaration is ignored unless it is
+// on the same site as the page and, for anything but a home page, points
+// somewhere other than the home page, since some sites declare their home
+// page as canonical for everything. The page URL's canonical form is
+// returned when nothing usable is declared.
+func Declared(pageURL string, declared ...string) string {
+	page := URL(pageURL)
+	pageParsed, err := url.Parse(page)
+	if err != nil {
+		return page
+	}
+
+	for _, candidate := range declared {
+		if strings.TrimSpace(candidate) == "" {
+			continue
+		}
+
+		resolved := Resolve(page, candidate)
+		if resolved == "" {
+			continue
+		}
+		parsed, err := url.Parse(resolved)
+		if err != nil || !sameSite(parsed.Host, pageParsed.Host) {
+			continue
+		}
+		if parsed.Path == "/" && pageParsed.Path != "/" {
+			continue
+		}
+
+		return resolved
+	}
+
+	return page
+}
+
+// sameSite reports whether two canonical hosts belong to the same site,
+// allowing for one being a subdomain of the other
+func sameSite(a, b string) bool {
+	return a == b || strings.HasSuffix(a, "."+b) || strings.HasSuffix(b, "."+a)
+}
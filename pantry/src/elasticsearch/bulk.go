@@ -0,0 +1,224 @@
+package elasticsearch
+
+// Batched recipe indexing through olivere's BulkProcessor
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"search-engine-indexer/src/canonical"
+	"search-engine-indexer/src/fingerprint"
+	"search-engine-indexer/src/logger"
+	"search-engine-indexer/src/structs"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	elastic "github.com/olivere/elastic/v7"
+)
+
+// Bulk indexing settings, set before StartBulkIndexer
+var (
+	BulkActions       = 500
+	BulkFlushInterval = 5 * time.Second
+	BulkWorkers       = 2
+)
+
+// BulkResult is the outcome of one queued page. Duplicate means a document
+// with the page's ID, and so the same canonical URL, was already indexed.
+type BulkResult struct {
+	Page      structs.Page
+	Created   bool
+	Duplicate bool
+	Err       error
+}
+
+// IndexStats counts the outcome of pages written through CreatePage
+type IndexStats struct {
+	Queued     int64
+	Created    int64
+	Duplicates int64
+	Failed     int64
+}
+
+var (
+	bulkProcessor *elastic.BulkProcessor
+	bulkOnResult  func(BulkResult)
+
+	// Pages queued but not yet acknowledged, by document ID, in the order
+	// they were queued. A print variant and its canonical page can share an
+	// ID within one batch, and each gets the result of its own request.
+	bulkPending   = make(map[string][]structs.Page)
+	bulkPendingMu sync.Mutex
+
+	indexStats IndexStats
+)
+
+// StartBulkIndexer starts the bulk processor. From then on CreatePage
+// queues documents instead of writing them one at a time, and onResult is
+// called for every page once its batch has been written.
+func StartBulkIndexer(onResult func(BulkResult)) error {
+	processor, err := client.BulkProcessor().
+		Name("recipe-indexer").
+		Workers(BulkWorkers).
+		BulkActions(BulkActions).
+		FlushInterval(BulkFlushInterval).
+		After(bulkAfter).
+		Do(context.Background())
+	if err != nil {
+		return err
+	}
+
+	bulkProcessor = processor
+	bulkOnResult = onResult
+	logger.WriteInfo(fmt.Sprintf("Bulk indexing in batches of %d, flushed every %v", BulkActions, BulkFlushInterval))
+	return nil
+}
+
+// StopBulkIndexer flushes any queued documents, waits for them to be
+// written and stops the bulk processor
+func StopBulkIndexer() {
+	if bulkProcessor == nil {
+		return
+	}
+
+	if err := bulkProcessor.Flush(); err != nil {
+		logger.WriteWarning(fmt.Sprintf("Failed to flush bulk indexer: %v", err))
+	}
+	if err := bulkProcessor.Close(); err != nil {
+		logger.WriteWarning(fmt.Sprintf("Failed to stop bulk indexer: %v", err))
+	}
+	bulkProcessor = nil
+}
+
+// Stats returns how many pages have been queued, created, skipped as
+// already indexed and failed
+func Stats() IndexStats {
+	return IndexStats{
+		Queued:     atomic.LoadInt64(&indexStats.Queued),
+		Created:    atomic.LoadInt64(&indexStats.Created),
+		Duplicates: atomic.LoadInt64(&indexStats.Duplicates),
+		Failed:     atomic.LoadInt64(&indexStats.Failed),
+	}
+}
+
+// RestorePage queues a page read back from a backup. Unlike CreatePage it
+// doesn't validate the recipe, so callers leave out pages with a
+// QuarantineReason first. It only canonicalizes the URL, fills in the ID, source site, fingerprint and
+// duplicate group when the backup predates them, and parses the numeric
+// fields. The bulk indexer must be running.
+func RestorePage(p structs.Page) {
+	p.URL = canonical.URL(p.URL)
+	p.ID = canonical.ID(p.URL)
+	p.SourceSite = extractSourceSite(p.URL)
+
+	if len(p.Fingerprint) == 0 {
+		p.Fingerprint = fingerprint.New(p.Ingredients, p.Instructions).Bands()
+	}
+	if p.DuplicateGroup == "" {
+		p.DuplicateGroup = p.ID
+	}
+	if p.LastChanged.IsZero() {
+		p.LastChanged = p.CrawlDate
+	}
+	p.SetNumericFields()
+	p.SetIngredientItems()
+
+	queuePage(p)
+}
+
+// queuePage adds a prepared page to the bulk processor. op_type=create
+// makes Elasticsearch reject a page whose URL-derived ID already exists,
+// so no search is needed beforehand.
+func queuePage(p structs.Page) {
+	bulkPendingMu.Lock()
+	bulkPending[p.ID] = append(bulkPending[p.ID], p)
+	bulkPendingMu.Unlock()
+
+	atomic.AddInt64(&indexStats.Queued, 1)
+	bulkProcessor.Add(elastic.NewBulkIndexRequest().
+		Index(IndexName).
+		OpType("create").
+		Id(p.ID).
+		Doc(p))
+}
+
+// bulkAfter reports the outcome of every document in a committed batch
+func bulkAfter(executionID int64, requests []elastic.BulkableRequest, response *elastic.BulkResponse, err error) {
+	// The whole batch failed, even after retries
+	if err != nil {
+		logger.WriteError(fmt.Sprintf("Bulk request %d with %d documents failed: %v", executionID, len(requests), err))
+		for _, request := range requests {
+			reportBulkResult(bulkRequestID(request), http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	for _, items := range response.Items {
+		for _, item := range items {
+			reason := ""
+			if item.Error != nil {
+				reason = item.Error.Reason
+			}
+			reportBulkResult(item.Id, item.Status, reason)
+		}
+	}
+}
+
+// reportBulkResult updates the stats and calls the result callback for
+// the oldest queued page with a document ID. Responses come back in the
+// order the requests were sent.
+func reportBulkResult(id string, status int, reason string) {
+	bulkPendingMu.Lock()
+	queued := bulkPending[id]
+	if len(queued) == 0 {
+		bulkPendingMu.Unlock()
+		return
+	}
+	p := queued[0]
+	if len(queued) == 1 {
+		delete(bulkPending, id)
+	} else {
+		bulkPending[id] = queued[1:]
+	}
+	bulkPendingMu.Unlock()
+
+	result := BulkResult{Page: p}
+	switch {
+	case status >= 200 && status < 300:
+		result.Created = true
+		atomic.AddInt64(&indexStats.Created, 1)
+		logger.WriteInfo(fmt.Sprintf("Successfully created new recipe - Title: %s, URL: %s", p.Title, p.URL))
+	case status == http.StatusConflict:
+		result.Duplicate = true
+		atomic.AddInt64(&indexStats.Duplicates, 1)
+		logger.WriteWarning(fmt.Sprintf("URL already exists in database: %s", p.URL))
+	default:
+		result.Err = fmt.Errorf("status %d: %s", status, reason)
+		atomic.AddInt64(&indexStats.Failed, 1)
+		logger.WriteWarning(fmt.Sprintf("Failed to create the page %s: %v", p.URL, result.Err))
+	}
+
+	if bulkOnResult != nil {
+		bulkOnResult(result)
+	}
+}
+
+// bulkRequestID reads the document ID from a request's action line
+func bulkRequestID(request elastic.BulkableRequest) string {
+	lines, err := request.Source()
+	if err != nil || len(lines) == 0 {
+		return ""
+	}
+
+	var action map[string]struct {
+		ID string `json:"_id"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &action); err != nil {
+		return ""
+	}
+	for _, meta := range action {
+		return meta.ID
+	}
+	return ""
+}
@@ -0,0 +1,105 @@
+package elasticsearch
+
+// Fields derived from a recipe's strings: numeric time, calorie and
+// servings fields, and the ingredients split into nested items
+import (
+	"context"
+	"reflect"
+	"search-engine-indexer/src/structs"
+
+	elastic "github.com/olivere/elastic/v7"
+)
+
+// numericFields maps each numeric field to the string field it's parsed from
+var numericFields = map[string]string{
+	"prep_minutes":  "prep_time",
+	"cook_minutes":  "cook_time",
+	"total_minutes": "total_time",
+	"calories_kcal": "calories",
+	"servings_min":  "servings",
+	"servings_max":  "servings",
+}
+
+// numericValues parses a page's numeric fields and returns them by name.
+// Fields that couldn't be parsed are nil, so an update clears a stale value.
+func numericValues(p structs.Page) map[string]interface{} {
+	p.SetNumericFields()
+	return map[string]interface{}{
+		"prep_minutes":  p.PrepMinutes,
+		"cook_minutes":  p.CookMinutes,
+		"total_minutes": p.TotalMinutes,
+		"calories_kcal": p.CaloriesKcal,
+		"servings_min":  p.ServingsMin,
+		"servings_max":  p.ServingsMax,
+	}
+}
+
+// setNumericParams adds the numeric fields for whichever time, calorie and
+// servings strings an update changes
+func setNumericParams(params map[string]interface{}) {
+	p := structs.Page{}
+	p.PrepTime, _ = params["prep_time"].(string)
+	p.CookTime, _ = params["cook_time"].(string)
+	p.TotalTime, _ = params["total_time"].(string)
+	p.Calories, _ = params["calories"].(string)
+	p.Servings, _ = params["servings"].(string)
+
+	for field, value := range numericValues(p) {
+		if _, ok := params[numericFields[field]]; ok {
+			params[field] = value
+		}
+	}
+}
+
+// ingredientItems splits an updated ingredients string into nested items.
+// The placeholder gives nil, which clears stale items.
+func ingredientItems(ingredients string) []structs.RecipeIngredient {
+	p := structs.Page{Ingredients: ingredients}
+	p.SetIngredientItems()
+	return p.IngredientItems
+}
+
+// BackfillDerivedFields parses the time, calorie, servings and ingredient
+// strings of every stored recipe and writes the derived fields where
+// they're missing or out of date. It returns the number of documents
+// updated.
+func BackfillDerivedFields() (int, error) {
+	ctx := context.Background()
+	updated := 0
+	bulk := client.Bulk().Index(IndexName)
+
+	source := elastic.NewFetchSourceContext(true).Include(
+		"prep_time", "cook_time", "total_time", "calories", "servings", "ingredients",
+		"prep_minutes", "cook_minutes", "total_minutes", "calories_kcal", "servings_min", "servings_max",
+		"ingredient_items", "ingredient_plugin")
+	err := scrollPages(ctx, nil, source, func(id string, p structs.Page) error {
+		current := p
+		p.SetNumericFields()
+		p.SetIngredientItems()
+		if sameInt(p.PrepMinutes, current.PrepMinutes) && sameInt(p.CookMinutes, current.CookMinutes) &&
+			sameInt(p.TotalMinutes, current.TotalMinutes) && sameInt(p.CaloriesKcal, current.CaloriesKcal) &&
+			sameInt(p.ServingsMin, current.ServingsMin) && sameInt(p.ServingsMax, current.ServingsMax) &&
+			reflect.DeepEqual(p.IngredientItems, current.IngredientItems) {
+			return nil
+		}
+
+		doc := numericValues(p)
+		doc["ingredient_items"] = p.IngredientItems
+		doc["ingredient_plugin"] = p.IngredientPlugin
+		bulk.Add(elastic.NewBulkUpdateRequest().Id(id).Doc(doc))
+		updated++
+		return flushBulk(ctx, bulk, bulkBatchSize)
+	})
+	if err == nil {
+		err = flushBulk(ctx, bulk, 1)
+	}
+	return updated, err
+}
+
+// sameInt reports whether two optional ints are equal
+func sameInt(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
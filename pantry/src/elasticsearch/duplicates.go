@@ -0,0 +1,205 @@
+package elasticsearch
+
+// Near-duplicate detection using ingredient and instruction fingerprints
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"search-engine-indexer/src/canonical"
+	"search-engine-indexer/src/fingerprint"
+	"search-engine-indexer/src/logger"
+	"search-engine-indexer/src/structs"
+
+	elastic "github.com/olivere/elastic/v7"
+)
+
+// Most duplicate candidates compared for a new recipe
+const maxDuplicateCandidates = 20
+
+// duplicateMapping adds the fingerprint fields to indices created before
+// they existed
+const duplicateMapping = `{
+    "properties": {
+        "fingerprint": {
+            "type": "keyword"
+        },
+        "duplicate_group": {
+            "type": "keyword"
+        }
+    }
+}`
+
+// EnsureDuplicateMapping makes sure an existing index maps the fingerprint
+// fields as keywords
+func EnsureDuplicateMapping() {
+	_, err := client.PutMapping().
+		Index(IndexName).
+		BodyString(duplicateMapping).
+		Do(context.Background())
+	if err != nil {
+		logger.WriteWarning(fmt.Sprintf("Failed to update mapping for duplicate detection: %v", err))
+	}
+}
+
+// PageByURL returns the page stored under a URL, matching both its
+// canonical form and the URL as given
+func PageByURL(pageURL string) (bool, structs.Page) {
+	var p structs.Page
+
+	q := elastic.NewTermsQuery("url.keyword", canonical.URL(pageURL), pageURL)
+	result, err := client.Search().
+		Index(IndexName).
+		Query(q).
+		Size(1).
+		Do(context.Background())
+	if err != nil {
+		logger.WriteError(fmt.Sprintf("Failed to search for page by URL: %v", err))
+		return false, p
+	}
+	if len(result.Hits.Hits) == 0 {
+		return false, p
+	}
+
+	hit := result.Hits.Hits[0]
+	if err := json.Unmarshal(hit.Source, &p); err != nil {
+		logger.WriteError(fmt.Sprintf("Failed to unmarshal page: %v", err))
+		return false, p
+	}
+	p.ID = hit.Id
+	return true, p
+}
+
+// duplicateGroup returns the group of the closest recipe, other than id,
+// that is a near-duplicate of fp. It returns an empty string if there is
+// none. A matched recipe that has no group yet becomes the head of a new
+// group named after its own ID.
+func duplicateGroup(id string, fp fingerprint.Fingerprint) string {
+	bands := fp.Bands()
+	if len(bands) == 0 {
+		return ""
+	}
+
+	terms := make([]interface{}, len(bands))
+	for i, band := range bands {
+		terms[i] = band
+	}
+
+	q := elastic.NewBoolQuery().
+		Filter(elastic.NewTermsQuery("fingerprint", terms...))
+	if id != "" {
+		q = q.MustNot(elastic.NewIdsQuery().Ids(id))
+	}
+
+	result, err := client.Search().
+		Index(IndexName).
+		Query(q).
+		Size(maxDuplicateCandidates).
+		Do(context.Background())
+	if err != nil {
+		logger.WriteWarning(fmt.Sprintf("Failed to search for duplicate candidates: %v", err))
+		return ""
+	}
+
+	var best structs.Page
+	bestScore := -1.0
+	for _, hit := range result.Hits.Hits {
+		var candidate structs.Page
+		if err := json.Unmarshal(hit.Source, &candidate); err != nil {
+			continue
+		}
+		candidate.ID = hit.Id
+
+		candidateFP := fingerprint.New(candidate.Ingredients, candidate.Instructions)
+		if !fingerprint.Duplicate(fp, candidateFP) {
+			continue
+		}
+		if score, _ := fingerprint.Similarity(fp, candidateFP); score > bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+
+	if bestScore < 0 {
+		return ""
+	}
+
+	if best.DuplicateGroup == "" {
+		best.DuplicateGroup = best.ID
+		_, err := client.Update().
+			Index(IndexName).
+			Id(best.ID).
+			Doc(map[string]interface{}{"duplicate_group": best.DuplicateGroup}).
+			Do(context.Background())
+		if err != nil {
+			logger.WriteWarning(fmt.Sprintf("Failed to set duplicate group on %s: %v", best.ID, err))
+		}
+	}
+
+	logger.WriteInfo(fmt.Sprintf("Near-duplicate of %s (%s), group %s", best.ID, best.URL, best.DuplicateGroup))
+	return best.DuplicateGroup
+}
+
+// RegroupDuplicates fingerprints every stored recipe and assigns duplicate
+// groups from scratch, oldest recipes first so each group is named after
+// its earliest copy. It returns the number of documents updated and the
+// number of groups with more than one recipe.
+func RegroupDuplicates() (int, int, error) {
+	ctx := context.Background()
+	index := fingerprint.NewIndex()
+	groupOf := make(map[string]string)
+	sizes := make(map[string]int)
+
+	updated := 0
+	bulk := client.Bulk().Index(IndexName)
+
+	source := elastic.NewFetchSourceContext(true).Include("ingredients", "instructions", "fingerprint", "duplicate_group")
+	err := scrollPages(ctx, nil, source, func(id string, p structs.Page) error {
+		// Join the group of the closest earlier recipe, or start one
+		fp := fingerprint.New(p.Ingredients, p.Instructions)
+		group := id
+		if match, ok := index.Match(fp); ok {
+			group = groupOf[match]
+		}
+		index.Add(id, fp)
+		groupOf[id] = group
+		sizes[group]++
+
+		bands := fp.Bands()
+		if group == p.DuplicateGroup && sameStrings(bands, p.Fingerprint) {
+			return nil
+		}
+		bulk.Add(elastic.NewBulkUpdateRequest().Id(id).Doc(map[string]interface{}{
+			"fingerprint":     bands,
+			"duplicate_group": group,
+		}))
+		updated++
+
+		return flushBulk(ctx, bulk, bulkBatchSize)
+	})
+	if err == nil {
+		err = flushBulk(ctx, bulk, 1)
+	}
+	if err != nil {
+		return updated, 0, err
+	}
+
+	groups := 0
+	for _, size := range sizes {
+		if size > 1 {
+			groups++
+		}
+	}
+	return updated, groups, nil
+}
+
+// sameStrings reports whether two string slices are equal
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
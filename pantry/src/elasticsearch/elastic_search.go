@@ -7,18 +7,25 @@ import (
 	"fmt"
 	"net/url"
 	"regexp"
+	"search-engine-indexer/src/canonical"
+	"search-engine-indexer/src/fingerprint"
 	"search-engine-indexer/src/logger"
+	"search-engine-indexer/src/sites"
 	"search-engine-indexer/src/structs"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	elastic "github.com/olivere/elastic/v7"
 )
 
-// Update the IndexMapping in elasticsearch/elasticsearch.go
+// IndexName is the alias every service reads and writes recipes through.
+// It points at the versioned index holding the current mapping. Bump
+// MappingVersion whenever IndexMapping changes and run "pantry migrate".
 const (
-	IndexName    = "recipes"
-	IndexMapping = `{
+	IndexName      = "recipes"
+	MappingVersion = 7
+	IndexMapping   = `{
         "settings":{
             "number_of_shards":1,
             "number_of_replicas":0,
@@ -34,6 +41,20 @@ const (
             }
         },
         "mappings":{
+            "dynamic_templates": [
+                {
+                    "extraction_source": {
+                        "path_match": "extraction.*.source",
+                        "mapping": {"type": "keyword"}
+                    }
+                },
+                {
+                    "extraction_confidence": {
+                        "path_match": "extraction.*.confidence",
+                        "mapping": {"type": "float"}
+                    }
+                }
+            ],
             "properties":{
                 "title": {
                     "type": "text",
@@ -96,19 +117,144 @@ const (
                 "servings": {
                     "type": "text"
                 },
+                "prep_minutes": {
+                    "type": "integer"
+                },
+                "cook_minutes": {
+                    "type": "integer"
+                },
+                "total_minutes": {
+                    "type": "integer"
+                },
+                "calories_kcal": {
+                    "type": "integer"
+                },
+                "servings_min": {
+                    "type": "integer"
+                },
+                "servings_max": {
+                    "type": "integer"
+                },
                 "ingredients": {
                     "type": "text",
                     "analyzer": "recipe_analyzer"
                 },
+                "ingredient_items": {
+                    "type": "nested",
+                    "properties": {
+                        "original": {
+                            "type": "text",
+                            "analyzer": "recipe_analyzer"
+                        },
+                        "quantity": {
+                            "type": "keyword"
+                        },
+                        "quantity_min": {
+                            "type": "float"
+                        },
+                        "quantity_max": {
+                            "type": "float"
+                        },
+                        "unit": {
+                            "type": "keyword"
+                        },
+                        "ingredient": {
+                            "type": "text",
+                            "analyzer": "recipe_analyzer"
+                        },
+                        "preparation": {
+                            "type": "text"
+                        },
+                        "notes": {
+                            "type": "text"
+                        },
+                        "optional": {
+                            "type": "boolean"
+                        },
+                        "name": {
+                            "type": "keyword"
+                        },
+                        "group": {
+                            "type": "text"
+                        }
+                    }
+                },
+                "ingredient_plugin": {
+                    "type": "keyword"
+                },
                 "instructions": {
                     "type": "text",
                     "analyzer": "recipe_analyzer"
                 },
+                "categories": {
+                    "type": "text",
+                    "analyzer": "recipe_analyzer"
+                },
+                "cuisines": {
+                    "type": "keyword"
+                },
+                "keywords": {
+                    "type": "keyword"
+                },
+                "diets": {
+                    "type": "keyword"
+                },
+                "author": {
+                    "type": "text",
+                    "fields": {
+                        "keyword": {
+                            "type": "keyword",
+                            "ignore_above": 256
+                        }
+                    }
+                },
+                "rating_value": {
+                    "type": "float"
+                },
+                "rating_count": {
+                    "type": "integer"
+                },
+                "video_url": {
+                    "type": "keyword",
+                    "ignore_above": 2048
+                },
+                "notes": {
+                    "type": "text",
+                    "analyzer": "recipe_analyzer"
+                },
+                "nutrition": {
+                    "properties": {
+                        "serving_size": {"type": "keyword"},
+                        "fat": {"type": "keyword"},
+                        "saturated_fat": {"type": "keyword"},
+                        "cholesterol": {"type": "keyword"},
+                        "sodium": {"type": "keyword"},
+                        "carbohydrates": {"type": "keyword"},
+                        "fiber": {"type": "keyword"},
+                        "sugar": {"type": "keyword"},
+                        "protein": {"type": "keyword"}
+                    }
+                },
+                "extraction": {
+                    "type": "object"
+                },
+                "confidence": {
+                    "type": "float"
+                },
                 "source_site": {
                     "type": "keyword"
                 },
                 "crawl_date": {
                     "type": "date"
+                },
+                "last_changed": {
+                    "type": "date"
+                },
+                "fingerprint": {
+                    "type": "keyword"
+                },
+                "duplicate_group": {
+                    "type": "keyword"
                 }
             }
         }
@@ -173,11 +319,15 @@ func ExistsIndex(i string) bool {
 	return exists
 }
 
-// CreateIndex creates a new index
+// CreateIndex creates the index for the current mapping version and points
+// the alias i at it
 func CreateIndex(i string) {
-	createIndex, err := client.CreateIndex(IndexName).
+	ctx := context.Background()
+	name := VersionedIndex(MappingVersion)
+
+	createIndex, err := client.CreateIndex(name).
 		Body(IndexMapping).
-		Do(context.Background())
+		Do(ctx)
 	if err != nil {
 		logger.WriteError(fmt.Sprintf("Failed to create index: %v", err))
 		return
@@ -187,13 +337,37 @@ func CreateIndex(i string) {
 		logger.WriteWarning("CreateIndex was not acknowledged. Check that timeout value is correct.")
 	}
 
-	logger.WriteInfo(fmt.Sprintf("Created index %s successfully", i))
+	if _, err := client.Alias().Add(name, i).Do(ctx); err != nil {
+		logger.WriteError(fmt.Sprintf("Failed to point alias %s at %s: %v", i, name, err))
+		return
+	}
+
+	logger.WriteInfo(fmt.Sprintf("Created index %s behind alias %s successfully", name, i))
 }
 
-// DeleteIndex in the indexName constant
+// DeleteIndex deletes every version of the recipe index, including an
+// unversioned one from before aliases were used
 func DeleteIndex() {
 	ctx := context.Background()
-	deleteIndex, err := client.DeleteIndex(IndexName).Do(ctx)
+	versions, legacy, err := IndexVersions()
+	if err != nil {
+		logger.WriteError(fmt.Sprintf("Failed to list indices: %v", err))
+		return
+	}
+
+	names := make([]string, 0, len(versions)+1)
+	for _, version := range versions {
+		names = append(names, version.Name)
+	}
+	if legacy {
+		names = append(names, IndexName)
+	}
+	if len(names) == 0 {
+		logger.WriteInfo(fmt.Sprintf("No %s indices to delete", IndexName))
+		return
+	}
+
+	deleteIndex, err := client.DeleteIndex(names...).Do(ctx)
 	if err != nil {
 		// Handle error
 		logger.WriteError(fmt.Sprintf("Failed to delete index: %v", err))
@@ -202,7 +376,7 @@ func DeleteIndex() {
 	if !deleteIndex.Acknowledged {
 		logger.WriteWarning("DeleteIndex was not acknowledged. Check that timeout value is correct.")
 	}
-	logger.WriteInfo(fmt.Sprintf("Index %s deleted", IndexName))
+	logger.WriteInfo(fmt.Sprintf("Indices %s deleted", strings.Join(names, ", ")))
 }
 
 // Helper function to extract domain from URL path
@@ -232,121 +406,37 @@ func extractSourceSite(urlStr string) string {
 	return parsedURL.Host
 }
 
-// isValidRecipeURL checks if a URL is a valid recipe URL
-func isValidRecipeURL(rawURL string) bool {
-	// List of allowed recipe domains - expanded
-	allowedDomains := []string{
-		"www.delish.com",
-		"www.allrecipes.com",
-		"allrecipes.com", // Some may not have www prefix
-		"www.foodnetwork.com",
-		"www.epicurious.com",
-		"www.simplyrecipes.com",
-		"www.bonappetit.com",
-		"www.taste.com.au",
-		"www.bbcgoodfood.com",
-		"www.eatingwell.com",
-		"www.seriouseats.com",
-		"cooking.nytimes.com",
-		"www.tasteofhome.com",
-		"www.food.com",
-		"www.yummly.com",
-		"thepioneerwoman.com",
-		"minimalistbaker.com",
-		"pinchofyum.com",
-		"www.budgetbytes.com",
-		"sallysbakingaddiction.com",
-		"www.recipetineats.com",
-		"www.gimmesomeoven.com",
-		"damndelicious.net",
-		"www.marthastewart.com",
-		"www.myrecipes.com",
-		"www.101cookbooks.com",
-		"www.skinnytaste.com",
-		"cookieandkate.com",
-		"smittenkitchen.com",
+// recipePaths maps each allowed recipe domain, without www., to the path
+// fragments accepted for indexing. It is loaded from the site definition
+// file with SetRecipeSites.
+var recipePaths = make(map[string][]string)
+
+// SetRecipeSites replaces the domains and paths accepted for indexing
+func SetRecipeSites(defs []sites.Site) {
+	paths := make(map[string][]string, len(defs))
+	for _, def := range defs {
+		paths[def.Domain] = def.IndexPaths
 	}
+	recipePaths = paths
+}
 
+// isValidRecipeURL checks if a URL is a valid recipe URL
+func isValidRecipeURL(rawURL string) bool {
 	// Parse the URL
 	parsedURL, err := url.Parse(rawURL)
 	if err != nil {
 		return false
 	}
 
-	// Extract host without www. prefix for consistent matching
-	host := parsedURL.Host
-	if strings.HasPrefix(host, "www.") {
-		host = host[4:]
-	}
-
-	// Check if domain is in allowed list
-	domainValid := false
-	for _, domain := range allowedDomains {
-		// Remove www. from the domain for consistent matching
-		allowedHost := domain
-		if strings.HasPrefix(allowedHost, "www.") {
-			allowedHost = allowedHost[4:]
-		}
-
-		if host == allowedHost || parsedURL.Host == domain {
-			domainValid = true
-			break
-		}
-	}
-
-	if !domainValid {
-		return false
-	}
-
-	// Map of domain-specific validation patterns - expanded
-	validPathPatterns := map[string][]string{
-		"delish.com":                {"/cooking/recipe-ideas/", "/recipe/", "/recipes/"},
-		"allrecipes.com":            {"/recipe/", "/recipes/", "/gallery/"},
-		"foodnetwork.com":           {"/recipes/", "/recipe/", "/fn-dish/"},
-		"epicurious.com":            {"/recipes/", "/recipe/", "/food/views/"},
-		"simplyrecipes.com":         {"/recipes/", "/"},
-		"bonappetit.com":            {"/recipe/", "/recipes/", "/story/"},
-		"taste.com.au":              {"/recipes/", "/recipe/"},
-		"bbcgoodfood.com":           {"/recipes/", "/recipe/"},
-		"eatingwell.com":            {"/recipe/", "/recipes/"},
-		"seriouseats.com":           {"/recipes/", "/"},
-		"cooking.nytimes.com":       {"/recipes/", "/"},
-		"tasteofhome.com":           {"/recipes/", "/recipe/"},
-		"food.com":                  {"/recipe/", "/recipes/"},
-		"yummly.com":                {"/recipe/", "/recipes/"},
-		"thepioneerwoman.com":       {"/food-cooking/recipes/", "/food-cooking/"},
-		"minimalistbaker.com":       {"/recipes/", "/recipe/"},
-		"pinchofyum.com":            {"/recipe/", "/"},
-		"budgetbytes.com":           {"/recipes/", "/recipe/"},
-		"sallysbakingaddiction.com": {"/recipe/", "/"},
-		"recipetineats.com":         {"/recipes/", "/recipe/", "/"},
-		"gimmesomeoven.com":         {"/"},
-		"damndelicious.net":         {"/recipe/", "/"},
-		"marthastewart.com":         {"/recipe/", "/recipes/"},
-		"myrecipes.com":             {"/recipe/", "/recipes/"},
-		"101cookbooks.com":          {"/recipes/", "/recipe/"},
-		"skinnytaste.com":           {"/recipe/", "/recipes/"},
-		"cookieandkate.com":         {"/recipe/", "/"},
-		"smittenkitchen.com":        {"/recipe/", "/"},
-	}
-
 	// Extract the host part without www. for consistent matching
-	hostForPatterns := parsedURL.Host
+	hostForPatterns := strings.ToLower(parsedURL.Hostname())
 	if strings.HasPrefix(hostForPatterns, "www.") {
 		hostForPatterns = hostForPatterns[4:]
 	}
 
-	// Check domain-specific path patterns
-	patterns, exists := validPathPatterns[hostForPatterns]
+	// Only domains with a site definition are indexed
+	patterns, exists := recipePaths[hostForPatterns]
 	if !exists {
-		// If we don't have specific patterns, check if path has common recipe indicators
-		path := parsedURL.Path
-		commonRecipePatterns := []string{"/recipe/", "/recipes/", "-recipe", "recipe-"}
-		for _, pattern := range commonRecipePatterns {
-			if strings.Contains(path, pattern) {
-				return true
-			}
-		}
 		return false
 	}
 
@@ -447,9 +537,10 @@ func isLikelyRecipePage(urlStr string) bool {
 func existingURL(urlToCheck string) (bool, error) {
 	ctx := context.Background()
 
-	// Create a bool query to check for exact URL match
+	// Match the canonical URL, and the URL as given for documents indexed
+	// before URLs were canonicalized
 	q := elastic.NewBoolQuery().
-		Must(elastic.NewTermQuery("url.keyword", urlToCheck))
+		Must(elastic.NewTermsQuery("url.keyword", canonical.URL(urlToCheck), urlToCheck))
 
 	// Execute the search with error handling
 	result, err := client.Search().
@@ -467,7 +558,9 @@ func existingURL(urlToCheck string) (bool, error) {
 	return hits > 0, nil
 }
 
-// CreatePage creates a new page in Elasticsearch with more flexible validation
+// CreatePage creates a new page in Elasticsearch with more flexible validation.
+// While the bulk indexer is running the page is only queued, and true means
+// it passed validation.
 func CreatePage(p structs.Page) bool {
 	ctx := context.Background()
 
@@ -489,52 +582,12 @@ func CreatePage(p structs.Page) bool {
 		logger.WriteInfo(fmt.Sprintf("Using title as name for URL: %s", p.URL))
 	}
 
-	// Allow empty ingredients if body contains ingredient-related text
-	hasIngredientText := p.Ingredients != "" ||
-		strings.Contains(strings.ToLower(p.Body), "ingredient") ||
-		strings.Contains(p.Body, "Ingredient")
-
-	// Allow empty instructions if body contains instruction-related text
-	instructionKeywords := []string{"instructions", "directions", "steps", "method", "preparation"}
-	hasInstructionText := p.Instructions != ""
-
-	if !hasInstructionText {
-		for _, keyword := range instructionKeywords {
-			if strings.Contains(strings.ToLower(p.Body), keyword) ||
-				strings.Contains(p.Body, strings.Title(keyword)) {
-				hasInstructionText = true
-				break
-			}
-		}
-	}
-
-	// Check minimum required data with more flexibility
-	if !hasIngredientText || !hasInstructionText {
-		// Special case for allrecipes.com - they have a specific structure
-		if strings.Contains(p.URL, "allrecipes.com") {
-			// For allrecipes, if we have the URL and title/name, let's assume it's valid
-			// and create a placeholder for missing data
-			if p.Ingredients == "" && hasIngredientText {
-				p.Ingredients = "Ingredients mentioned in page but not structured"
-				logger.WriteInfo(fmt.Sprintf("Created placeholder ingredients for AllRecipes URL: %s", p.URL))
-			}
-
-			if p.Instructions == "" && hasInstructionText {
-				p.Instructions = "Instructions mentioned in page but not structured"
-				logger.WriteInfo(fmt.Sprintf("Created placeholder instructions for AllRecipes URL: %s", p.URL))
-			}
-		} else {
-			// For other sites, still enforce basic validation
-			if !hasIngredientText {
-				logger.WriteWarning(fmt.Sprintf("Cannot create page, missing ingredients: %s", p.URL))
-				return false
-			}
-
-			if !hasInstructionText {
-				logger.WriteWarning(fmt.Sprintf("Cannot create page, missing instructions: %s", p.URL))
-				return false
-			}
-		}
+	// Recipes without real ingredients or instructions belong in the
+	// quarantine index, not here. Confidence was already checked by the
+	// caller.
+	if reason := p.QuarantineReason(0); reason != "" {
+		logger.WriteWarning(fmt.Sprintf("Cannot create page, %s: %s", reason, p.URL))
+		return false
 	}
 
 	// Convert relative URL to absolute if needed
@@ -548,6 +601,9 @@ func CreatePage(p structs.Page) bool {
 		logger.WriteInfo(fmt.Sprintf("Converted relative URL to absolute: %s", p.URL))
 	}
 
+	// Store the canonical URL so variants of the same page collapse
+	p.URL = canonical.URL(p.URL)
+
 	// Improved URL validation for recipe sites
 	if !isValidRecipeURL(p.URL) {
 		// If URL doesn't match standard patterns but appears to be a recipe, allow it
@@ -562,43 +618,61 @@ func CreatePage(p structs.Page) bool {
 	// Set source site from URL
 	p.SourceSite = extractSourceSite(p.URL)
 
+	// IDs are derived from the canonical URL so they survive a re-crawl
+	if p.ID == "" {
+		p.ID = canonical.ID(p.URL)
+	}
+
+	// Numeric copies of the time, calorie and servings strings for range
+	// filters and sorting, and the ingredients split into nested items
+	p.SetNumericFields()
+	p.SetIngredientItems()
+
 	// Set crawl date to current time
 	p.CrawlDate = time.Now()
-
-	// Check if URL already exists - with improved error handling
-	urlExists, err := existingURL(p.URL)
-	if err != nil {
-		logger.WriteWarning(fmt.Sprintf("Error checking if URL exists: %v - proceeding anyway", err))
-	} else if urlExists {
-		logger.WriteWarning(fmt.Sprintf("URL already exists in database: %s", p.URL))
-		return false
+	if p.LastChanged.IsZero() {
+		p.LastChanged = p.CrawlDate
 	}
 
-	// Less stringent validation of extracted data length
-	// We still want some basic data, but we'll be more lenient
-	if len(p.Ingredients) < 5 && !strings.Contains(p.Ingredients, "placeholder") {
-		logger.WriteWarning(fmt.Sprintf("Ingredients seem unusually short: %s", p.Title))
-		// But proceed anyway - don't return false
+	// Fingerprint the recipe and join the group of any near-duplicate,
+	// such as a syndicated copy on another site
+	fp := fingerprint.New(p.Ingredients, p.Instructions)
+	p.Fingerprint = fp.Bands()
+	p.DuplicateGroup = duplicateGroup(p.ID, fp)
+	if p.DuplicateGroup == "" {
+		p.DuplicateGroup = p.ID
 	}
 
-	if len(p.Instructions) < 10 && !strings.Contains(p.Instructions, "placeholder") {
-		logger.WriteWarning(fmt.Sprintf("Instructions seem unusually short: %s", p.Title))
-		// But proceed anyway - don't return false
+	// With the bulk indexer running the page is written with the next
+	// batch and the outcome is reported through its callback
+	if bulkProcessor != nil {
+		queuePage(p)
+		return true
 	}
 
-	// Create the new page with refresh to ensure immediate visibility
-	_, err = client.Index().
+	// Create the new page with refresh to ensure immediate visibility.
+	// op_type=create fails if the URL-derived ID is already indexed.
+	atomic.AddInt64(&indexStats.Queued, 1)
+	_, err := client.Index().
 		Index(IndexName).
+		OpType("create").
 		Id(p.ID).
 		Refresh("true").
 		BodyJson(p).
 		Do(ctx)
 
 	if err != nil {
-		logger.WriteWarning(fmt.Sprintf("Failed to create the page: %v", err))
+		if elastic.IsConflict(err) {
+			atomic.AddInt64(&indexStats.Duplicates, 1)
+			logger.WriteWarning(fmt.Sprintf("URL already exists in database: %s", p.URL))
+		} else {
+			atomic.AddInt64(&indexStats.Failed, 1)
+			logger.WriteWarning(fmt.Sprintf("Failed to create the page: %v", err))
+		}
 		return false
 	}
 
+	atomic.AddInt64(&indexStats.Created, 1)
 	logger.WriteInfo(fmt.Sprintf("Successfully created new recipe - Title: %s, URL: %s", p.Title, p.URL))
 	return true
 }
@@ -622,8 +696,9 @@ func UpdatePage(id string, params map[string]interface{}) bool {
 				domain = "https://www.example.com" // Fallback
 			}
 			url = domain + url
-			params["url"] = url
 		}
+		url = canonical.URL(url)
+		params["url"] = url
 
 		if !isValidRecipeURL(url) {
 			logger.WriteWarning(fmt.Sprintf("Invalid URL format: %s", url))
@@ -663,21 +738,41 @@ func UpdatePage(id string, params map[string]interface{}) bool {
 		}
 	}
 
-	// More flexible validation for ingredients and instructions
+	// Empty ingredients or instructions never overwrite the stored ones
 	if ingredients, ok := params["ingredients"].(string); ok && ingredients == "" {
-		// If ingredients is provided but empty, add a placeholder
-		params["ingredients"] = "Ingredients mentioned in page but not structured"
-		logger.WriteInfo("Created placeholder ingredients for update")
+		delete(params, "ingredients")
+		logger.WriteInfo("Keeping stored ingredients, update has none")
 	}
 
 	if instructions, ok := params["instructions"].(string); ok && instructions == "" {
-		// If instructions is provided but empty, add a placeholder
-		params["instructions"] = "Instructions mentioned in page but not structured"
-		logger.WriteInfo("Created placeholder instructions for update")
+		delete(params, "instructions")
+		logger.WriteInfo("Keeping stored instructions, update has none")
+	}
+
+	// Re-fingerprint a changed recipe, which may move it to another group
+	ingredients, hasIngredients := params["ingredients"].(string)
+	instructions, hasInstructions := params["instructions"].(string)
+	if hasIngredients && hasInstructions {
+		fp := fingerprint.New(ingredients, instructions)
+		group := duplicateGroup(id, fp)
+		if group == "" {
+			group = id
+		}
+		params["fingerprint"] = fp.Bands()
+		params["duplicate_group"] = group
 	}
 
-	// Update crawl_date
+	setNumericParams(params)
+	// Items read from a recipe plugin's markup come with the update
+	if _, hasItems := params["ingredient_items"]; hasIngredients && !hasItems {
+		params["ingredient_items"] = ingredientItems(ingredients)
+	}
+
+	// Update crawl_date, and last_changed unless the caller set it
 	params["crawl_date"] = time.Now()
+	if _, ok := params["last_changed"]; !ok {
+		params["last_changed"] = params["crawl_date"]
+	}
 
 	// Perform the update with refresh to ensure immediate visibility
 	_, err := client.Update().
@@ -697,10 +792,17 @@ func UpdatePage(id string, params map[string]interface{}) bool {
 	return true
 }
 
-// ExistingPage return a boolean and a page if the title is already
-// stored in the database
-func ExistingPage(title string) (bool, structs.Page) {
-	var exists bool
+// ExistingPage return a boolean and a page if the recipe at pageURL is
+// already stored in the database. A page stored under the URL always
+// matches. A page stored under another URL only matches if it has a similar
+// title, comes from the same site and its ingredients and instructions are
+// a near-duplicate, so different recipes that share a title are kept apart
+// and copies on other sites are grouped instead of overwritten.
+func ExistingPage(pageURL, title, ingredients, instructions string) (bool, structs.Page) {
+	if exists, p := PageByURL(pageURL); exists {
+		return true, p
+	}
+
 	var p structs.Page
 
 	ctx := context.Background()
@@ -718,6 +820,11 @@ func ExistingPage(title string) (bool, structs.Page) {
 		).
 		MinimumShouldMatch("1")
 
+	// Recipes indexed before URLs were canonicalized kept the www. prefix
+	if site := canonical.Host(extractSourceSite(pageURL)); site != "" {
+		q = q.Filter(elastic.NewTermsQuery("source_site", site, "www."+site))
+	}
+
 	result, err := client.Search().
 		Index(IndexName).
 		Query(q).
@@ -731,26 +838,33 @@ func ExistingPage(title string) (bool, structs.Page) {
 
 	// Set a similarity threshold for considering a page a match
 	similarityThreshold := 0.8
+	fp := fingerprint.New(ingredients, instructions)
 
-	var ttyp structs.Page
 	for _, hit := range result.Hits.Hits {
+		var ttyp structs.Page
 		if err := json.Unmarshal(hit.Source, &ttyp); err != nil {
 			logger.WriteError(fmt.Sprintf("Failed to unmarshal page: %v", err))
 			continue
 		}
+		ttyp.ID = hit.Id
 
 		// Calculate title similarity
 		similarity := calculateTitleSimilarity(title, ttyp.Title)
 		logger.WriteInfo(fmt.Sprintf("Title similarity: %.2f for '%s' vs '%s'", similarity, title, ttyp.Title))
+		if similarity < similarityThreshold {
+			continue
+		}
 
-		// If similarity is above threshold, consider it a match
-		if similarity >= similarityThreshold {
-			exists, p = true, ttyp
-			return exists, p
+		// A shared title is not enough, the recipe itself has to match
+		if !fingerprint.Duplicate(fp, fingerprint.New(ttyp.Ingredients, ttyp.Instructions)) {
+			logger.WriteInfo(fmt.Sprintf("Different recipe with a similar title: %s", ttyp.URL))
+			continue
 		}
+
+		return true, ttyp
 	}
 
-	return exists, p
+	return false, p
 }
 
 // normalizeTitle removes extra whitespace and converts to lowercase for consistent matching
@@ -0,0 +1,26 @@
+package elasticsearch
+
+// Reading recipes back out of the index for snapshots
+import (
+	"context"
+	"search-engine-indexer/src/structs"
+	"time"
+
+	elastic "github.com/olivere/elastic/v7"
+)
+
+// EachPage calls fn for every recipe changed at or after since, or for every
+// recipe if since is zero, oldest crawl first
+func EachPage(since time.Time, fn func(structs.Page) error) error {
+	var query elastic.Query
+	if !since.IsZero() {
+		query = elastic.NewRangeQuery("last_changed").Gte(since)
+	}
+
+	return scrollPages(context.Background(), query, elastic.NewFetchSourceContext(true), func(id string, p structs.Page) error {
+		if p.ID == "" {
+			p.ID = id
+		}
+		return fn(p)
+	})
+}
@@ -0,0 +1,223 @@
+package elasticsearch
+
+// Migration from random document IDs to IDs derived from the canonical URL
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"search-engine-indexer/src/canonical"
+	"search-engine-indexer/src/logger"
+	"search-engine-indexer/src/structs"
+	"time"
+
+	elastic "github.com/olivere/elastic/v7"
+)
+
+// AliasIndexName holds one document per retired recipe ID, keyed by the
+// old ID, so API clients can redirect links saved before the migration
+const (
+	AliasIndexName    = "recipe_aliases"
+	AliasIndexMapping = `{
+        "settings":{
+            "number_of_shards":1,
+            "number_of_replicas":0
+        },
+        "mappings":{
+            "properties":{
+                "new_id": {
+                    "type": "keyword"
+                },
+                "url": {
+                    "type": "keyword"
+                },
+                "migrated": {
+                    "type": "date"
+                }
+            }
+        }
+    }`
+)
+
+// IDAlias maps a retired recipe ID to its current one
+type IDAlias struct {
+	NewID    string    `json:"new_id"`
+	URL      string    `json:"url"`
+	Migrated time.Time `json:"migrated"`
+}
+
+// ensureAliasIndex creates the alias index if it doesn't exist yet
+func ensureAliasIndex(ctx context.Context) error {
+	exists, err := client.IndexExists(AliasIndexName).Do(ctx)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = client.CreateIndex(AliasIndexName).Body(AliasIndexMapping).Do(ctx)
+	return err
+}
+
+// MigrateIDs re-keys every recipe under the ID derived from its canonical
+// URL and records each old ID in the alias index. Documents that share a
+// canonical URL collapse into one, keeping the most recently crawled copy.
+// Duplicate groups named after a retired ID are renamed too. It returns
+// the old-to-new ID map.
+func MigrateIDs() (map[string]string, error) {
+	ctx := context.Background()
+	if err := ensureAliasIndex(ctx); err != nil {
+		return nil, fmt.Errorf("create alias index: %w", err)
+	}
+
+	// First pass: work out every document's new ID. Pages come oldest
+	// first, so the last one seen for an ID is the copy to keep.
+	aliases := make(map[string]string)
+	urls := make(map[string]string)
+	keep := make(map[string]string)
+	err := scrollPages(ctx, nil, elastic.NewFetchSourceContext(true).Include("url"), func(id string, p structs.Page) error {
+		if p.URL == "" {
+			return nil
+		}
+		newID := canonical.ID(p.URL)
+		keep[newID] = id
+		if newID != id {
+			aliases[id] = newID
+			urls[id] = canonical.URL(p.URL)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Second pass: rewrite documents whose ID or group changed
+	bulk := client.Bulk()
+
+	err = scrollPages(ctx, nil, elastic.NewFetchSourceContext(true), func(id string, p structs.Page) error {
+		newID, moved := aliases[id]
+		newGroup, regrouped := aliases[p.DuplicateGroup]
+		if !moved && !regrouped && p.ID == id {
+			return nil
+		}
+
+		p.ID = id
+		if moved {
+			p.ID = newID
+		}
+		if regrouped {
+			p.DuplicateGroup = newGroup
+		}
+
+		if keep[p.ID] == id {
+			bulk.Add(elastic.NewBulkIndexRequest().Index(IndexName).Id(p.ID).Doc(p))
+		}
+		if moved {
+			bulk.Add(elastic.NewBulkDeleteRequest().Index(IndexName).Id(id))
+		}
+		return flushBulk(ctx, bulk, bulkBatchSize)
+	})
+	if err != nil {
+		return aliases, err
+	}
+
+	// Record the aliases
+	now := time.Now()
+	for oldID, newID := range aliases {
+		bulk.Add(elastic.NewBulkIndexRequest().Index(AliasIndexName).Id(oldID).Doc(IDAlias{
+			NewID:    newID,
+			URL:      urls[oldID],
+			Migrated: now,
+		}))
+		if err := flushBulk(ctx, bulk, bulkBatchSize); err != nil {
+			return aliases, err
+		}
+	}
+	if err := flushBulk(ctx, bulk, 1); err != nil {
+		return aliases, err
+	}
+
+	// Aliases from an earlier migration that point at a retired ID
+	if len(aliases) > 0 {
+		ids := make([]interface{}, 0, len(aliases))
+		for oldID := range aliases {
+			ids = append(ids, oldID)
+		}
+		script := elastic.NewScript("ctx._source.new_id = params.aliases[ctx._source.new_id]").
+			Param("aliases", aliases)
+		_, err := client.UpdateByQuery(AliasIndexName).
+			Query(elastic.NewTermsQuery("new_id", ids...)).
+			Script(script).
+			Do(ctx)
+		if err != nil {
+			logger.WriteWarning(fmt.Sprintf("Failed to update older ID aliases: %v", err))
+		}
+	}
+
+	if _, err := client.Refresh(IndexName, AliasIndexName).Do(ctx); err != nil {
+		logger.WriteWarning(fmt.Sprintf("Failed to refresh indices after ID migration: %v", err))
+	}
+
+	return aliases, nil
+}
+
+// Actions sent per bulk request by the maintenance commands
+const bulkBatchSize = 500
+
+// flushBulk sends the queued bulk actions once there are at least atLeast
+// of them. Individual failed actions are logged rather than returned.
+func flushBulk(ctx context.Context, bulk *elastic.BulkService, atLeast int) error {
+	if atLeast < 1 {
+		atLeast = 1
+	}
+	if bulk.NumberOfActions() < atLeast {
+		return nil
+	}
+
+	response, err := bulk.Do(ctx)
+	if err != nil {
+		return err
+	}
+	for _, item := range response.Failed() {
+		reason := ""
+		if item.Error != nil {
+			reason = item.Error.Reason
+		}
+		logger.WriteWarning(fmt.Sprintf("Bulk action failed for %s/%s (status %d): %s", item.Index, item.Id, item.Status, reason))
+	}
+	return nil
+}
+
+// scrollPages calls fn for every recipe matching query, or every recipe if
+// query is nil, oldest crawl first
+func scrollPages(ctx context.Context, query elastic.Query, source *elastic.FetchSourceContext, fn func(id string, p structs.Page) error) error {
+	if query == nil {
+		query = elastic.NewMatchAllQuery()
+	}
+
+	scroll := client.Scroll(IndexName).
+		Query(query).
+		Sort("crawl_date", true).
+		FetchSourceContext(source).
+		Size(bulkBatchSize)
+	defer scroll.Clear(ctx)
+
+	for {
+		result, err := scroll.Do(ctx)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, hit := range result.Hits.Hits {
+			var p structs.Page
+			if err := json.Unmarshal(hit.Source, &p); err != nil {
+				logger.WriteWarning(fmt.Sprintf("Failed to unmarshal page %s: %v", hit.Id, err))
+				continue
+			}
+			if err := fn(hit.Id, p); err != nil {
+				return err
+			}
+		}
+	}
+}
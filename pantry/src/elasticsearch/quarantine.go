@@ -0,0 +1,234 @@
+package elasticsearch
+
+// Recipes held back from the pages index because their extraction failed
+// validation
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"search-engine-indexer/src/logger"
+	"search-engine-indexer/src/structs"
+	"sync"
+	"time"
+
+	elastic "github.com/olivere/elastic/v7"
+)
+
+// QuarantineIndexName holds one document per quarantined recipe, keyed by
+// the ID the recipe would have in the pages index. The page itself is
+// stored but not indexed, so it doesn't show up in recipe searches.
+const (
+	QuarantineIndexName    = "recipes_quarantine"
+	QuarantineIndexMapping = `{
+        "settings":{
+            "number_of_shards":1,
+            "number_of_replicas":0
+        },
+        "mappings":{
+            "properties":{
+                "url": {
+                    "type": "keyword"
+                },
+                "source_site": {
+                    "type": "keyword"
+                },
+                "reason": {
+                    "type": "keyword"
+                },
+                "html_ref": {
+                    "type": "keyword"
+                },
+                "quarantined_at": {
+                    "type": "date"
+                },
+                "attempts": {
+                    "type": "integer"
+                },
+                "page": {
+                    "type": "object",
+                    "enabled": false
+                }
+            }
+        }
+    }`
+)
+
+// QuarantinedRecipe is a recipe that failed validation. HTMLRef is the
+// path of the page's raw HTML as fetched, and Attempts counts the
+// extractions that have failed, including retries.
+type QuarantinedRecipe struct {
+	ID            string       `json:"id"`
+	URL           string       `json:"url"`
+	SourceSite    string       `json:"source_site"`
+	Reason        string       `json:"reason"`
+	HTMLRef       string       `json:"html_ref"`
+	QuarantinedAt time.Time    `json:"quarantined_at"`
+	Attempts      int          `json:"attempts"`
+	Page          structs.Page `json:"page"`
+}
+
+// quarantineCheckInterval is how long AnyQuarantined trusts its last
+// answer that nothing is quarantined
+const quarantineCheckInterval = time.Minute
+
+// What AnyQuarantined last found, so the crawl doesn't look up every recipe
+// it stores in an empty or missing quarantine index
+var (
+	quarantineMu      sync.Mutex
+	quarantineEmpty   bool
+	quarantineChecked time.Time
+)
+
+// AnyQuarantined reports whether the quarantine index holds any recipe. An
+// empty or missing index is only checked again once a minute, or as soon
+// as this process quarantines a recipe. Errors count as quarantined so
+// callers still look.
+func AnyQuarantined() bool {
+	quarantineMu.Lock()
+	defer quarantineMu.Unlock()
+
+	if quarantineEmpty && time.Since(quarantineChecked) < quarantineCheckInterval {
+		return false
+	}
+
+	count, err := client.Count(QuarantineIndexName).Do(context.Background())
+	if elastic.IsNotFound(err) {
+		count, err = 0, nil
+	}
+	if err != nil {
+		return true
+	}
+	quarantineEmpty = count == 0
+	quarantineChecked = time.Now()
+	return !quarantineEmpty
+}
+
+// ensureQuarantineIndex creates the quarantine index if it doesn't exist
+// yet
+func ensureQuarantineIndex(ctx context.Context) error {
+	exists, err := client.IndexExists(QuarantineIndexName).Do(ctx)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = client.CreateIndex(QuarantineIndexName).Body(QuarantineIndexMapping).Do(ctx)
+	return err
+}
+
+// QuarantinePage stores p in the quarantine index with the reason it
+// failed validation, replacing any earlier entry for the same recipe
+func QuarantinePage(p structs.Page, reason, htmlRef string) error {
+	ctx := context.Background()
+	if err := ensureQuarantineIndex(ctx); err != nil {
+		return fmt.Errorf("create quarantine index: %w", err)
+	}
+
+	entry := QuarantinedRecipe{
+		ID:            p.ID,
+		URL:           p.URL,
+		SourceSite:    p.SourceSite,
+		Reason:        reason,
+		HTMLRef:       htmlRef,
+		QuarantinedAt: time.Now(),
+		Attempts:      1,
+		Page:          p,
+	}
+	previous, err := GetQuarantined(p.ID)
+	if err != nil {
+		return err
+	}
+	if previous != nil {
+		entry.Attempts = previous.Attempts + 1
+	}
+
+	_, err = client.Index().
+		Index(QuarantineIndexName).
+		Id(p.ID).
+		Refresh("true").
+		BodyJson(entry).
+		Do(ctx)
+	if err != nil {
+		return err
+	}
+
+	quarantineMu.Lock()
+	quarantineEmpty = false
+	quarantineMu.Unlock()
+	return nil
+}
+
+// GetQuarantined returns the quarantine entry for a recipe ID, or nil if
+// there is none
+func GetQuarantined(id string) (*QuarantinedRecipe, error) {
+	result, err := client.Get().Index(QuarantineIndexName).Id(id).Do(context.Background())
+	if elastic.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entry QuarantinedRecipe
+	if err := json.Unmarshal(result.Source, &entry); err != nil {
+		return nil, fmt.Errorf("decode quarantine entry %s: %w", id, err)
+	}
+	entry.ID = result.Id
+	return &entry, nil
+}
+
+// QuarantinedRecipes returns the quarantined recipes, oldest first, or
+// only those quarantined for reason when it isn't empty
+func QuarantinedRecipes(reason string) ([]QuarantinedRecipe, error) {
+	ctx := context.Background()
+	exists, err := client.IndexExists(QuarantineIndexName).Do(ctx)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	var query elastic.Query = elastic.NewMatchAllQuery()
+	if reason != "" {
+		query = elastic.NewTermQuery("reason", reason)
+	}
+
+	scroll := client.Scroll(QuarantineIndexName).
+		Query(query).
+		Sort("quarantined_at", true).
+		Size(bulkBatchSize)
+	defer scroll.Clear(ctx)
+
+	entries := []QuarantinedRecipe{}
+	for {
+		result, err := scroll.Do(ctx)
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, err
+		}
+
+		for _, hit := range result.Hits.Hits {
+			var entry QuarantinedRecipe
+			if err := json.Unmarshal(hit.Source, &entry); err != nil {
+				logger.WriteWarning(fmt.Sprintf("Failed to unmarshal quarantine entry %s: %v", hit.Id, err))
+				continue
+			}
+			entry.ID = hit.Id
+			entries = append(entries, entry)
+		}
+	}
+}
+
+// RemoveQuarantined deletes a recipe's quarantine entry. An entry that's
+// already gone isn't an error.
+func RemoveQuarantined(id string) error {
+	_, err := client.Delete().
+		Index(QuarantineIndexName).
+		Id(id).
+		Refresh("true").
+		Do(context.Background())
+	if elastic.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
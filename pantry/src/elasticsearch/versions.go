@@ -0,0 +1,356 @@
+package elasticsearch
+
+// Versioned recipe indices behind the IndexName alias
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"search-engine-indexer/src/logger"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	elastic "github.com/olivere/elastic/v7"
+)
+
+// IndexVersion is one versioned recipe index
+type IndexVersion struct {
+	Name    string
+	Version int
+	Docs    int
+	Live    bool
+}
+
+// MigrateResult describes a finished migration
+type MigrateResult struct {
+	From     string
+	To       string
+	Docs     int64
+	CaughtUp int64
+}
+
+// VersionedIndex returns the name of the index holding a mapping version
+func VersionedIndex(version int) string {
+	return fmt.Sprintf("%s_v%d", IndexName, version)
+}
+
+// IndexVersions lists the versioned recipe indices, oldest first, and
+// reports whether an unversioned index named IndexName exists
+func IndexVersions() ([]IndexVersion, bool, error) {
+	ctx := context.Background()
+
+	live, err := liveIndex(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	rows, err := client.CatIndices().Index(IndexName+"_v*").Columns("index", "docs.count").Do(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	versions := []IndexVersion{}
+	for _, row := range rows {
+		version, ok := indexVersion(row.Index)
+		if !ok {
+			continue
+		}
+		versions = append(versions, IndexVersion{
+			Name:    row.Index,
+			Version: version,
+			Docs:    row.DocsCount,
+			Live:    row.Index == live,
+		})
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+
+	legacy := false
+	if live == "" {
+		legacy, err = client.IndexExists(IndexName).Do(ctx)
+		if err != nil {
+			return versions, false, err
+		}
+	}
+
+	return versions, legacy, nil
+}
+
+// CheckIndexVersion warns when the alias doesn't point at the current
+// mapping version
+func CheckIndexVersion() {
+	live, err := liveIndex(context.Background())
+	switch {
+	case err != nil:
+		logger.WriteWarning(fmt.Sprintf("Failed to resolve alias %s: %v", IndexName, err))
+	case live == "":
+		logger.WriteWarning(fmt.Sprintf("Index %s is not versioned, run \"pantry migrate\" to move it to %s", IndexName, VersionedIndex(MappingVersion)))
+	case live != VersionedIndex(MappingVersion):
+		logger.WriteWarning(fmt.Sprintf("Alias %s points at %s, run \"pantry migrate\" to move to %s", IndexName, live, VersionedIndex(MappingVersion)))
+	}
+}
+
+// LegacyVersion is the version an unversioned index is kept under once it
+// has been migrated
+const LegacyVersion = 0
+
+// Migrate copies the recipes into a new index created from IndexMapping and
+// atomically moves the alias to it. The previous version is kept for
+// Rollback.
+//
+// An unversioned index is made read-only and cloned to
+// VersionedIndex(LegacyVersion) first, and the migration copies from the
+// clone. The unversioned index is removed in the atomic swap, since the
+// alias takes its name, and the clone is what Rollback goes back to.
+//
+// Recipes written while the copy runs are caught up twice: once before the
+// swap, and once after it for writes that reached the old index while the
+// alias moved.
+func Migrate() (*MigrateResult, error) {
+	ctx := context.Background()
+	target := VersionedIndex(MappingVersion)
+
+	live, err := liveIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	source := live
+	swapped := false
+	if source == "" {
+		exists, err := client.IndexExists(IndexName).Do(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("no %s index to migrate", IndexName)
+		}
+
+		source, err = cloneLegacyIndex(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		// Crawls can write to the unversioned index again if the
+		// migration stops before the alias takes its place
+		defer func() {
+			if !swapped {
+				if err := setWriteBlock(ctx, IndexName, false); err != nil {
+					logger.WriteWarning(fmt.Sprintf("Failed to make %s writable again: %v", IndexName, err))
+				}
+			}
+		}()
+	}
+
+	result := &MigrateResult{From: source, To: target}
+	if source == target {
+		return result, nil
+	}
+
+	// A target left behind by a failed or rolled back migration is rebuilt
+	exists, err := client.IndexExists(target).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		logger.WriteWarning(fmt.Sprintf("Deleting %s left by an earlier migration", target))
+		if _, err := client.DeleteIndex(target).Do(ctx); err != nil {
+			return nil, fmt.Errorf("delete %s: %w", target, err)
+		}
+	}
+
+	if _, err := client.CreateIndex(target).Body(IndexMapping).Do(ctx); err != nil {
+		return nil, fmt.Errorf("create %s: %w", target, err)
+	}
+
+	sourceDocs, err := client.Count(source).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	started := time.Now()
+	logger.WriteInfo(fmt.Sprintf("Reindexing %d recipes from %s into %s", sourceDocs, source, target))
+	copied, err := reindex(ctx, source, target, nil, "index")
+	if err != nil {
+		return nil, err
+	}
+	result.Docs = copied
+
+	// Recipes changed while the copy ran
+	changed := elastic.NewRangeQuery("last_changed").Gte(started)
+	caughtUp, err := reindex(ctx, source, target, changed, "index")
+	if err != nil {
+		return nil, err
+	}
+	result.CaughtUp = caughtUp
+
+	targetDocs, err := client.Count(target).Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if targetDocs < sourceDocs {
+		return nil, fmt.Errorf("%s has %d recipes but %s had %d, alias not moved", target, targetDocs, source, sourceDocs)
+	}
+
+	// Swap the alias in one request so readers never see it missing
+	swap := client.Alias().Add(target, IndexName)
+	if live != "" {
+		swap = swap.Remove(live, IndexName)
+	} else {
+		swap = swap.Action(elastic.NewAliasRemoveIndexAction(IndexName))
+	}
+	if _, err := swap.Do(ctx); err != nil {
+		return nil, fmt.Errorf("move alias %s to %s: %w", IndexName, target, err)
+	}
+	swapped = true
+	logger.WriteInfo(fmt.Sprintf("Alias %s moved from %s to %s", IndexName, source, target))
+
+	// Recipes created in the old index while the alias moved. op_type=create
+	// leaves anything already written to the new index alone.
+	if live != "" {
+		late, err := reindex(ctx, source, target, changed, "create")
+		if err != nil {
+			logger.WriteWarning(fmt.Sprintf("Failed to copy recipes written to %s during the swap: %v", source, err))
+		}
+		result.CaughtUp += late
+	}
+
+	return result, nil
+}
+
+// cloneLegacyIndex makes the unversioned index read-only and clones it to
+// VersionedIndex(LegacyVersion), which stays writable so it can serve
+// again after a rollback. Writes to the unversioned index fail from here
+// on, so none are lost when it is removed. A clone left by an earlier
+// attempt is replaced.
+func cloneLegacyIndex(ctx context.Context) (string, error) {
+	clone := VersionedIndex(LegacyVersion)
+
+	exists, err := client.IndexExists(clone).Do(ctx)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		logger.WriteWarning(fmt.Sprintf("Deleting %s left by an earlier migration", clone))
+		if _, err := client.DeleteIndex(clone).Do(ctx); err != nil {
+			return "", fmt.Errorf("delete %s: %w", clone, err)
+		}
+	}
+
+	if err := setWriteBlock(ctx, IndexName, true); err != nil {
+		return "", fmt.Errorf("make %s read-only: %w", IndexName, err)
+	}
+
+	_, err = client.PerformRequest(ctx, elastic.PerformRequestOptions{
+		Method: "POST",
+		Path:   fmt.Sprintf("/%s/_clone/%s", IndexName, clone),
+		Params: url.Values{"wait_for_active_shards": []string{"1"}},
+		Body:   `{"settings":{"index.blocks.write":null}}`,
+	})
+	if err != nil {
+		if err := setWriteBlock(ctx, IndexName, false); err != nil {
+			logger.WriteWarning(fmt.Sprintf("Failed to make %s writable again: %v", IndexName, err))
+		}
+		return "", fmt.Errorf("clone %s to %s: %w", IndexName, clone, err)
+	}
+
+	logger.WriteInfo(fmt.Sprintf("Cloned unversioned index %s to %s", IndexName, clone))
+	return clone, nil
+}
+
+// setWriteBlock blocks or allows writes to an index
+func setWriteBlock(ctx context.Context, index string, blocked bool) error {
+	body := `{"index.blocks.write":null}`
+	if blocked {
+		body = `{"index.blocks.write":true}`
+	}
+	_, err := client.IndexPutSettings(index).BodyString(body).Do(ctx)
+	return err
+}
+
+// Rollback points the alias back at the newest version older than the
+// live one
+func Rollback() (string, string, error) {
+	versions, _, err := IndexVersions()
+	if err != nil {
+		return "", "", err
+	}
+
+	live := -1
+	for i, version := range versions {
+		if version.Live {
+			live = i
+		}
+	}
+	if live == -1 {
+		return "", "", fmt.Errorf("alias %s doesn't point at a versioned index", IndexName)
+	}
+	if live == 0 {
+		return "", "", fmt.Errorf("no version older than %s to roll back to", versions[live].Name)
+	}
+
+	from, to := versions[live].Name, versions[live-1].Name
+	_, err = client.Alias().Remove(from, IndexName).Add(to, IndexName).Do(context.Background())
+	if err != nil {
+		return "", "", fmt.Errorf("move alias %s to %s: %w", IndexName, to, err)
+	}
+	logger.WriteInfo(fmt.Sprintf("Alias %s rolled back from %s to %s", IndexName, from, to))
+	return from, to, nil
+}
+
+// reindex copies the recipes matching query, or all of them if query is
+// nil, and returns how many were written
+func reindex(ctx context.Context, source, target string, query elastic.Query, opType string) (int64, error) {
+	src := elastic.NewReindexSource().Index(source)
+	if query != nil {
+		src = src.Query(query)
+	}
+
+	response, err := client.Reindex().
+		Source(src).
+		Destination(elastic.NewReindexDestination().Index(target).OpType(opType)).
+		ProceedOnVersionConflict().
+		WaitForCompletion(true).
+		Refresh("true").
+		Do(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("reindex %s into %s: %w", source, target, err)
+	}
+	if len(response.Failures) > 0 {
+		return 0, fmt.Errorf("reindex %s into %s: %d documents failed", source, target, len(response.Failures))
+	}
+	return response.Created + response.Updated, nil
+}
+
+// liveIndex returns the index the alias points at, or "" if there is no
+// alias
+func liveIndex(ctx context.Context) (string, error) {
+	result, err := client.Aliases().Alias(IndexName).Do(ctx)
+	if elastic.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	indices := result.IndicesByAlias(IndexName)
+	switch len(indices) {
+	case 0:
+		return "", nil
+	case 1:
+		return indices[0], nil
+	default:
+		sort.Strings(indices)
+		return "", fmt.Errorf("alias %s points at several indices: %s", IndexName, strings.Join(indices, ", "))
+	}
+}
+
+// indexVersion parses the version from a versioned index name
+func indexVersion(name string) (int, bool) {
+	version, err := strconv.Atoi(strings.TrimPrefix(name, IndexName+"_v"))
+	if err != nil || !strings.HasPrefix(name, IndexName+"_v") {
+		return 0, false
+	}
+	return version, true
+}
@@ -0,0 +1,260 @@
+package fingerprint
+
+// Ingredient and instruction fingerprints for near-duplicate recipe detection
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"regexp"
+	"search-engine-indexer/src/ingredient"
+	"search-engine-indexer/src/structs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MinHash signature size, split into bands of rows for locality
+	// sensitive hashing. Two recipes share a band with high probability
+	// once their shingle sets are around 50% similar.
+	bands  = 16
+	rows   = 4
+	hashes = bands * rows
+
+	// Fewer ingredients than this are too little to tell recipes apart
+	minIngredients = 3
+
+	// Words per instruction shingle
+	shingleSize = 3
+)
+
+// Thresholds for Duplicate. Ingredients include quantities, so two
+// different recipes for the same dish rarely get close to IngredientThreshold
+// even though they use the same ingredients.
+var (
+	IngredientThreshold  = 0.7
+	InstructionThreshold = 0.5
+)
+
+// Fingerprint holds the normalized shingles of a recipe and the MinHash
+// signatures used to find candidates
+type Fingerprint struct {
+	Ingredients  []string
+	Instructions []string
+
+	ingredientSig  []uint64
+	instructionSig []uint64
+}
+
+// New fingerprints a recipe from its semicolon-separated ingredients and
+// instructions, as stored on a Page
+func New(ingredients, instructions string) Fingerprint {
+	fp := Fingerprint{
+		Ingredients:  IngredientShingles(ingredients),
+		Instructions: InstructionShingles(instructions),
+	}
+	fp.ingredientSig = minHash(fp.Ingredients)
+	fp.instructionSig = minHash(fp.Instructions)
+	return fp
+}
+
+// Usable reports whether there is enough data to compare the recipe.
+// Pages with placeholder or missing ingredients are never grouped.
+func (f Fingerprint) Usable() bool {
+	return len(f.Ingredients) >= minIngredients
+}
+
+// Bands returns the LSH band keys stored with a recipe. Recipes that share
+// a band key are duplicate candidates. Ingredient and instruction bands are
+// kept apart so a lightly edited method still finds its match through the
+// ingredients, and the other way round.
+func (f Fingerprint) Bands() []string {
+	if !f.Usable() {
+		return nil
+	}
+
+	keys := bandKeys("i", f.ingredientSig)
+	return append(keys, bandKeys("s", f.instructionSig)...)
+}
+
+// Similarity returns the Jaccard similarity of the ingredient and
+// instruction shingles. An empty instruction set on either side gives -1.
+func Similarity(a, b Fingerprint) (ingredients, instructions float64) {
+	ingredients = jaccard(a.Ingredients, b.Ingredients)
+	instructions = -1
+	if len(a.Instructions) > 0 && len(b.Instructions) > 0 {
+		instructions = jaccard(a.Instructions, b.Instructions)
+	}
+	return ingredients, instructions
+}
+
+// Duplicate reports whether two recipes are the same recipe: nearly the
+// same ingredients and quantities, and a similar method when both have one
+func Duplicate(a, b Fingerprint) bool {
+	if !a.Usable() || !b.Usable() {
+		return false
+	}
+
+	ingredients, instructions := Similarity(a, b)
+	if ingredients < IngredientThreshold {
+		return false
+	}
+	return instructions < 0 || instructions >= InstructionThreshold
+}
+
+var (
+	nonWordRegex     = regexp.MustCompile(`[^a-z0-9./ ]+`)
+	stepNumberRegex  = regexp.MustCompile(`^(?:step\s*)?\d+[.):]?\s+`)
+	unicodeFractions = strings.NewReplacer("½", " 1/2", "⅓", " 1/3", "⅔", " 2/3", "¼", " 1/4", "¾", " 3/4", "⅛", " 1/8", "⅜", " 3/8", "⅝", " 5/8", "⅞", " 7/8", "⁄", "/")
+)
+
+// IngredientShingles reduces each ingredient line to "quantity unit name"
+// with the ingredient parser, so lines canonicalize as they do in
+// ingredient_items: "2 1/4 cups all-purpose flour (281g)" becomes
+// "2.25 cup all purpose flour"
+func IngredientShingles(ingredients string) []string {
+	if structs.IsPlaceholder(ingredients, structs.PlaceholderIngredients) {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(ingredients, ";") {
+		if shingle := normalizeIngredient(line); shingle != "" {
+			seen[shingle] = true
+		}
+	}
+	return sortedKeys(seen)
+}
+
+// normalizeIngredient reduces one ingredient line to its quantity, unit
+// and name. Ranges like "2-3" keep their lower bound.
+func normalizeIngredient(line string) string {
+	parsed := ingredient.Parse(line)
+	if parsed.Name == "" {
+		return ""
+	}
+
+	parts := []string{}
+	if parsed.Max > 0 {
+		parts = append(parts, strconv.FormatFloat(math.Round(parsed.Min*100)/100, 'f', -1, 64))
+	}
+	if parsed.Unit != ingredient.NoUnit {
+		parts = append(parts, string(parsed.Unit))
+	}
+	return strings.Join(append(parts, parsed.Name), " ")
+}
+
+// InstructionShingles splits instructions into overlapping runs of words
+func InstructionShingles(instructions string) []string {
+	if structs.IsPlaceholder(instructions, structs.PlaceholderInstructions) {
+		return nil
+	}
+
+	words := []string{}
+	for _, step := range strings.Split(instructions, ";") {
+		step = strings.ToLower(strings.TrimSpace(step))
+		step = stepNumberRegex.ReplaceAllString(step, "")
+		step = unicodeFractions.Replace(step)
+		step = nonWordRegex.ReplaceAllString(step, " ")
+		for _, word := range strings.Fields(step) {
+			if word = strings.Trim(word, "./"); word != "" {
+				words = append(words, word)
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	if len(words) > 0 && len(words) < shingleSize {
+		seen[strings.Join(words, " ")] = true
+	}
+	for i := 0; i+shingleSize <= len(words); i++ {
+		seen[strings.Join(words[i:i+shingleSize], " ")] = true
+	}
+	return sortedKeys(seen)
+}
+
+// minHash computes the MinHash signature of a shingle set. Each of the
+// hash functions is the shingle's FNV hash mixed with a different seed.
+func minHash(shingles []string) []uint64 {
+	if len(shingles) == 0 {
+		return nil
+	}
+
+	sig := make([]uint64, hashes)
+	for i := range sig {
+		sig[i] = math.MaxUint64
+	}
+
+	for _, shingle := range shingles {
+		h := fnv.New64a()
+		h.Write([]byte(shingle))
+		base := h.Sum64()
+		for i := range sig {
+			if v := mix(base ^ uint64(i+1)*0x9e3779b97f4a7c15); v < sig[i] {
+				sig[i] = v
+			}
+		}
+	}
+	return sig
+}
+
+// mix is the splitmix64 finalizer
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// bandKeys hashes each band of a signature into a key like "i03-1f2e..."
+func bandKeys(prefix string, sig []uint64) []string {
+	if len(sig) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, bands)
+	for b := 0; b < bands; b++ {
+		h := fnv.New64a()
+		for _, v := range sig[b*rows : (b+1)*rows] {
+			h.Write([]byte(strconv.FormatUint(v, 16)))
+			h.Write([]byte{0})
+		}
+		keys = append(keys, fmt.Sprintf("%s%02d-%016x", prefix, b, h.Sum64()))
+	}
+	return keys
+}
+
+// jaccard returns |a ∩ b| / |a ∪ b| for two sorted sets
+func jaccard(a, b []string) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 0
+	}
+
+	shared := 0
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			shared++
+			i++
+			j++
+		case a[i] < b[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// sortedKeys returns a set's members in order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
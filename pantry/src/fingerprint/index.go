@@ -0,0 +1,54 @@
+package fingerprint
+
+// Index finds duplicate candidates among fingerprints held in memory, for
+// grouping a whole index at once
+type Index struct {
+	buckets map[string][]string
+	prints  map[string]Fingerprint
+}
+
+// NewIndex creates an empty index
+func NewIndex() *Index {
+	return &Index{
+		buckets: make(map[string][]string),
+		prints:  make(map[string]Fingerprint),
+	}
+}
+
+// Add stores a fingerprint under id. Unusable fingerprints are ignored.
+func (x *Index) Add(id string, fp Fingerprint) {
+	if !fp.Usable() {
+		return
+	}
+
+	x.prints[id] = fp
+	for _, key := range fp.Bands() {
+		x.buckets[key] = append(x.buckets[key], id)
+	}
+}
+
+// Match returns the id of the most similar stored recipe that is a
+// duplicate of fp, or false if there is none
+func (x *Index) Match(fp Fingerprint) (string, bool) {
+	best, bestScore := "", -1.0
+	checked := make(map[string]bool)
+
+	for _, key := range fp.Bands() {
+		for _, id := range x.buckets[key] {
+			if checked[id] {
+				continue
+			}
+			checked[id] = true
+
+			candidate := x.prints[id]
+			if !Duplicate(fp, candidate) {
+				continue
+			}
+			if score, _ := Similarity(fp, candidate); score > bestScore {
+				best, bestScore = id, score
+			}
+		}
+	}
+
+	return best, bestScore >= 0
+}
@@ -0,0 +1,306 @@
+package fixtures
+
+// Golden-file regression fixtures for recipe extraction
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"search-engine-indexer/src/scraper"
+)
+
+// DefaultDir is where fixtures live relative to the pantry directory
+const DefaultDir = "fixtures"
+
+// urlComment records the page URL on the first line of a fixture's HTML
+// so extraction sees the same URL (and site config) as a live crawl
+var urlComment = regexp.MustCompile(`^\s*<!--\s*fixture-url:\s*(\S+)\s*-->`)
+
+// capturedComment follows the URL header on pages saved by Capture or
+// Recapture, recording when the live page was fetched
+var capturedComment = regexp.MustCompile(`^\s*<!--\s*fixture-url:\s*\S+\s*-->\s*<!--\s*fixture-captured:\s*(\S+)\s*-->`)
+
+// Fixture is a saved HTML page and its expected extraction output, stored
+// as <dir>/<domain>/<name>.html and <dir>/<domain>/<name>.json
+type Fixture struct {
+	Domain     string
+	Name       string
+	URL        string
+	HTMLPath   string
+	GoldenPath string
+
+	// Captured is when the page was fetched from the live site, and zero
+	// for pages written by hand
+	Captured time.Time
+}
+
+// FieldDiff is a single field whose extracted value differs from the
+// golden file
+type FieldDiff struct {
+	Field    string
+	Expected string
+	Actual   string
+}
+
+// Load finds every fixture under dir
+func Load(dir string) ([]Fixture, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*", "*.html"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	fixtures := make([]Fixture, 0, len(paths))
+	for _, path := range paths {
+		f, err := loadFixture(path)
+		if err != nil {
+			return nil, err
+		}
+		fixtures = append(fixtures, f)
+	}
+
+	return fixtures, nil
+}
+
+// loadFixture reads the URL header of a fixture's HTML file
+func loadFixture(path string) (Fixture, error) {
+	html, err := os.ReadFile(path)
+	if err != nil {
+		return Fixture{}, err
+	}
+
+	matches := urlComment.FindSubmatch(html)
+	if matches == nil {
+		return Fixture{}, fmt.Errorf("%s: missing <!-- fixture-url: URL --> header", path)
+	}
+
+	var captured time.Time
+	if m := capturedComment.FindSubmatch(html); m != nil {
+		captured, _ = time.Parse(time.RFC3339, string(m[1]))
+	}
+
+	name := strings.TrimSuffix(filepath.Base(path), ".html")
+	return Fixture{
+		Domain:     filepath.Base(filepath.Dir(path)),
+		Name:       name,
+		URL:        string(matches[1]),
+		HTMLPath:   path,
+		GoldenPath: strings.TrimSuffix(path, ".html") + ".json",
+		Captured:   captured,
+	}, nil
+}
+
+// Extract runs GetRecipeData over the fixture's HTML. Each field's source
+// and confidence are added as "extraction.<field>", e.g. "json-ld 0.9",
+// so a field that starts coming from another step shows up as a diff.
+func (f Fixture) Extract() (map[string]string, error) {
+	html, err := os.ReadFile(f.HTMLPath)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := scraper.NewScraperFromBody(f.URL, html)
+	if err != nil {
+		return nil, fmt.Errorf("%s: parse HTML: %w", f.HTMLPath, err)
+	}
+
+	data := s.GetRecipeData()
+	result := make(map[string]string, len(data.Values)+len(data.Extraction))
+	for field, value := range data.Values {
+		result[field] = value
+	}
+	for field, extraction := range data.Extraction {
+		result["extraction."+field] = fmt.Sprintf("%s %g", extraction.Source, extraction.Confidence)
+	}
+
+	return result, nil
+}
+
+// Golden reads the expected extraction output
+func (f Fixture) Golden() (map[string]string, error) {
+	data, err := os.ReadFile(f.GoldenPath)
+	if err != nil {
+		return nil, err
+	}
+
+	golden := make(map[string]string)
+	if err := json.Unmarshal(data, &golden); err != nil {
+		return nil, fmt.Errorf("%s: %w", f.GoldenPath, err)
+	}
+
+	return golden, nil
+}
+
+// Verify re-extracts the fixture and compares it field by field with the
+// golden file
+func (f Fixture) Verify() ([]FieldDiff, error) {
+	expected, err := f.Golden()
+	if err != nil {
+		return nil, err
+	}
+
+	actual, err := f.Extract()
+	if err != nil {
+		return nil, err
+	}
+
+	return Diff(expected, actual), nil
+}
+
+// Update regenerates the golden file from the current extraction output
+func (f Fixture) Update() error {
+	actual, err := f.Extract()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(actual, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(f.GoldenPath, append(data, '\n'), 0644)
+}
+
+// Diff compares two extraction results and returns the differing fields
+// in name order
+func Diff(expected, actual map[string]string) []FieldDiff {
+	fields := make(map[string]bool)
+	for field := range expected {
+		fields[field] = true
+	}
+	for field := range actual {
+		fields[field] = true
+	}
+
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+
+	diffs := []FieldDiff{}
+	for _, field := range names {
+		if expected[field] != actual[field] {
+			diffs = append(diffs, FieldDiff{
+				Field:    field,
+				Expected: expected[field],
+				Actual:   actual[field],
+			})
+		}
+	}
+
+	return diffs
+}
+
+// Capture fetches a URL with the scraper's current fetcher and saves it
+// as a new fixture with a freshly generated golden file
+func Capture(dir, u string) (Fixture, error) {
+	body, err := fetchPage(u)
+	if err != nil {
+		return Fixture{}, err
+	}
+
+	domain := scraper.SiteDomain(u)
+	if domain == "" {
+		return Fixture{}, fmt.Errorf("no RecipeSite config matches %s", u)
+	}
+
+	domainDir := filepath.Join(dir, domain)
+	if err := os.MkdirAll(domainDir, 0755); err != nil {
+		return Fixture{}, err
+	}
+
+	path := filepath.Join(domainDir, fixtureName(u)+".html")
+	if err := writePage(path, u, body); err != nil {
+		return Fixture{}, err
+	}
+
+	f, err := loadFixture(path)
+	if err != nil {
+		return Fixture{}, err
+	}
+	return f, f.Update()
+}
+
+// Recapture fetches the fixture's URL again, replaces its saved HTML with
+// the live page and regenerates the golden file
+func (f Fixture) Recapture() error {
+	body, err := fetchPage(f.URL)
+	if err != nil {
+		return err
+	}
+	if err := writePage(f.HTMLPath, f.URL, body); err != nil {
+		return err
+	}
+	return f.Update()
+}
+
+// fetchPage returns the body of a page fetched with the scraper's current
+// fetcher
+func fetchPage(u string) ([]byte, error) {
+	response, err := scraper.DefaultFetcher.Fetch(u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		return nil, fmt.Errorf("status code %d for %s", response.StatusCode, u)
+	}
+	return response.Body, nil
+}
+
+// writePage saves a fetched page behind the headers naming its URL and
+// when it was captured
+func writePage(path, u string, body []byte) error {
+	header := fmt.Sprintf("<!-- fixture-url: %s -->\n<!-- fixture-captured: %s -->\n", u, time.Now().UTC().Format(time.RFC3339))
+	return os.WriteFile(path, append([]byte(header), body...), 0644)
+}
+
+// fixtureName derives a file name from the last path segment of a URL
+func fixtureName(u string) string {
+	u = strings.TrimRight(u, "/")
+	if i := strings.Index(u, "?"); i >= 0 {
+		u = u[:i]
+	}
+	name := u[strings.LastIndex(u, "/")+1:]
+	name = regexp.MustCompile(`[^A-Za-z0-9_-]+`).ReplaceAllString(name, "-")
+	if name == "" {
+		name = "index"
+	}
+	return name
+}
+
+// Uncaptured returns the fixtures whose pages weren't fetched from the
+// live site. Their goldens only check extraction against hand-written
+// markup, so they can't catch a site redesign.
+func Uncaptured(fixtures []Fixture) []Fixture {
+	uncaptured := []Fixture{}
+	for _, f := range fixtures {
+		if f.Captured.IsZero() {
+			uncaptured = append(uncaptured, f)
+		}
+	}
+	return uncaptured
+}
+
+// MissingDomains returns the RecipeSite domains that have no fixtures
+func MissingDomains(fixtures []Fixture) []string {
+	covered := make(map[string]bool)
+	for _, f := range fixtures {
+		covered[f.Domain] = true
+	}
+
+	missing := []string{}
+	for _, domain := range scraper.SiteDomains() {
+		if !covered[domain] {
+			missing = append(missing, domain)
+		}
+	}
+
+	return missing
+}
@@ -0,0 +1,97 @@
+package fixtures
+
+// Golden ingredient lines for the ingredient parser
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"search-engine-indexer/src/ingredient"
+)
+
+// IngredientsFile holds ingredient lines taken from real recipes and how
+// each should parse. It lives in the fixtures directory.
+const IngredientsFile = "ingredients.json"
+
+// IngredientCase is one ingredient line and its expected parse, field by
+// field. Empty fields are left out.
+type IngredientCase struct {
+	Line     string            `json:"line"`
+	Expected map[string]string `json:"expected"`
+}
+
+// LoadIngredientCases reads the ingredient lines in dir. A directory
+// without the file has no cases.
+func LoadIngredientCases(dir string) ([]IngredientCase, error) {
+	path := filepath.Join(dir, IngredientsFile)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cases := []IngredientCase{}
+	if err := json.Unmarshal(data, &cases); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cases, nil
+}
+
+// ParseIngredientLine runs the ingredient parser over a line and returns
+// its fields as strings
+func ParseIngredientLine(line string) map[string]string {
+	p := ingredient.Parse(line)
+
+	fields := make(map[string]string)
+	set := func(field, value string) {
+		if value != "" {
+			fields[field] = value
+		}
+	}
+	set("quantity", p.Quantity)
+	if p.Max > 0 {
+		set("min", strconv.FormatFloat(p.Min, 'f', -1, 64))
+		set("max", strconv.FormatFloat(p.Max, 'f', -1, 64))
+	}
+	set("unit", string(p.Unit))
+	set("ingredient", p.Ingredient)
+	set("name", p.Name)
+	set("preparation", p.Preparation)
+	set("notes", p.Notes)
+	if p.Optional {
+		set("optional", "true")
+	}
+
+	return fields
+}
+
+// Verify re-parses the line and compares it field by field with the
+// expected parse
+func (c IngredientCase) Verify() []FieldDiff {
+	return Diff(c.Expected, ParseIngredientLine(c.Line))
+}
+
+// UpdateIngredientCases regenerates the expected parse of every line in
+// dir from the current parser
+func UpdateIngredientCases(dir string, cases []IngredientCase) error {
+	for i := range cases {
+		cases[i].Expected = ParseIngredientLine(cases[i].Line)
+	}
+
+	// Lines like "M&M's" stay readable without HTML escaping
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(cases); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, IngredientsFile), data.Bytes(), 0644)
+}
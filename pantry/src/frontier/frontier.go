@@ -0,0 +1,627 @@
+package frontier
+
+// Disk-backed crawl frontier
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"search-engine-indexer/src/canonical"
+)
+
+// State is the lifecycle state of a URL in the frontier
+type State string
+
+const (
+	StatePending  State = "pending"
+	StateInFlight State = "in_flight"
+	StateDone     State = "done"
+	StateFailed   State = "failed"
+	StateBlocked  State = "blocked"
+)
+
+// States lists every frontier state in lifecycle order
+var States = []State{StatePending, StateInFlight, StateDone, StateFailed, StateBlocked}
+
+var (
+	// urlsBucket maps a canonical URL to its JSON encoded Entry, so
+	// variants of a page are only queued once
+	urlsBucket = []byte("urls")
+
+	// pendingBucket maps a sequence number to a canonical URL so pending
+	// work is FIFO
+	pendingBucket = []byte("pending")
+
+	// refreshBucket is a second FIFO for known URLs queued for a re-check.
+	// It is only drained once pendingBucket is empty so never-seen URLs
+	// are crawled first.
+	refreshBucket = []byte("refresh")
+
+	// childrenBucket maps "parent\x00child", both canonical, to nothing so
+	// the crawl tree can be walked from any URL
+	childrenBucket = []byte("children")
+
+	// sitesBucket maps a source site to the number of its URLs held in
+	// the frontier, in any state. Prune gives pruned URLs back.
+	sitesBucket = []byte("sites")
+
+	// pagesBucket maps a canonical URL to its JSON encoded PageState. It outlives
+	// the URL's entry so pruned URLs can still be fetched conditionally.
+	pagesBucket = []byte("pages")
+)
+
+var (
+	// ErrKnown is returned by Add when the URL is already in the frontier
+	ErrKnown = errors.New("url already in frontier")
+
+	// ErrBudgetExhausted is returned by Add when the URL's site has
+	// already queued SiteBudget pages
+	ErrBudgetExhausted = errors.New("site page budget exhausted")
+)
+
+// Item is a URL waiting to be crawled along with where it came from. URL
+// is fetched as it was found; Canonical is its canonical form, which the
+// frontier is keyed on, and is filled in by Add.
+type Item struct {
+	URL          string    `json:"url"`
+	Canonical    string    `json:"canonical,omitempty"`
+	Depth        int       `json:"depth"`
+	Parent       string    `json:"parent,omitempty"`
+	Site         string    `json:"site"`
+	DiscoveredAt time.Time `json:"discovered_at"`
+
+	// LastMod is the page's last modification time when it was found in
+	// a sitemap
+	LastMod time.Time `json:"lastmod,omitempty"`
+}
+
+// Entry is a single Item tracked by the frontier with its crawl state
+type Entry struct {
+	Item
+	State     State     `json:"state"`
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Frontier records pending, in-flight, done and failed URLs in a bbolt file
+// so a crawl can be resumed after a crash or timeout
+type Frontier struct {
+	db *bolt.DB
+
+	// SiteBudget caps how many URLs a site may have in the frontier at
+	// once, in any state. Pruning URLs frees room for new ones, so a
+	// long-running crawl can keep finding recipes. Zero means no limit.
+	SiteBudget int
+}
+
+// Open opens (or creates) the frontier stored at path
+func Open(path string) (*Frontier, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open frontier %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{urlsBucket, pendingBucket, refreshBucket, childrenBucket, sitesBucket, pagesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initialize frontier %s: %w", path, err)
+	}
+
+	return &Frontier{db: db}, nil
+}
+
+// Close closes the underlying database file
+func (f *Frontier) Close() error {
+	return f.db.Close()
+}
+
+// Add queues an item. It returns ErrKnown if the URL, or another URL with
+// the same canonical form, is already in the frontier in any state, and
+// ErrBudgetExhausted if its site is over budget.
+func (f *Frontier) Add(item Item) error {
+	if item.DiscoveredAt.IsZero() {
+		item.DiscoveredAt = time.Now()
+	}
+	if item.Canonical == "" {
+		item.Canonical = canonical.URL(item.URL)
+	}
+
+	return f.db.Update(func(tx *bolt.Tx) error {
+		urls := tx.Bucket(urlsBucket)
+		if urls.Get([]byte(item.Canonical)) != nil {
+			return ErrKnown
+		}
+
+		sites := tx.Bucket(sitesBucket)
+		queued := getCount(sites, item.Site)
+		if f.SiteBudget > 0 && queued >= f.SiteBudget {
+			return ErrBudgetExhausted
+		}
+
+		entry := Entry{
+			Item:      item,
+			State:     StatePending,
+			UpdatedAt: item.DiscoveredAt,
+		}
+		if err := putEntry(urls, entry); err != nil {
+			return err
+		}
+		if err := pushPending(tx.Bucket(pendingBucket), item.Canonical); err != nil {
+			return err
+		}
+		if item.Parent != "" {
+			if err := tx.Bucket(childrenBucket).Put(childKey(canonical.URL(item.Parent), item.Canonical), nil); err != nil {
+				return err
+			}
+		}
+
+		return putCount(sites, item.Site, queued+1)
+	})
+}
+
+// Get returns the entry for a URL, or for the URL it has the same
+// canonical form as
+func (f *Frontier) Get(url string) (Entry, bool) {
+	var entry Entry
+	found := false
+
+	f.db.View(func(tx *bolt.Tx) error {
+		entry, found = getEntry(tx.Bucket(urlsBucket), url)
+		return nil
+	})
+
+	return entry, found
+}
+
+// Children returns the entries that were discovered on the given URL
+func (f *Frontier) Children(url string) []Entry {
+	children := []Entry{}
+
+	f.db.View(func(tx *bolt.Tx) error {
+		urls := tx.Bucket(urlsBucket)
+		prefix := childKey(canonical.URL(url), "")
+
+		c := tx.Bucket(childrenBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			if entry, ok := getEntry(urls, string(k[len(prefix):])); ok {
+				children = append(children, entry)
+			}
+		}
+		return nil
+	})
+
+	return children
+}
+
+// Ancestors returns the chain of referrers for a URL, nearest first
+func (f *Frontier) Ancestors(url string) []Entry {
+	ancestors := []Entry{}
+
+	f.db.View(func(tx *bolt.Tx) error {
+		urls := tx.Bucket(urlsBucket)
+		seen := map[string]bool{canonical.URL(url): true}
+
+		entry, ok := getEntry(urls, url)
+		for ok && entry.Parent != "" && !seen[canonical.URL(entry.Parent)] {
+			seen[canonical.URL(entry.Parent)] = true
+			entry, ok = getEntry(urls, entry.Parent)
+			if ok {
+				ancestors = append(ancestors, entry)
+			}
+		}
+		return nil
+	})
+
+	return ancestors
+}
+
+// SiteCounts returns how many URLs each site has in the frontier
+func (f *Frontier) SiteCounts() map[string]int {
+	counts := make(map[string]int)
+
+	f.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(sitesBucket).ForEach(func(k, v []byte) error {
+			counts[string(k)] = int(binary.BigEndian.Uint64(v))
+			return nil
+		})
+	})
+
+	return counts
+}
+
+// Next pops the oldest pending URL, preferring never-seen URLs over
+// refreshes, and marks it in-flight. It returns false when nothing is
+// pending.
+func (f *Frontier) Next() (Entry, bool) {
+	var entry Entry
+	found := false
+
+	err := f.db.Update(func(tx *bolt.Tx) error {
+		urls := tx.Bucket(urlsBucket)
+
+		for _, name := range [][]byte{pendingBucket, refreshBucket} {
+			c := tx.Bucket(name).Cursor()
+			for k, v := c.First(); k != nil; k, v = c.First() {
+				url := string(v)
+				if err := c.Delete(); err != nil {
+					return err
+				}
+
+				current, ok := getEntry(urls, url)
+				if !ok || current.State != StatePending {
+					// Stale pointer left behind by a prune or state change
+					continue
+				}
+
+				current.State = StateInFlight
+				current.Attempts++
+				current.UpdatedAt = time.Now()
+				if err := putEntry(urls, current); err != nil {
+					return err
+				}
+
+				entry = current
+				found = true
+				return nil
+			}
+		}
+
+		return nil
+	})
+
+	return entry, err == nil && found
+}
+
+// Refresh queues a known URL that has finished or failed to be checked
+// again, behind every never-seen URL. A non-zero lastMod replaces the
+// entry's sitemap lastmod. It returns false if the URL is unknown or
+// already pending or in-flight.
+func (f *Frontier) Refresh(url string, lastMod time.Time) (bool, error) {
+	queued := false
+
+	err := f.db.Update(func(tx *bolt.Tx) error {
+		urls := tx.Bucket(urlsBucket)
+
+		entry, ok := getEntry(urls, url)
+		if !ok || (entry.State != StateDone && entry.State != StateFailed) {
+			return nil
+		}
+
+		entry.State = StatePending
+		entry.Error = ""
+		entry.UpdatedAt = time.Now()
+		if !lastMod.IsZero() {
+			entry.LastMod = lastMod
+		}
+		if err := putEntry(urls, entry); err != nil {
+			return err
+		}
+
+		queued = true
+		return pushPending(tx.Bucket(refreshBucket), entry.key())
+	})
+
+	return queued, err
+}
+
+// Stale returns up to limit done entries accepted by match whose page was
+// last checked before cutoff, oldest check first. Entries that were never
+// checked count as stale.
+func (f *Frontier) Stale(cutoff time.Time, limit int, match func(Entry) bool) []Entry {
+	type staleEntry struct {
+		entry   Entry
+		checked time.Time
+	}
+	stale := []staleEntry{}
+
+	f.db.View(func(tx *bolt.Tx) error {
+		pages := tx.Bucket(pagesBucket)
+
+		return tx.Bucket(urlsBucket).ForEach(func(k, v []byte) error {
+			var entry Entry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return nil
+			}
+			if entry.State != StateDone || !match(entry) {
+				return nil
+			}
+
+			var state PageState
+			if data := pages.Get(k); data != nil {
+				json.Unmarshal(data, &state)
+			}
+			if state.LastChecked.Before(cutoff) {
+				stale = append(stale, staleEntry{entry: entry, checked: state.LastChecked})
+			}
+			return nil
+		})
+	})
+
+	sort.Slice(stale, func(i, j int) bool {
+		return stale[i].checked.Before(stale[j].checked)
+	})
+
+	entries := []Entry{}
+	for _, s := range stale {
+		if limit > 0 && len(entries) >= limit {
+			break
+		}
+		entries = append(entries, s.entry)
+	}
+
+	return entries
+}
+
+// MarkDone records that a URL was crawled successfully
+func (f *Frontier) MarkDone(url string) {
+	f.setState(url, StateDone, "")
+}
+
+// MarkFailed records that a URL could not be crawled
+func (f *Frontier) MarkFailed(url string, reason string) {
+	f.setState(url, StateFailed, reason)
+}
+
+// MarkBlocked records that a URL was not crawled because the site's
+// robots.txt disallows it
+func (f *Frontier) MarkBlocked(url string, reason string) {
+	f.setState(url, StateBlocked, reason)
+}
+
+// setState moves a known URL to a terminal state
+func (f *Frontier) setState(url string, state State, reason string) {
+	f.db.Update(func(tx *bolt.Tx) error {
+		urls := tx.Bucket(urlsBucket)
+		entry, ok := getEntry(urls, url)
+		if !ok {
+			return nil
+		}
+
+		entry.State = state
+		entry.Error = reason
+		entry.UpdatedAt = time.Now()
+		return putEntry(urls, entry)
+	})
+}
+
+// Requeue moves every URL in the given state back to pending and returns
+// how many were moved. It is used on startup to recover in-flight work
+// that was interrupted, and to retry failed URLs.
+func (f *Frontier) Requeue(state State) (int, error) {
+	moved := 0
+
+	err := f.db.Update(func(tx *bolt.Tx) error {
+		urls := tx.Bucket(urlsBucket)
+		pending := tx.Bucket(pendingBucket)
+
+		var entries []Entry
+		err := urls.ForEach(func(k, v []byte) error {
+			var entry Entry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return nil
+			}
+			if entry.State == state {
+				entries = append(entries, entry)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			entry.State = StatePending
+			entry.UpdatedAt = time.Now()
+			if err := putEntry(urls, entry); err != nil {
+				return err
+			}
+			if err := pushPending(pending, entry.key()); err != nil {
+				return err
+			}
+			moved++
+		}
+
+		return nil
+	})
+
+	return moved, err
+}
+
+// Prune removes URLs in the given state that were last updated before
+// the cutoff and returns how many were removed. A zero cutoff removes
+// every URL in that state. Removed URLs no longer count against their
+// site's budget.
+func (f *Frontier) Prune(state State, cutoff time.Time) (int, error) {
+	removed := 0
+
+	err := f.db.Update(func(tx *bolt.Tx) error {
+		urls := tx.Bucket(urlsBucket)
+
+		var keys [][]byte
+		pruned := make(map[string]int)
+		err := urls.ForEach(func(k, v []byte) error {
+			var entry Entry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return nil
+			}
+			if entry.State != state {
+				return nil
+			}
+			if !cutoff.IsZero() && entry.UpdatedAt.After(cutoff) {
+				return nil
+			}
+			keys = append(keys, append([]byte(nil), k...))
+			pruned[entry.Site]++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		children := tx.Bucket(childrenBucket)
+		for _, k := range keys {
+			if err := urls.Delete(k); err != nil {
+				return err
+			}
+			if err := deleteChildren(children, string(k)); err != nil {
+				return err
+			}
+			removed++
+		}
+
+		sites := tx.Bucket(sitesBucket)
+		for site, n := range pruned {
+			left := getCount(sites, site) - n
+			if left < 0 {
+				left = 0
+			}
+			if err := putCount(sites, site, left); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return removed, err
+}
+
+// Counts returns the number of URLs in each state
+func (f *Frontier) Counts() map[State]int {
+	counts := make(map[State]int)
+
+	f.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(urlsBucket).ForEach(func(k, v []byte) error {
+			var entry Entry
+			if err := json.Unmarshal(v, &entry); err == nil {
+				counts[entry.State]++
+			}
+			return nil
+		})
+	})
+
+	return counts
+}
+
+// List returns up to limit entries in the given state. A limit of zero
+// or less returns every matching entry.
+func (f *Frontier) List(state State, limit int) []Entry {
+	entries := []Entry{}
+
+	f.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(urlsBucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var entry Entry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				continue
+			}
+			if entry.State != state {
+				continue
+			}
+			entries = append(entries, entry)
+			if limit > 0 && len(entries) >= limit {
+				break
+			}
+		}
+		return nil
+	})
+
+	return entries
+}
+
+// key returns the canonical URL an item is stored under. Items added
+// before Canonical existed were stored under URL, which was canonical.
+func (i Item) key() string {
+	if i.Canonical != "" {
+		return i.Canonical
+	}
+	return canonical.URL(i.URL)
+}
+
+// getEntry reads the entry for a URL, in any form, from the urls bucket
+func getEntry(urls *bolt.Bucket, url string) (Entry, bool) {
+	var entry Entry
+
+	data := urls.Get([]byte(canonical.URL(url)))
+	if data == nil {
+		return entry, false
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return entry, false
+	}
+
+	return entry, true
+}
+
+// putEntry writes an entry to the urls bucket
+func putEntry(urls *bolt.Bucket, entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return urls.Put([]byte(entry.key()), data)
+}
+
+// childKey builds the children bucket key for a parent and child URL
+func childKey(parent, child string) []byte {
+	return []byte(parent + "\x00" + child)
+}
+
+// deleteChildren removes the crawl tree edges leading out of a URL
+func deleteChildren(children *bolt.Bucket, parent string) error {
+	prefix := childKey(parent, "")
+
+	var keys [][]byte
+	c := children.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+
+	for _, k := range keys {
+		if err := children.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getCount reads a counter from a bucket
+func getCount(b *bolt.Bucket, key string) int {
+	data := b.Get([]byte(key))
+	if len(data) != 8 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(data))
+}
+
+// putCount writes a counter to a bucket
+func putCount(b *bolt.Bucket, key string, n int) error {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(n))
+	return b.Put([]byte(key), data)
+}
+
+// pushPending appends a URL to the end of the pending queue
+func pushPending(pending *bolt.Bucket, url string) error {
+	seq, err := pending.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return pending.Put(key, []byte(url))
+}
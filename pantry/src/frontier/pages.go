@@ -0,0 +1,52 @@
+package frontier
+
+import (
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"search-engine-indexer/src/canonical"
+)
+
+// PageState is what the last fetch of a URL told us about its content.
+// It is used to make conditional requests and to skip pages that haven't
+// changed.
+type PageState struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	ContentHash  string `json:"content_hash,omitempty"`
+
+	// LastChecked is when the URL was last fetched, LastChanged is when
+	// its content was last seen to differ from the previous fetch
+	LastChecked time.Time `json:"last_checked"`
+	LastChanged time.Time `json:"last_changed"`
+}
+
+// Page returns the stored state for a URL, kept under its canonical form
+func (f *Frontier) Page(url string) (PageState, bool) {
+	var state PageState
+	found := false
+
+	f.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(pagesBucket).Get([]byte(canonical.URL(url)))
+		if data == nil {
+			return nil
+		}
+		found = json.Unmarshal(data, &state) == nil
+		return nil
+	})
+
+	return state, found
+}
+
+// SetPage stores the state for a URL
+func (f *Frontier) SetPage(url string, state PageState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return f.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(pagesBucket).Put([]byte(canonical.URL(url)), data)
+	})
+}
@@ -0,0 +1,590 @@
+package ingredient
+
+// Ingredient line parsing: amounts, canonical units, preparation and notes
+import (
+	"html"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Parsed is one ingredient line split into its parts
+type Parsed struct {
+	// Quantity is the amount as written, e.g. "1 1/2" or "1-2", and Min
+	// and Max its value. Min and Max are equal unless the line gives a
+	// range, and both are 0 when it has no amount.
+	Quantity string
+	Min      float64
+	Max      float64
+	Unit     Unit
+
+	// Ingredient is what's left of the line once the amount, unit,
+	// preparation and notes are taken out, and Name its normalized keyword
+	Ingredient  string
+	Name        string
+	Preparation string
+	Notes       string
+	Optional    bool
+}
+
+type tokenKind int
+
+const (
+	numberToken tokenKind = iota
+	wordToken
+	punctToken
+)
+
+// token is a number, word or punctuation mark, with its byte offsets in
+// the normalized line
+type token struct {
+	kind  tokenKind
+	text  string
+	start int
+	end   int
+	value float64
+}
+
+var (
+	numberRegex = regexp.MustCompile(`^(?:\d+(?:\.\d+)?(?:/\d+)?|\.\d+)`)
+	wordRegex   = regexp.MustCompile(`^\p{L}+(?:['-]\p{L}+)*`)
+	labelRegex  = regexp.MustCompile(`^(\p{L}[\p{L} '&-]{0,40}):\s+(.+)$`)
+	trailRegex  = regexp.MustCompile(`(?i)\s+((?:to taste|as needed|for (?:serving|garnish|garnishing|topping|frying|greasing|dusting|drizzling|brushing|the \p{L}+)).*)$`)
+	parenRegex  = regexp.MustCompile(`\(([^()]*)\)`)
+
+	normalizer = strings.NewReplacer(
+		"½", " 1/2", "⅓", " 1/3", "⅔", " 2/3", "¼", " 1/4", "¾", " 3/4",
+		"⅕", " 1/5", "⅛", " 1/8", "⅜", " 3/8", "⅝", " 5/8", "⅞", " 7/8",
+		"⁄", "/", " ", " ", "–", "-", "—", "-", "’", "'", "‘", "'",
+		"“", "\"", "”", "\"", "″", "\"",
+	)
+)
+
+// numberWords are amounts spelled out at the start of a line
+var numberWords = map[string]float64{
+	"one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6,
+	"seven": 7, "eight": 8, "nine": 9, "ten": 10, "eleven": 11, "twelve": 12,
+}
+
+// preparationWords can start a line's ingredient, as in "chopped fresh
+// parsley", and are moved to its preparation. Words that name a different
+// product, like "ground" beef or "dried" oregano, aren't included.
+var preparationWords = map[string]bool{
+	"chopped": true, "diced": true, "minced": true, "sliced": true, "grated": true,
+	"shredded": true, "crushed": true, "melted": true, "softened": true, "cubed": true,
+	"peeled": true, "halved": true, "quartered": true, "trimmed": true, "beaten": true,
+	"sifted": true, "packed": true, "mashed": true, "torn": true, "julienned": true,
+	"pitted": true, "seeded": true, "cooked": true, "drained": true, "rinsed": true,
+	"thawed": true, "cut": true, "zested": true, "juiced": true, "deveined": true,
+	"cored": true, "shelled": true, "crumbled": true, "whisked": true, "broken": true,
+}
+
+// notPreparation end in "-ed" but describe how an ingredient is used
+var notPreparation = map[string]bool{
+	"divided": true, "needed": true, "desired": true, "preferred": true, "reserved": true,
+}
+
+// noteWords start a clause that's a note rather than preparation, as in
+// "plus more for serving" or "such as Yukon Gold"
+var noteWords = map[string]bool{
+	"plus": true, "such": true, "about": true, "from": true, "for": true, "to": true,
+	"or": true, "at": true, "if": true, "see": true, "preferably": true, "depending": true,
+}
+
+// Parse splits one ingredient line into its amount, unit, ingredient,
+// preparation and notes, e.g. "1 (15-oz.) can black beans, drained"
+// becomes 1 can of "black beans", prepared "drained", with the note
+// "15-oz."
+func Parse(line string) Parsed {
+	text := normalize(line)
+	p := Parsed{}
+
+	// "Cheese: Grate your own Parmesan" names the ingredient before the
+	// colon and describes it after
+	notes := []string{}
+	if match := labelRegex.FindStringSubmatch(text); match != nil {
+		switch label := strings.ToLower(match[1]); {
+		case label == "optional":
+			p.Optional = true
+			text = match[2]
+		case strings.HasPrefix(label, "for "):
+			// A section heading: "For the dough: 2 c. flour"
+			notes = append(notes, match[1])
+			text = match[2]
+		default:
+			p.Ingredient = strings.TrimSpace(match[1])
+			p.Notes = strings.TrimSpace(match[2])
+			p.Name = Name(p.Ingredient)
+			return p
+		}
+	}
+
+	tokens := tokenize(text)
+	i := 0
+
+	if min, max, n := readQuantity(tokens, 0); n > 0 {
+		p.Quantity = text[tokens[0].start:tokens[n-1].end]
+		p.Min, p.Max = min, max
+		i = n
+	} else if len(tokens) > 1 && (strings.EqualFold(tokens[0].text, "a") || strings.EqualFold(tokens[0].text, "an")) {
+		// "a pinch of salt"
+		if _, n := readUnit(tokens, 1); n > 0 {
+			p.Quantity = tokens[0].text
+			p.Min, p.Max = 1, 1
+			i = 1
+		}
+	} else if unit, n := readUnit(tokens, 0); n > 0 && n+1 < len(tokens) {
+		// "Dash of hot sauce" and "Pinch cayenne pepper"
+		if strings.EqualFold(tokens[n].text, "of") {
+			p.Unit = unit
+			i = n + 1
+		} else if smallUnits[unit] {
+			p.Unit = unit
+			i = n
+		}
+	}
+
+	// "2 dozen eggs"
+	if i > 0 && i < len(tokens) && strings.EqualFold(tokens[i].text, "dozen") {
+		p.Quantity = text[tokens[0].start:tokens[i].end]
+		p.Min, p.Max = p.Min*12, p.Max*12
+		i++
+	}
+
+	if i > 0 {
+		// "1 (14-oz.) can": the size of each container
+		if i < len(tokens) && tokens[i].text == "(" {
+			if end := closingParen(tokens, i); end > i {
+				if inner := strings.TrimSpace(text[tokens[i].end:tokens[end].start]); inner != "" {
+					notes = append(notes, inner)
+				}
+				i = end + 1
+			}
+		}
+
+		// "2 x 400g cans"
+		if i+1 < len(tokens) && (strings.EqualFold(tokens[i].text, "x") || tokens[i].text == "×") && tokens[i+1].kind == numberToken {
+			i++
+		}
+
+		// The same without parentheses: "1 14-oz. can", "4 6-oz. salmon
+		// fillets" or "1 1" piece ginger"
+		if _, _, n := readQuantity(tokens, i); n > 0 {
+			j := i + n
+			hyphen := j < len(tokens) && tokens[j].text == "-"
+			if hyphen {
+				j++
+			}
+			if _, m := readUnit(tokens, j); m > 0 {
+				if unit, k := readUnit(tokens, j+m); hyphen || (k > 0 && containerUnits[unit]) {
+					notes = append(notes, text[tokens[i].start:tokens[j+m-1].end])
+					i = j + m
+				}
+			} else if !hyphen && j < len(tokens) && tokens[j].text == "\"" {
+				notes = append(notes, text[tokens[i].start:tokens[j].end])
+				i = j + 1
+			}
+		}
+
+		// "2 heaping tbsp." or "1 large can", but not "2 large eggs"
+		size := ""
+		if i < len(tokens) && sizeWords[strings.ToLower(tokens[i].text)] {
+			if _, n := readUnit(tokens, i+1); n > 0 {
+				size = tokens[i].text
+				i++
+			}
+		}
+
+		if unit, n := readUnit(tokens, i); n > 0 {
+			// A unit with nothing after it is the ingredient: "4 cloves"
+			if i+n < len(tokens) {
+				p.Unit = unit
+				i += n
+				if size != "" {
+					notes = append(notes, size)
+				}
+				if i < len(tokens) && strings.EqualFold(tokens[i].text, "of") {
+					i++
+				}
+			}
+		}
+
+		// "1 c. (240 g.) flour": the same amount in other units
+		if p.Unit != NoUnit && i < len(tokens) && tokens[i].text == "(" {
+			if end := closingParen(tokens, i); end > i && end+1 < len(tokens) {
+				if inner := strings.TrimSpace(text[tokens[i].end:tokens[end].start]); inner != "" {
+					notes = append(notes, inner)
+				}
+				i = end + 1
+			}
+		}
+
+		// "1/4 c. plus 2 tbsp. sugar" keeps the first amount and notes the
+		// second
+		if p.Unit != NoUnit && i < len(tokens) && strings.EqualFold(tokens[i].text, "plus") {
+			if _, _, n := readQuantity(tokens, i+1); n > 0 {
+				if _, m := readUnit(tokens, i+1+n); m > 0 && i+1+n+m < len(tokens) {
+					notes = append(notes, text[tokens[i].start:tokens[i+n+m].end])
+					i += 1 + n + m
+				}
+			}
+		}
+	}
+
+	rest := ""
+	if i < len(tokens) {
+		rest = text[tokens[i].start:]
+	}
+
+	ingredient, preparation, restNotes, optional := splitRest(rest)
+	p.Ingredient = ingredient
+	p.Preparation = preparation
+	p.Notes = strings.Join(append(notes, restNotes...), "; ")
+	p.Optional = p.Optional || optional
+	p.Name = Name(p.Ingredient)
+	return p
+}
+
+// FromParts builds a Parsed from an ingredient that a recipe plugin's
+// markup already splits into amount, unit, name and notes. Only the name
+// and notes are split further, into the ingredient, its preparation and
+// notes. A unit that isn't a known one, like "large" in "2 large eggs",
+// stays with the ingredient.
+func FromParts(amount, unit, name, notes string) Parsed {
+	p := Parsed{Quantity: normalize(amount)}
+	if min, max, n := readQuantity(tokenize(p.Quantity), 0); n > 0 {
+		p.Min, p.Max = min, max
+	}
+
+	unit = normalize(unit)
+	if tokens := tokenize(unit); len(tokens) > 0 {
+		if found, n := readUnit(tokens, 0); n == len(tokens) {
+			p.Unit = found
+		} else {
+			name = unit + " " + name
+		}
+	}
+
+	ingredient, preparation, restNotes, optional := splitRest(normalize(name))
+	p.Ingredient = ingredient
+	p.Optional = optional
+
+	// Plugins keep notes like "(optional)" or ", finely chopped" apart
+	prepared := []string{}
+	if preparation != "" {
+		prepared = append(prepared, preparation)
+	}
+	note := strings.Trim(normalize(notes), " ,;:()")
+	if rest, ok := cutOptional(note); ok {
+		p.Optional = true
+		note = rest
+	}
+	switch {
+	case note == "":
+	case isPreparation(note):
+		prepared = append(prepared, note)
+	default:
+		restNotes = append(restNotes, note)
+	}
+
+	p.Preparation = strings.Join(prepared, ", ")
+	p.Notes = strings.Join(restNotes, "; ")
+	p.Name = Name(p.Ingredient)
+	return p
+}
+
+// splitRest separates the text after the unit into the ingredient, its
+// preparation and any notes
+func splitRest(rest string) (string, string, []string, bool) {
+	notes := []string{}
+	preparation := []string{}
+	optional := false
+
+	// Parenthesized notes, and "(optional)"
+	for {
+		match := parenRegex.FindStringSubmatchIndex(rest)
+		if match == nil {
+			break
+		}
+		inner := strings.TrimSpace(rest[match[2]:match[3]])
+		if note, ok := cutOptional(inner); ok {
+			optional = true
+			inner = note
+		}
+		if inner != "" {
+			notes = append(notes, inner)
+		}
+		rest = rest[:match[0]] + " " + rest[match[1]:]
+	}
+	// A parenthesis cut off by the extractor
+	if open := strings.Index(rest, "("); open >= 0 {
+		if note := strings.TrimSpace(strings.Trim(rest[open+1:], ")")); note != "" {
+			notes = append(notes, note)
+		}
+		rest = rest[:open]
+	}
+	rest = strings.ReplaceAll(rest, ")", " ")
+
+	// Anything after a comma is preparation or a note, except in
+	// "skinless, boneless chicken thighs"
+	parts := strings.Split(rest, ",")
+	head := parts[0]
+	for len(parts) > 1 && onlyDescriptors(head) {
+		head += parts[1]
+		parts = parts[1:]
+	}
+	for _, part := range parts[1:] {
+		part = strings.Trim(part, " .;:")
+		switch {
+		case part == "":
+		case strings.EqualFold(part, "optional"):
+			optional = true
+		case isPreparation(part):
+			preparation = append(preparation, part)
+		default:
+			notes = append(notes, part)
+		}
+	}
+
+	head = strings.Join(strings.Fields(head), " ")
+	if note, ok := cutOptional(head); ok {
+		optional = true
+		head = note
+	}
+
+	// "salt to taste", "oil for frying"
+	if match := trailRegex.FindStringSubmatchIndex(head); match != nil {
+		notes = append([]string{head[match[2]:match[3]]}, notes...)
+		head = head[:match[0]]
+	}
+
+	// "finely chopped fresh parsley"
+	words := strings.Fields(head)
+	leading := 0
+	for leading < len(words) {
+		word := strings.ToLower(words[leading])
+		if preparationWords[word] {
+			leading++
+			continue
+		}
+		if strings.HasSuffix(word, "ly") && leading+1 < len(words) && preparationWords[strings.ToLower(words[leading+1])] {
+			leading += 2
+			continue
+		}
+		if word == "and" && leading > 0 {
+			leading++
+			continue
+		}
+		break
+	}
+	if leading > 0 {
+		preparation = append([]string{strings.Join(words[:leading], " ")}, preparation...)
+		words = words[leading:]
+	}
+
+	ingredient := strings.Trim(strings.Join(words, " "), " .;:-\"")
+	return ingredient, strings.Join(preparation, ", "), notes, optional
+}
+
+// cutOptional reports whether text marks the ingredient optional, as in
+// "optional", "optional, for garnish" or "optional: sprinkles", and
+// returns the rest of it
+func cutOptional(text string) (string, bool) {
+	lower := strings.ToLower(text)
+	if !strings.HasPrefix(lower, "optional") {
+		return text, false
+	}
+	rest := text[len("optional"):]
+	if rest != "" && !strings.ContainsAny(rest[:1], " ,;:") {
+		return text, false
+	}
+	return strings.TrimSpace(strings.TrimLeft(rest, " ,;:")), true
+}
+
+// isPreparation reports whether a comma-separated clause says how to
+// prepare the ingredient, like "thinly sliced" or "seeds removed", rather
+// than something else about it, like "to taste" or "plus more for serving"
+func isPreparation(clause string) bool {
+	words := strings.Fields(strings.ToLower(clause))
+	if len(words) == 0 || noteWords[words[0]] {
+		return false
+	}
+	for _, word := range words {
+		word = strings.Trim(word, ".;:")
+		if notPreparation[word] {
+			return false
+		}
+		if preparationWords[word] || (strings.HasSuffix(word, "ed") && len(word) > 4) {
+			return true
+		}
+	}
+	return false
+}
+
+// onlyDescriptors reports whether every word of text is a size or
+// preparation word, like "skinless"
+func onlyDescriptors(text string) bool {
+	words := strings.Fields(strings.ToLower(text))
+	for _, word := range words {
+		if !descriptors[word] {
+			return false
+		}
+	}
+	return len(words) > 0
+}
+
+// normalize decodes entities and spells out fraction characters, so
+// "1½&nbsp;cups" reads "1 1/2 cups"
+func normalize(line string) string {
+	line = normalizer.Replace(html.UnescapeString(line))
+	return strings.Join(strings.Fields(line), " ")
+}
+
+// tokenize splits a normalized line into numbers, words and punctuation.
+// Digits and letters are split apart, so "200g" is a number and a word.
+func tokenize(line string) []token {
+	tokens := []token{}
+	for i := 0; i < len(line); {
+		r, size := utf8.DecodeRuneInString(line[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+			continue
+		case unicode.IsDigit(r) || (r == '.' && numberRegex.MatchString(line[i:])):
+			text := numberRegex.FindString(line[i:])
+			if text == "" {
+				text = string(r)
+			}
+			tokens = append(tokens, token{kind: numberToken, text: text, start: i, end: i + len(text), value: parseNumber(text)})
+			i += len(text)
+		case unicode.IsLetter(r):
+			text := wordRegex.FindString(line[i:])
+			tokens = append(tokens, token{kind: wordToken, text: text, start: i, end: i + len(text)})
+			i += len(text)
+		default:
+			tokens = append(tokens, token{kind: punctToken, text: string(r), start: i, end: i + size})
+			i += size
+		}
+	}
+	return tokens
+}
+
+// readAmount reads one amount at tokens[i]: "2", "1.5", "1/2", "1 1/2" or
+// "one". It returns the value and the number of tokens used.
+func readAmount(tokens []token, i int) (float64, int) {
+	if i >= len(tokens) {
+		return 0, 0
+	}
+	t := tokens[i]
+	if t.kind == wordToken {
+		if value, ok := numberWords[strings.ToLower(t.text)]; ok {
+			return value, 1
+		}
+		return 0, 0
+	}
+	if t.kind != numberToken {
+		return 0, 0
+	}
+
+	// A whole number followed by a fraction: "1 1/2"
+	if !strings.ContainsAny(t.text, "./") && i+1 < len(tokens) {
+		next := tokens[i+1]
+		if next.kind == numberToken && strings.Contains(next.text, "/") && next.value < 1 {
+			return t.value + next.value, 2
+		}
+	}
+	return t.value, 1
+}
+
+// readQuantity reads an amount or a range of amounts at tokens[i]: "2",
+// "1-2", "2 to 3" or "1 or 2". It returns the low and high values and the
+// number of tokens used.
+func readQuantity(tokens []token, i int) (float64, float64, int) {
+	min, n := readAmount(tokens, i)
+	if n == 0 {
+		return 0, 0, 0
+	}
+
+	j := i + n
+	if j+1 < len(tokens) && tokens[j+1].kind == numberToken {
+		sep := strings.ToLower(tokens[j].text)
+		if sep == "-" || sep == "to" || sep == "or" {
+			if max, m := readAmount(tokens, j+1); m > 0 && max > min {
+				return round(min), round(max), n + 1 + m
+			}
+		}
+	}
+	return round(min), round(min), n
+}
+
+// readUnit reads a unit at tokens[i], with its abbreviation period, and
+// returns the number of tokens used
+func readUnit(tokens []token, i int) (Unit, int) {
+	if i >= len(tokens) || tokens[i].kind != wordToken {
+		return NoUnit, 0
+	}
+
+	unit, ok := lookupUnit(tokens[i].text)
+	n := 1
+
+	// "fl. oz." and "fluid ounces"
+	if word := strings.ToLower(tokens[i].text); word == "fl" || word == "fluid" {
+		next := i + 1
+		if next < len(tokens) && tokens[next].text == "." {
+			next++
+		}
+		if next < len(tokens) {
+			if u, found := lookupUnit(tokens[next].text); found && u == Ounce {
+				unit, ok, n = FluidOunce, true, next-i+1
+			}
+		}
+	}
+	if !ok {
+		return NoUnit, 0
+	}
+
+	// "tbsp." but not the period ending a sentence
+	if end := i + n; end < len(tokens) && tokens[end].text == "." && tokens[end].start == tokens[end-1].end {
+		n++
+	}
+	return unit, n
+}
+
+// closingParen finds the token closing the parenthesis at tokens[open]
+func closingParen(tokens []token, open int) int {
+	depth := 0
+	for i := open; i < len(tokens); i++ {
+		switch tokens[i].text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// parseNumber reads "2", "1.5" or "1/2"
+func parseNumber(text string) float64 {
+	if numerator, denominator, ok := strings.Cut(text, "/"); ok {
+		n, err1 := strconv.ParseFloat(numerator, 64)
+		d, err2 := strconv.ParseFloat(denominator, 64)
+		if err1 != nil || err2 != nil || d == 0 {
+			return 0
+		}
+		return n / d
+	}
+	value, _ := strconv.ParseFloat(text, 64)
+	return value
+}
+
+// round keeps three decimals, so 1/3 is 0.333
+func round(value float64) float64 {
+	return math.Round(value*1000) / 1000
+}
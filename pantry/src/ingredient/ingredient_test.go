@@ -0,0 +1,87 @@
+package ingredient_test
+
+import (
+	"testing"
+
+	"search-engine-indexer/src/fixtures"
+	"search-engine-indexer/src/ingredient"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		line string
+		want ingredient.Parsed
+	}{
+		{
+			line: "½ cup sugar",
+			want: ingredient.Parsed{Quantity: "1/2", Min: 0.5, Max: 0.5, Unit: ingredient.Cup,
+				Ingredient: "sugar", Name: "sugar"},
+		},
+		{
+			line: "1-2 tbsp olive oil",
+			want: ingredient.Parsed{Quantity: "1-2", Min: 1, Max: 2, Unit: ingredient.Tablespoon,
+				Ingredient: "olive oil", Name: "olive oil"},
+		},
+		{
+			line: "1 (14 oz) can diced tomatoes, drained",
+			want: ingredient.Parsed{Quantity: "1", Min: 1, Max: 1, Unit: ingredient.Can,
+				Ingredient: "tomatoes", Name: "tomato", Preparation: "diced, drained", Notes: "14 oz"},
+		},
+		{
+			line: "2 large eggs",
+			want: ingredient.Parsed{Quantity: "2", Min: 2, Max: 2,
+				Ingredient: "large eggs", Name: "egg"},
+		},
+		{
+			line: "salt and pepper, to taste",
+			want: ingredient.Parsed{Ingredient: "salt and pepper", Name: "salt and pepper", Notes: "to taste"},
+		},
+		{
+			line: "2 Tbsp. butter, melted",
+			want: ingredient.Parsed{Quantity: "2", Min: 2, Max: 2, Unit: ingredient.Tablespoon,
+				Ingredient: "butter", Name: "butter", Preparation: "melted"},
+		},
+		{
+			line: "1 c. milk",
+			want: ingredient.Parsed{Quantity: "1", Min: 1, Max: 1, Unit: ingredient.Cup,
+				Ingredient: "milk", Name: "milk"},
+		},
+		{
+			line: "1 1/2 cups all-purpose flour, sifted",
+			want: ingredient.Parsed{Quantity: "1 1/2", Min: 1.5, Max: 1.5, Unit: ingredient.Cup,
+				Ingredient: "all-purpose flour", Name: "all purpose flour", Preparation: "sifted"},
+		},
+		{
+			line: "1/4 cup chopped fresh parsley (optional)",
+			want: ingredient.Parsed{Quantity: "1/4", Min: 0.25, Max: 0.25, Unit: ingredient.Cup,
+				Ingredient: "fresh parsley", Name: "parsley", Preparation: "chopped", Optional: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got := ingredient.Parse(tt.line)
+			if got != tt.want {
+				t.Errorf("Parse(%q)\n got  %+v\n want %+v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestParseGoldenLines checks the parser against the ingredient lines in
+// the fixtures directory, as verify-fixtures does
+func TestParseGoldenLines(t *testing.T) {
+	cases, err := fixtures.LoadIngredientCases("../../fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) == 0 {
+		t.Fatalf("no ingredient lines in ../../fixtures/%s", fixtures.IngredientsFile)
+	}
+
+	for _, c := range cases {
+		for _, diff := range c.Verify() {
+			t.Errorf("%q: %s = %q, want %q", c.Line, diff.Field, diff.Actual, diff.Expected)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package ingredient
+
+// Normalized ingredient names for exact matching and aggregations
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	nameParenRegex = regexp.MustCompile(`\([^)]*\)`)
+	nonLetterRegex = regexp.MustCompile(`[^\p{L}]+`)
+	juiceOfRegex   = regexp.MustCompile(`^\s*(juice|zest|juice and zest) of (.+)$`)
+)
+
+// descriptors describe an ingredient's size, freshness or preparation
+// rather than what it is, and are left out of its name
+var descriptors = map[string]bool{
+	"chopped": true, "diced": true, "minced": true, "sliced": true, "grated": true,
+	"shredded": true, "crushed": true, "melted": true, "softened": true,
+	"cubed": true, "peeled": true, "divided": true, "packed": true, "sifted": true,
+	"trimmed": true, "halved": true, "quartered": true, "beaten": true,
+	"finely": true, "roughly": true, "coarsely": true, "thinly": true, "freshly": true,
+	"fresh": true, "large": true, "medium": true, "small": true, "boneless": true,
+	"skinless": true, "optional": true,
+}
+
+// leadingWords are dropped from the start of a name, as in "of ginger"
+// or "a waffle iron"
+var leadingWords = map[string]bool{"of": true, "a": true, "an": true, "the": true}
+
+// irregularPlurals aren't made singular by dropping an "s"
+var irregularPlurals = map[string]string{
+	"leaves": "leaf", "halves": "half", "loaves": "loaf", "knives": "knife",
+	"cookies": "cookie", "brownies": "brownie", "pies": "pie", "smoothies": "smoothie",
+}
+
+// Name normalizes an ingredient to a keyword: lowercased, without notes,
+// preparation or size words, and with the last word singular, so
+// "2 large boneless skinless chicken thighs, trimmed" is "chicken thigh"
+func Name(ingredient string) string {
+	name := strings.ToLower(normalize(ingredient))
+	name = nameParenRegex.ReplaceAllString(name, " ")
+
+	// Anything after a comma is preparation
+	name, _, _ = strings.Cut(name, ",")
+
+	// "juice of 2 limes" is lime juice
+	if match := juiceOfRegex.FindStringSubmatch(name); match != nil {
+		fruit := strings.Fields(nonLetterRegex.ReplaceAllString(match[2], " "))
+		if len(fruit) > 0 {
+			name = Singular(fruit[len(fruit)-1]) + " " + match[1]
+		}
+	}
+
+	// Descriptors are dropped before splitting on hyphens, so "oil-packed"
+	// keeps "packed"
+	fields := []string{}
+	for _, field := range strings.Fields(name) {
+		if !descriptors[strings.Trim(field, ".;:")] {
+			fields = append(fields, field)
+		}
+	}
+
+	// Of "butter or margarine" only the first choice is kept, borrowing
+	// the words it shares with the second: "peanut or vegetable oil" is
+	// "peanut oil"
+	for i, field := range fields {
+		if field != "or" && field != "for" {
+			continue
+		}
+		first, second := fields[:i], fields[i+1:]
+		for j, word := range second {
+			if strings.ContainsAny(word, "0123456789") {
+				second = second[:j]
+				break
+			}
+		}
+		switch {
+		case len(first) == 0:
+			first = second
+		case field == "or" && len(second) > len(first):
+			first = append(first, second[len(first):]...)
+		}
+		fields = first
+		break
+	}
+
+	words := []string{}
+	for _, field := range fields {
+		for _, word := range strings.Fields(nonLetterRegex.ReplaceAllString(field, " ")) {
+			if len(words) == 0 && leadingWords[word] {
+				continue
+			}
+			words = append(words, word)
+		}
+	}
+	if len(words) == 0 {
+		return ""
+	}
+
+	last := words[len(words)-1]
+	if singular, ok := irregularPlurals[last]; ok {
+		words[len(words)-1] = singular
+	} else {
+		words[len(words)-1] = Singular(last)
+	}
+	return strings.Join(words, " ")
+}
+
+// Singular strips a plural ending from a word: "thighs", "berries" and
+// "tomatoes" become "thigh", "berry" and "tomato"
+func Singular(word string) string {
+	switch {
+	case len(word) <= 3 || strings.HasSuffix(word, "ss") || strings.HasSuffix(word, "us"):
+		return word
+	case strings.HasSuffix(word, "ies"):
+		return word[:len(word)-3] + "y"
+	case strings.HasSuffix(word, "oes"):
+		return word[:len(word)-2]
+	case strings.HasSuffix(word, "s"):
+		return word[:len(word)-1]
+	}
+	return word
+}
@@ -0,0 +1,147 @@
+package ingredient
+
+// Canonical units and the spellings that map to them
+import "strings"
+
+// Unit is a canonical unit of measure. The zero value means the line has
+// no unit, as in "2 eggs".
+type Unit string
+
+const (
+	NoUnit Unit = ""
+
+	// Volume
+	Teaspoon   Unit = "tsp"
+	Tablespoon Unit = "tbsp"
+	Cup        Unit = "cup"
+	FluidOunce Unit = "fl oz"
+	Pint       Unit = "pint"
+	Quart      Unit = "quart"
+	Gallon     Unit = "gallon"
+	Milliliter Unit = "ml"
+	Centiliter Unit = "cl"
+	Deciliter  Unit = "dl"
+	Liter      Unit = "l"
+
+	// Weight
+	Ounce     Unit = "oz"
+	Pound     Unit = "lb"
+	Milligram Unit = "mg"
+	Gram      Unit = "g"
+	Kilogram  Unit = "kg"
+
+	// Length
+	Inch       Unit = "inch"
+	Centimeter Unit = "cm"
+
+	// Small amounts
+	Pinch   Unit = "pinch"
+	Dash    Unit = "dash"
+	Drop    Unit = "drop"
+	Handful Unit = "handful"
+
+	// Containers
+	Can       Unit = "can"
+	Jar       Unit = "jar"
+	Bottle    Unit = "bottle"
+	Package   Unit = "package"
+	Envelope  Unit = "envelope"
+	Bag       Unit = "bag"
+	Box       Unit = "box"
+	Container Unit = "container"
+	Carton    Unit = "carton"
+	Tube      Unit = "tube"
+	Block     Unit = "block"
+
+	// Pieces
+	Stick Unit = "stick"
+	Clove Unit = "clove"
+	Slice Unit = "slice"
+	Piece Unit = "piece"
+	Sheet Unit = "sheet"
+	Bunch Unit = "bunch"
+	Sprig Unit = "sprig"
+	Stalk Unit = "stalk"
+	Head  Unit = "head"
+)
+
+// unitWords maps lowercased unit spellings, without a trailing period, to
+// their canonical unit. "T" and "t" are handled by lookupUnit since case
+// tells them apart.
+var unitWords = map[string]Unit{
+	"tsp": Teaspoon, "tsps": Teaspoon, "teaspoon": Teaspoon, "teaspoons": Teaspoon,
+	"tbsp": Tablespoon, "tbsps": Tablespoon, "tbs": Tablespoon, "tbl": Tablespoon,
+	"tblsp": Tablespoon, "tablespoon": Tablespoon, "tablespoons": Tablespoon,
+	"c": Cup, "cup": Cup, "cups": Cup,
+	"pt": Pint, "pts": Pint, "pint": Pint, "pints": Pint,
+	"qt": Quart, "qts": Quart, "quart": Quart, "quarts": Quart,
+	"gal": Gallon, "gallon": Gallon, "gallons": Gallon,
+	"ml": Milliliter, "mls": Milliliter, "milliliter": Milliliter, "milliliters": Milliliter,
+	"millilitre": Milliliter, "millilitres": Milliliter,
+	"cl": Centiliter, "centiliter": Centiliter, "centiliters": Centiliter,
+	"dl": Deciliter, "deciliter": Deciliter, "deciliters": Deciliter,
+	"l": Liter, "liter": Liter, "liters": Liter, "litre": Liter, "litres": Liter,
+	"oz": Ounce, "ozs": Ounce, "ounce": Ounce, "ounces": Ounce,
+	"lb": Pound, "lbs": Pound, "pound": Pound, "pounds": Pound,
+	"mg": Milligram, "milligram": Milligram, "milligrams": Milligram,
+	"g": Gram, "gr": Gram, "gram": Gram, "grams": Gram, "gramme": Gram, "grammes": Gram,
+	"kg": Kilogram, "kgs": Kilogram, "kilo": Kilogram, "kilos": Kilogram,
+	"kilogram": Kilogram, "kilograms": Kilogram,
+	"inch": Inch, "inches": Inch,
+	"cm": Centimeter, "centimeter": Centimeter, "centimeters": Centimeter,
+	"pinch": Pinch, "pinches": Pinch,
+	"dash": Dash, "dashes": Dash,
+	"drop": Drop, "drops": Drop,
+	"handful": Handful, "handfuls": Handful,
+	"can": Can, "cans": Can, "tin": Can, "tins": Can,
+	"jar": Jar, "jars": Jar,
+	"bottle": Bottle, "bottles": Bottle,
+	"package": Package, "packages": Package, "pkg": Package, "pkgs": Package,
+	"packet": Package, "packets": Package,
+	"envelope": Envelope, "envelopes": Envelope,
+	"bag": Bag, "bags": Bag,
+	"box": Box, "boxes": Box,
+	"container": Container, "containers": Container,
+	"carton": Carton, "cartons": Carton,
+	"tube": Tube, "tubes": Tube,
+	"block": Block, "blocks": Block,
+	"stick": Stick, "sticks": Stick,
+	"clove": Clove, "cloves": Clove,
+	"slice": Slice, "slices": Slice,
+	"piece": Piece, "pieces": Piece, "pc": Piece, "pcs": Piece,
+	"sheet": Sheet, "sheets": Sheet,
+	"bunch": Bunch, "bunches": Bunch,
+	"sprig": Sprig, "sprigs": Sprig,
+	"stalk": Stalk, "stalks": Stalk,
+	"head": Head, "heads": Head,
+}
+
+// containerUnits can follow a size, as in "1 14-oz. can"
+var containerUnits = map[Unit]bool{
+	Can: true, Jar: true, Bottle: true, Package: true, Envelope: true, Bag: true,
+	Box: true, Container: true, Carton: true, Tube: true, Block: true, Stick: true, Piece: true,
+	Slice: true, Sheet: true,
+}
+
+// smallUnits stand in for an amount on their own, as in "Pinch salt"
+var smallUnits = map[Unit]bool{Pinch: true, Dash: true, Drop: true, Handful: true}
+
+// sizeWords describe how full a measure is or how big a container is, as
+// in "2 heaping tbsp." or "1 large can"
+var sizeWords = map[string]bool{
+	"heaping": true, "heaped": true, "level": true, "scant": true, "rounded": true,
+	"generous": true, "small": true, "medium": true, "large": true, "big": true,
+}
+
+// lookupUnit returns the canonical unit for one word. A capital "T" is a
+// tablespoon and a lowercase "t" a teaspoon.
+func lookupUnit(word string) (Unit, bool) {
+	switch word {
+	case "T", "Tb", "Tbs", "TB":
+		return Tablespoon, true
+	case "t":
+		return Teaspoon, true
+	}
+	unit, ok := unitWords[strings.ToLower(word)]
+	return unit, ok
+}
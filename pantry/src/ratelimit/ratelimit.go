@@ -0,0 +1,261 @@
+package ratelimit
+
+// Per-host token bucket rate limiting with adaptive backoff
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// A host is never slowed down to less than one request per MaxInterval
+	MaxInterval = 10 * time.Minute
+
+	// Retry-After values longer than this are capped
+	MaxRetryAfter = time.Hour
+
+	// Successful responses needed before a backed-off host speeds up again
+	recoverAfter = 5
+)
+
+// Policy returns the base interval between requests and the maximum
+// number of concurrent requests for a host
+type Policy func(host string) (time.Duration, int)
+
+// HostRate describes the current state of a host's limiter
+type HostRate struct {
+	Host string
+
+	// Interval is the current time between requests and Base the interval
+	// the host recovers to
+	Interval time.Duration
+	Base     time.Duration
+
+	// BlockedUntil is set while honouring a Retry-After
+	BlockedUntil time.Time
+
+	InFlight    int
+	Concurrency int
+}
+
+// PerSecond returns the current request rate
+func (r HostRate) PerSecond() float64 {
+	if r.Interval <= 0 {
+		return 0
+	}
+	return float64(time.Second) / float64(r.Interval)
+}
+
+// BackingOff reports whether the host is currently slower than its base rate
+func (r HostRate) BackingOff() bool {
+	return r.Interval > r.Base || time.Now().Before(r.BlockedUntil)
+}
+
+// host is the token bucket and concurrency slots for a single host
+type host struct {
+	base     time.Duration
+	interval time.Duration
+
+	// tokens may go negative: each caller reserves a token and sleeps
+	// until its turn, so waiting callers queue instead of stampeding
+	tokens float64
+	last   time.Time
+
+	blockedUntil time.Time
+	successes    int
+
+	slots chan struct{}
+}
+
+// Limiter spaces out requests to each host and backs off when a host
+// answers 429 or 503
+type Limiter struct {
+	policy Policy
+
+	mu    sync.Mutex
+	hosts map[string]*host
+}
+
+// New creates a limiter that uses policy to set up each host it sees
+func New(policy Policy) *Limiter {
+	return &Limiter{
+		policy: policy,
+		hosts:  make(map[string]*host),
+	}
+}
+
+// get returns the state for a host, creating it from the policy.
+// The caller must hold l.mu.
+func (l *Limiter) get(name string) *host {
+	name = strings.ToLower(name)
+	if h, ok := l.hosts[name]; ok {
+		return h
+	}
+
+	interval, concurrency := l.policy(name)
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	h := &host{
+		base:     interval,
+		interval: interval,
+		tokens:   1,
+		last:     time.Now(),
+		slots:    make(chan struct{}, concurrency),
+	}
+	l.hosts[name] = h
+	return h
+}
+
+// SetMinInterval makes sure requests to a host are at least d apart, e.g.
+// for a robots.txt Crawl-delay. It never speeds a host up.
+func (l *Limiter) SetMinInterval(name string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	h := l.get(name)
+	if d > h.base {
+		h.base = d
+	}
+	if d > h.interval {
+		h.interval = d
+	}
+}
+
+// Acquire waits for the host's next token and then for a free
+// concurrency slot. The returned function releases the slot. The wait
+// for a token happens before a slot is taken so sleeping callers don't
+// block requests that are already due.
+func (l *Limiter) Acquire(name string) func() {
+	l.mu.Lock()
+	h := l.get(name)
+	now := time.Now()
+
+	// Refill at one token per interval, holding at most one
+	if h.interval > 0 {
+		h.tokens += float64(now.Sub(h.last)) / float64(h.interval)
+		if h.tokens > 1 {
+			h.tokens = 1
+		}
+	} else {
+		h.tokens = 1
+	}
+	h.last = now
+
+	h.tokens--
+	var wait time.Duration
+	if h.tokens < 0 {
+		wait = time.Duration(-h.tokens * float64(h.interval))
+	}
+	// Queue behind a Retry-After rather than all waking when it ends
+	if blocked := h.blockedUntil.Sub(now); blocked > 0 {
+		wait += blocked
+	}
+	slots := h.slots
+	l.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+
+	slots <- struct{}{}
+	return func() { <-slots }
+}
+
+// Observe adjusts a host's rate from a response status. 429 and 503 halve
+// the rate and honour retryAfter; a run of successful responses moves the
+// rate back towards the base.
+func (l *Limiter) Observe(name string, status int, retryAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	h := l.get(name)
+	now := time.Now()
+
+	switch {
+	case status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable:
+		h.successes = 0
+		if h.interval <= 0 {
+			h.interval = time.Second
+		} else {
+			h.interval *= 2
+		}
+		if h.interval > MaxInterval {
+			h.interval = MaxInterval
+		}
+		if h.tokens > 0 {
+			h.tokens = 0
+		}
+
+		if retryAfter > MaxRetryAfter {
+			retryAfter = MaxRetryAfter
+		}
+		if until := now.Add(retryAfter); retryAfter > 0 && until.After(h.blockedUntil) {
+			h.blockedUntil = until
+		}
+
+	case status >= 200 && status < 500:
+		if h.interval <= h.base {
+			return
+		}
+		h.successes++
+		if h.successes < recoverAfter {
+			return
+		}
+		h.successes = 0
+		h.interval = h.interval * 3 / 4
+		if h.interval < h.base {
+			h.interval = h.base
+		}
+	}
+}
+
+// Rates returns the state of every host seen so far, sorted by host
+func (l *Limiter) Rates() []HostRate {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rates := make([]HostRate, 0, len(l.hosts))
+	for name, h := range l.hosts {
+		rates = append(rates, HostRate{
+			Host:         name,
+			Interval:     h.interval,
+			Base:         h.base,
+			BlockedUntil: h.blockedUntil,
+			InFlight:     len(h.slots),
+			Concurrency:  cap(h.slots),
+		})
+	}
+
+	sort.Slice(rates, func(i, j int) bool {
+		return rates[i].Host < rates[j].Host
+	})
+	return rates
+}
+
+// ParseRetryAfter reads a Retry-After header given either as a number of
+// seconds or as an HTTP date. It returns zero if the header is missing or
+// malformed.
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+
+	return 0
+}
@@ -0,0 +1,415 @@
+package schemaorg
+
+// Typed decoding of schema.org Recipe markup from JSON-LD
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// tagRegex matches HTML tags left in text values
+var tagRegex = regexp.MustCompile(`<[^>]*>`)
+
+// Recipe is a schema.org Recipe with each property reduced to plain
+// values, whichever of its documented shapes the page used
+type Recipe struct {
+	Name         string
+	Description  string
+	Images       []string
+	PrepTime     string
+	CookTime     string
+	TotalTime    string
+	Yield        []string
+	Ingredients  []string
+	Instructions []Step
+	Categories   []string
+	Cuisines     []string
+	Keywords     []string
+	Authors      []string
+	Diets        []string
+	Nutrition    Nutrition
+	Rating       *Rating
+	Video        *Video
+}
+
+// Step is one instruction. Section is the name of the HowToSection it
+// came from, if any.
+type Step struct {
+	Section string
+	Text    string
+}
+
+// Nutrition holds a NutritionInformation's values as written, e.g.
+// "320 kcal" or "12 g"
+type Nutrition struct {
+	ServingSize   string
+	Calories      string
+	Fat           string
+	SaturatedFat  string
+	Cholesterol   string
+	Sodium        string
+	Carbohydrates string
+	Fiber         string
+	Sugar         string
+	Protein       string
+}
+
+// Rating is an AggregateRating. Count is the ratingCount, or the
+// reviewCount when there is none.
+type Rating struct {
+	Value float64
+	Count int
+}
+
+// Video is a VideoObject
+type Video struct {
+	Name         string
+	ContentURL   string
+	EmbedURL     string
+	ThumbnailURL string
+	Duration     string
+}
+
+// node is a decoded JSON-LD object
+type node = map[string]interface{}
+
+// Find returns the first Recipe in a decoded JSON-LD document, looking
+// through arrays, @graph and nested objects. References such as
+// {"@id": "#primaryimage"} are resolved against the other objects in the
+// document. It returns nil when there is no recipe.
+func Find(doc interface{}) *Recipe {
+	recipe := findRecipe(doc)
+	if recipe == nil {
+		return nil
+	}
+
+	ids := make(map[string]node)
+	collectIDs(doc, ids)
+	return decode(recipe, ids)
+}
+
+// findRecipe returns the first object typed Recipe, alone or among other
+// types as in ["Recipe", "NewsArticle"]
+func findRecipe(data interface{}) node {
+	switch v := data.(type) {
+	case node:
+		if hasType(v, "recipe") {
+			return v
+		}
+		for _, value := range v {
+			if recipe := findRecipe(value); recipe != nil {
+				return recipe
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if recipe := findRecipe(item); recipe != nil {
+				return recipe
+			}
+		}
+	}
+	return nil
+}
+
+// hasType reports whether an object's @type is, or includes, the given
+// type, ignoring case
+func hasType(n node, want string) bool {
+	for _, t := range texts(n["@type"]) {
+		if strings.EqualFold(t, want) {
+			return true
+		}
+	}
+	return false
+}
+
+// collectIDs indexes every object with an @id and other properties
+func collectIDs(data interface{}, ids map[string]node) {
+	switch v := data.(type) {
+	case node:
+		if id, ok := v["@id"].(string); ok && len(v) > 1 {
+			if _, seen := ids[id]; !seen {
+				ids[id] = v
+			}
+		}
+		for _, value := range v {
+			collectIDs(value, ids)
+		}
+	case []interface{}:
+		for _, item := range v {
+			collectIDs(item, ids)
+		}
+	}
+}
+
+// resolve replaces a bare {"@id": ...} reference with the object it
+// points to
+func resolve(value interface{}, ids map[string]node) interface{} {
+	if n, ok := value.(node); ok && len(n) == 1 {
+		if id, ok := n["@id"].(string); ok {
+			if target, found := ids[id]; found {
+				return target
+			}
+		}
+	}
+	return value
+}
+
+// decode maps a Recipe object onto the typed Recipe
+func decode(n node, ids map[string]node) *Recipe {
+	r := &Recipe{
+		Name:        text(n["name"]),
+		Description: text(n["description"]),
+		Images:      images(n["image"], ids),
+		PrepTime:    text(n["prepTime"]),
+		CookTime:    text(n["cookTime"]),
+		TotalTime:   text(n["totalTime"]),
+		Yield:       texts(n["recipeYield"]),
+		Ingredients: texts(n["recipeIngredient"]),
+		Categories:  splitList(texts(n["recipeCategory"])),
+		Cuisines:    splitList(texts(n["recipeCuisine"])),
+		Keywords:    splitList(texts(n["keywords"])),
+		Authors:     names(n["author"], ids),
+		Diets:       diets(n["suitableForDiet"]),
+		Rating:      rating(resolve(n["aggregateRating"], ids)),
+		Video:       video(n["video"], ids),
+	}
+
+	// Older markup uses "ingredients"
+	if len(r.Ingredients) == 0 {
+		r.Ingredients = texts(n["ingredients"])
+	}
+
+	r.Instructions = steps(n["recipeInstructions"], "", ids)
+
+	if nutrition, ok := resolve(n["nutrition"], ids).(node); ok {
+		r.Nutrition = Nutrition{
+			ServingSize:   text(nutrition["servingSize"]),
+			Calories:      text(nutrition["calories"]),
+			Fat:           text(nutrition["fatContent"]),
+			SaturatedFat:  text(nutrition["saturatedFatContent"]),
+			Cholesterol:   text(nutrition["cholesterolContent"]),
+			Sodium:        text(nutrition["sodiumContent"]),
+			Carbohydrates: text(nutrition["carbohydrateContent"]),
+			Fiber:         text(nutrition["fiberContent"]),
+			Sugar:         text(nutrition["sugarContent"]),
+			Protein:       text(nutrition["proteinContent"]),
+		}
+	}
+
+	return r
+}
+
+// steps flattens recipeInstructions, which may be one string, a list of
+// strings, HowToStep objects, or HowToSection and ItemList objects
+// holding any of those in itemListElement
+func steps(value interface{}, section string, ids map[string]node) []Step {
+	var result []Step
+
+	switch v := resolve(value, ids).(type) {
+	case string:
+		// A single string holds one step per line
+		for _, line := range strings.Split(v, "\n") {
+			if line = clean(line); line != "" {
+				result = append(result, Step{Section: section, Text: line})
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			result = append(result, steps(item, section, ids)...)
+		}
+	case node:
+		if hasType(v, "HowToSection") {
+			if name := text(v["name"]); name != "" {
+				section = name
+			}
+			return steps(v["itemListElement"], section, ids)
+		}
+
+		// A HowToStep's text is in "text", or only in "name" on some
+		// sites. Steps and ItemLists may hold steps of their own.
+		instruction := text(v["text"])
+		if instruction == "" && v["itemListElement"] == nil {
+			instruction = text(v["name"])
+		}
+		if instruction != "" {
+			result = append(result, Step{Section: section, Text: instruction})
+		}
+		result = append(result, steps(v["itemListElement"], section, ids)...)
+	}
+
+	return result
+}
+
+// images returns image URLs from a URL, an ImageObject, a reference to
+// one, or a list of any of these
+func images(value interface{}, ids map[string]node) []string {
+	var urls []string
+
+	switch v := resolve(value, ids).(type) {
+	case string:
+		if url := clean(v); url != "" {
+			urls = append(urls, url)
+		}
+	case []interface{}:
+		for _, item := range v {
+			urls = append(urls, images(item, ids)...)
+		}
+	case node:
+		url := text(v["url"])
+		if url == "" {
+			url = text(v["contentUrl"])
+		}
+		if url != "" {
+			urls = append(urls, url)
+		}
+	}
+
+	return urls
+}
+
+// names returns the names of a Person or Organization, a reference to
+// one, a plain name, or a list of any of these
+func names(value interface{}, ids map[string]node) []string {
+	var result []string
+
+	switch v := resolve(value, ids).(type) {
+	case string:
+		if name := clean(v); name != "" {
+			result = append(result, name)
+		}
+	case []interface{}:
+		for _, item := range v {
+			result = append(result, names(item, ids)...)
+		}
+	case node:
+		if name := text(v["name"]); name != "" {
+			result = append(result, name)
+		}
+	}
+
+	return result
+}
+
+// rating reads an AggregateRating, whose values may be numbers or
+// strings. It returns nil without a rating value.
+func rating(value interface{}) *Rating {
+	n, ok := value.(node)
+	if !ok {
+		return nil
+	}
+
+	ratingValue, err := strconv.ParseFloat(text(n["ratingValue"]), 64)
+	if err != nil || ratingValue <= 0 {
+		return nil
+	}
+
+	count := text(n["ratingCount"])
+	if count == "" {
+		count = text(n["reviewCount"])
+	}
+	ratingCount, _ := strconv.Atoi(strings.ReplaceAll(count, ",", ""))
+
+	return &Rating{Value: ratingValue, Count: ratingCount}
+}
+
+// video reads a VideoObject, or the first of a list of them
+func video(value interface{}, ids map[string]node) *Video {
+	switch v := resolve(value, ids).(type) {
+	case []interface{}:
+		for _, item := range v {
+			if found := video(item, ids); found != nil {
+				return found
+			}
+		}
+	case node:
+		found := &Video{
+			Name:       text(v["name"]),
+			ContentURL: text(v["contentUrl"]),
+			EmbedURL:   text(v["embedUrl"]),
+			Duration:   text(v["duration"]),
+		}
+		if thumbnails := images(v["thumbnailUrl"], ids); len(thumbnails) > 0 {
+			found.ThumbnailURL = thumbnails[0]
+		}
+		if found.ContentURL == "" && found.EmbedURL == "" {
+			return nil
+		}
+		return found
+	}
+	return nil
+}
+
+// diets reads suitableForDiet, whose values are RestrictedDiet names
+// written as URLs: "https://schema.org/GlutenFreeDiet" is "GlutenFreeDiet"
+func diets(value interface{}) []string {
+	var result []string
+	for _, diet := range texts(value) {
+		if i := strings.LastIndex(diet, "/"); i >= 0 {
+			diet = diet[i+1:]
+		}
+		if diet != "" {
+			result = append(result, diet)
+		}
+	}
+	return result
+}
+
+// texts returns the text values in a string, a number, a list or an
+// object with a "name", "text" or "@value"
+func texts(value interface{}) []string {
+	var result []string
+
+	switch v := value.(type) {
+	case string:
+		if s := clean(v); s != "" {
+			result = append(result, s)
+		}
+	case float64:
+		result = append(result, strconv.FormatFloat(v, 'f', -1, 64))
+	case []interface{}:
+		for _, item := range v {
+			result = append(result, texts(item)...)
+		}
+	case node:
+		for _, key := range []string{"@value", "text", "name"} {
+			if s := text(v[key]); s != "" {
+				return []string{s}
+			}
+		}
+	}
+
+	return result
+}
+
+// text returns the first text value
+func text(value interface{}) string {
+	if values := texts(value); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// splitList splits comma separated values, as keywords usually are, and
+// drops repeats
+func splitList(values []string) []string {
+	var result []string
+	seen := make(map[string]bool)
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			part = strings.TrimSpace(part)
+			if part != "" && !seen[strings.ToLower(part)] {
+				seen[strings.ToLower(part)] = true
+				result = append(result, part)
+			}
+		}
+	}
+	return result
+}
+
+// clean strips the HTML tags and entities many sites leave in their
+// JSON-LD, and collapses whitespace
+func clean(s string) string {
+	s = html.UnescapeString(tagRegex.ReplaceAllString(s, " "))
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,209 @@
+package schemaorg
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// The documents below follow the JSON-LD that recipe sites publish: WP
+// Recipe Maker inside a Yoast @graph, the top-level array with a
+// multi-typed Recipe that Dotdash Meredith sites use, and the older flat
+// markup with string instructions. They're trimmed to the properties Find
+// reads and the ones around them it has to skip, with hosts and names
+// replaced.
+var findTests = []struct {
+	name   string
+	jsonld string
+	want   *Recipe
+}{
+	{
+		name: "yoast graph with wprm sections",
+		jsonld: `{"@context":"https://schema.org","@graph":[
+			{"@type":"Article","@id":"https://example.com/lemon-bars/#article","isPartOf":{"@id":"https://example.com/lemon-bars/"},
+			 "author":{"name":"Jane Baker","@id":"https://example.com/#/schema/person/3b1f"},"headline":"Lemon Bars",
+			 "image":{"@id":"https://example.com/lemon-bars/#primaryimage"}},
+			{"@type":"WebPage","@id":"https://example.com/lemon-bars/","url":"https://example.com/lemon-bars/","name":"Lemon Bars",
+			 "primaryImageOfPage":{"@id":"https://example.com/lemon-bars/#primaryimage"}},
+			{"@type":"ImageObject","inLanguage":"en-US","@id":"https://example.com/lemon-bars/#primaryimage",
+			 "url":"https://example.com/wp-content/uploads/2023/03/lemon-bars.jpg",
+			 "contentUrl":"https://example.com/wp-content/uploads/2023/03/lemon-bars.jpg","width":1200,"height":1800},
+			{"@type":"Person","@id":"https://example.com/#/schema/person/3b1f","name":"Jane Baker",
+			 "image":{"@type":"ImageObject","url":"https://secure.gravatar.com/avatar/3b1f"}},
+			{"@type":"Recipe","name":"Lemon Bars","author":{"@id":"https://example.com/#/schema/person/3b1f"},
+			 "description":"Buttery shortbread crust with a tart lemon filling.","datePublished":"2023-03-14T08:00:00+00:00",
+			 "image":["https://example.com/wp-content/uploads/2023/03/lemon-bars.jpg",
+			          "https://example.com/wp-content/uploads/2023/03/lemon-bars-500x500.jpg"],
+			 "recipeYield":["16","16 bars"],"prepTime":"PT20M","cookTime":"PT45M","totalTime":"PT65M",
+			 "recipeIngredient":["1 cup (227g) unsalted butter, melted","1/2 cup (100g) granulated sugar","4 large eggs"],
+			 "recipeInstructions":[
+			  {"@type":"HowToSection","name":"Crust","itemListElement":[
+			   {"@type":"HowToStep","text":"Preheat the oven to 325°F (163°C).","name":"Preheat the oven to 325°F (163°C).",
+			    "url":"https://example.com/lemon-bars/#wprm-recipe-101-step-0-0"},
+			   {"@type":"HowToStep","text":"Press the dough into the pan and bake for 20 minutes.",
+			    "name":"Press the dough into the pan and bake for 20 minutes.",
+			    "url":"https://example.com/lemon-bars/#wprm-recipe-101-step-0-1"}]},
+			  {"@type":"HowToSection","name":"Filling","itemListElement":[
+			   {"@type":"HowToStep","text":"Whisk the sugar, eggs and lemon juice together.",
+			    "name":"Whisk the sugar, eggs and lemon juice together.",
+			    "url":"https://example.com/lemon-bars/#wprm-recipe-101-step-1-0"}]}],
+			 "aggregateRating":{"@type":"AggregateRating","ratingValue":"4.86","ratingCount":"293"},
+			 "recipeCategory":["Dessert"],"recipeCuisine":["American"],"keywords":"lemon bars, lemon dessert",
+			 "nutrition":{"@type":"NutritionInformation","servingSize":"1 bar","calories":"221 kcal",
+			  "carbohydrateContent":"29 g","proteinContent":"3 g","fatContent":"11 g","saturatedFatContent":"7 g",
+			  "cholesterolContent":"77 mg","sodiumContent":"58 mg","fiberContent":"0.3 g","sugarContent":"19 g"},
+			 "@id":"https://example.com/lemon-bars/#recipe","isPartOf":{"@id":"https://example.com/lemon-bars/#article"},
+			 "mainEntityOfPage":"https://example.com/lemon-bars/"}]}`,
+		want: &Recipe{
+			Name:        "Lemon Bars",
+			Description: "Buttery shortbread crust with a tart lemon filling.",
+			Images: []string{
+				"https://example.com/wp-content/uploads/2023/03/lemon-bars.jpg",
+				"https://example.com/wp-content/uploads/2023/03/lemon-bars-500x500.jpg",
+			},
+			PrepTime:    "PT20M",
+			CookTime:    "PT45M",
+			TotalTime:   "PT65M",
+			Yield:       []string{"16", "16 bars"},
+			Ingredients: []string{"1 cup (227g) unsalted butter, melted", "1/2 cup (100g) granulated sugar", "4 large eggs"},
+			Instructions: []Step{
+				{Section: "Crust", Text: "Preheat the oven to 325°F (163°C)."},
+				{Section: "Crust", Text: "Press the dough into the pan and bake for 20 minutes."},
+				{Section: "Filling", Text: "Whisk the sugar, eggs and lemon juice together."},
+			},
+			Categories: []string{"Dessert"},
+			Cuisines:   []string{"American"},
+			Keywords:   []string{"lemon bars", "lemon dessert"},
+			Authors:    []string{"Jane Baker"},
+			Nutrition: Nutrition{
+				ServingSize:   "1 bar",
+				Calories:      "221 kcal",
+				Fat:           "11 g",
+				SaturatedFat:  "7 g",
+				Cholesterol:   "77 mg",
+				Sodium:        "58 mg",
+				Carbohydrates: "29 g",
+				Fiber:         "0.3 g",
+				Sugar:         "19 g",
+				Protein:       "3 g",
+			},
+			Rating: &Rating{Value: 4.86, Count: 293},
+		},
+	},
+	{
+		name: "top-level array with multi-typed recipe",
+		jsonld: `[{"@context":"http://schema.org","@type":["Recipe","NewsArticle"],
+			"headline":"Easy Banana Bread","name":"Easy Banana Bread",
+			"image":{"@type":"ImageObject","url":"https://www.example.com/thmb/banana-bread-1x1.jpg","height":1500,"width":1500},
+			"author":[{"@type":"Person","name":"Sam Ortiz","url":"https://www.example.com/author/sam-ortiz/"},
+			          {"@type":"Person","name":"Lee Park"}],
+			"publisher":{"@type":"Organization","name":"Example Recipes",
+			 "logo":{"@type":"ImageObject","url":"https://www.example.com/logo.png"}},
+			"aggregateRating":{"@type":"AggregateRating","ratingValue":4.7,"ratingCount":14321},
+			"cookTime":"PT60M","prepTime":"PT15M","totalTime":"PT85M",
+			"nutrition":{"@type":"NutritionInformation","calories":"229 kcal","carbohydrateContent":"34 g",
+			 "cholesterolContent":"41 mg","fatContent":"10 g","proteinContent":"3 g","saturatedFatContent":"6 g",
+			 "sodiumContent":"203 mg","sugarContent":"19 g"},
+			"recipeCategory":["Breakfast","Bread"],"recipeCuisine":["American"],
+			"recipeIngredient":["2 cups all-purpose flour","1 teaspoon baking soda","2 ⅓ cups mashed overripe bananas"],
+			"recipeInstructions":[
+			 {"@type":"HowToStep","text":"Gather all ingredients. Preheat the oven to 350 degrees F (175 degrees C)."},
+			 {"@type":"HowToStep","image":[{"@type":"ImageObject","url":"https://www.example.com/thmb/step-2.jpg"}],
+			  "text":"Combine flour, baking soda, and salt in a large bowl."}],
+			"recipeYield":["12"]}]`,
+		want: &Recipe{
+			Name:        "Easy Banana Bread",
+			Images:      []string{"https://www.example.com/thmb/banana-bread-1x1.jpg"},
+			PrepTime:    "PT15M",
+			CookTime:    "PT60M",
+			TotalTime:   "PT85M",
+			Yield:       []string{"12"},
+			Ingredients: []string{"2 cups all-purpose flour", "1 teaspoon baking soda", "2 ⅓ cups mashed overripe bananas"},
+			Instructions: []Step{
+				{Text: "Gather all ingredients. Preheat the oven to 350 degrees F (175 degrees C)."},
+				{Text: "Combine flour, baking soda, and salt in a large bowl."},
+			},
+			Categories: []string{"Breakfast", "Bread"},
+			Cuisines:   []string{"American"},
+			Authors:    []string{"Sam Ortiz", "Lee Park"},
+			Nutrition: Nutrition{
+				Calories:      "229 kcal",
+				Fat:           "10 g",
+				SaturatedFat:  "6 g",
+				Cholesterol:   "41 mg",
+				Sodium:        "203 mg",
+				Carbohydrates: "34 g",
+				Sugar:         "19 g",
+				Protein:       "3 g",
+			},
+			Rating: &Rating{Value: 4.7, Count: 14321},
+		},
+	},
+	{
+		name: "flat markup with string instructions",
+		jsonld: `{"@context":"http://schema.org/","@type":"Recipe","name":"Classic Pot Roast",
+			"author":"Pat Morgan","image":"https://example.com/fullset/2012/pot-roast.jpg",
+			"recipeYield":"6 servings","totalTime":"PT4H",
+			"recipeIngredient":["1 whole (3 to 5 pounds) chuck roast","Salt and pepper, to taste"],
+			"recipeInstructions":"Preheat the oven to 275 degrees F.\n\nGenerously salt and pepper the chuck roast.\r\n Roast for 3 hours.",
+			"aggregateRating":{"@type":"AggregateRating","ratingValue":"4.5","reviewCount":"1,204","bestRating":"5"},
+			"nutrition":{"@type":"NutritionInformation","calories":"650","fatContent":"38g"}}`,
+		want: &Recipe{
+			Name:        "Classic Pot Roast",
+			Images:      []string{"https://example.com/fullset/2012/pot-roast.jpg"},
+			TotalTime:   "PT4H",
+			Yield:       []string{"6 servings"},
+			Ingredients: []string{"1 whole (3 to 5 pounds) chuck roast", "Salt and pepper, to taste"},
+			Instructions: []Step{
+				{Text: "Preheat the oven to 275 degrees F."},
+				{Text: "Generously salt and pepper the chuck roast."},
+				{Text: "Roast for 3 hours."},
+			},
+			Authors:   []string{"Pat Morgan"},
+			Nutrition: Nutrition{Calories: "650", Fat: "38g"},
+			Rating:    &Rating{Value: 4.5, Count: 1204},
+		},
+	},
+	{
+		name: "string step list and referenced image object",
+		jsonld: `{"@context":"https://schema.org","@graph":[
+			{"@type":"ImageObject","@id":"https://example.org/pancakes#image","url":"https://example.org/img/pancakes.jpg"},
+			{"@type":"Recipe","name":"Fluffy Pancakes","image":{"@id":"https://example.org/pancakes#image"},
+			 "author":{"@type":"Organization","name":"Example Test Kitchen"},
+			 "recipeIngredient":["1 ½ cups milk","2 tbsp. melted butter"],
+			 "recipeInstructions":["Whisk the dry ingredients.","  Stir in the milk and butter. ",""],
+			 "aggregateRating":{"@type":"AggregateRating","ratingValue":"0","ratingCount":"0"},
+			 "suitableForDiet":"https://schema.org/VegetarianDiet"}]}`,
+		want: &Recipe{
+			Name:        "Fluffy Pancakes",
+			Images:      []string{"https://example.org/img/pancakes.jpg"},
+			Ingredients: []string{"1 ½ cups milk", "2 tbsp. melted butter"},
+			Instructions: []Step{
+				{Text: "Whisk the dry ingredients."},
+				{Text: "Stir in the milk and butter."},
+			},
+			Authors: []string{"Example Test Kitchen"},
+			Diets:   []string{"VegetarianDiet"},
+		},
+	},
+	{
+		name:   "no recipe",
+		jsonld: `{"@context":"https://schema.org","@type":"WebSite","name":"Example Kitchen"}`,
+	},
+}
+
+func TestFind(t *testing.T) {
+	for _, tt := range findTests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc interface{}
+			if err := json.Unmarshal([]byte(tt.jsonld), &doc); err != nil {
+				t.Fatalf("decode JSON-LD: %v", err)
+			}
+
+			got := Find(doc)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Find()\n got  %+v\n want %+v", got, tt.want)
+			}
+		})
+	}
+}
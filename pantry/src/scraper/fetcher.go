@@ -0,0 +1,277 @@
+package scraper
+
+import (
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"search-engine-indexer/src/ratelimit"
+)
+
+// Responses larger than this are truncated
+const maxResponseSize = 20 * 1024 * 1024
+
+// Response is a fetched page with its status, headers and decoded body
+type Response struct {
+	URL        string      `json:"url"`
+	StatusCode int         `json:"status"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"-"`
+	FetchedAt  time.Time   `json:"fetched_at"`
+}
+
+// Fetcher retrieves a URL. Extra request headers, such as Accept, may be
+// passed in header and can be nil.
+type Fetcher interface {
+	Fetch(u string, header http.Header) (*Response, error)
+}
+
+// DefaultFetcher is used for every page, robots.txt and sitemap request
+var DefaultFetcher Fetcher = NewHTTPFetcher(45 * time.Second)
+
+// SetFetcher replaces the fetcher used by the scraper. Cached robots.txt
+// rules are dropped so they are re-read through the new fetcher.
+func SetFetcher(f Fetcher) {
+	DefaultFetcher = f
+	Robots.Reset()
+}
+
+// HTTPFetcher fetches pages from the live web with a shared client
+type HTTPFetcher struct {
+	client *http.Client
+}
+
+// NewHTTPFetcher returns a live fetcher with the given request timeout
+func NewHTTPFetcher(timeout time.Duration) *HTTPFetcher {
+	return &HTTPFetcher{
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Fetch implements Fetcher
+func (f *HTTPFetcher) Fetch(u string, header http.Header) (*Response, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Identify ourselves honestly and accept normal HTML responses
+	req.Header.Set("User-Agent", UserAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,*/*;q=0.8")
+	req.Header.Set("Accept-Language", "en-US,en;q=0.5")
+	for key, values := range header {
+		req.Header.Del(key)
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	response, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	// Read response body and handle compression
+	var reader io.Reader = response.Body
+	if response.Header.Get("Content-Encoding") == "gzip" {
+		gzipReader, err := gzip.NewReader(response.Body)
+		if err != nil {
+			return nil, fmt.Errorf("create gzip reader: %w", err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	body, err := io.ReadAll(io.LimitReader(reader, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("read response body: %w", err)
+	}
+
+	return &Response{
+		URL:        u,
+		StatusCode: response.StatusCode,
+		Header:     response.Header,
+		Body:       body,
+		FetchedAt:  time.Now(),
+	}, nil
+}
+
+// RecordingFetcher fetches through another Fetcher and stores every
+// response on disk so it can be replayed later with ReplayFetcher
+type RecordingFetcher struct {
+	next Fetcher
+	dir  string
+}
+
+// NewRecordingFetcher records responses from next into dir
+func NewRecordingFetcher(next Fetcher, dir string) (*RecordingFetcher, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create recording directory: %w", err)
+	}
+	return &RecordingFetcher{next: next, dir: dir}, nil
+}
+
+// Fetch implements Fetcher
+func (f *RecordingFetcher) Fetch(u string, header http.Header) (*Response, error) {
+	response, err := f.next.Fetch(u, header)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeRecording(f.dir, response); err != nil {
+		log.Printf("Failed to record response for %s: %v", u, err)
+	}
+
+	return response, nil
+}
+
+// ReplayFetcher serves responses previously stored by RecordingFetcher.
+// URLs that were never recorded get a 404 so crawls stay deterministic.
+type ReplayFetcher struct {
+	dir string
+}
+
+// NewReplayFetcher replays responses recorded in dir
+func NewReplayFetcher(dir string) (*ReplayFetcher, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("open replay directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("replay path %s is not a directory", dir)
+	}
+	return &ReplayFetcher{dir: dir}, nil
+}
+
+// Fetch implements Fetcher
+func (f *ReplayFetcher) Fetch(u string, header http.Header) (*Response, error) {
+	response, err := readRecording(f.dir, u)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("No recorded response for %s", u)
+		return &Response{
+			URL:        u,
+			StatusCode: http.StatusNotFound,
+			Header:     http.Header{},
+			FetchedAt:  time.Now(),
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// recordingKey returns the file name stem used for a URL's recording
+func recordingKey(u string) string {
+	sum := sha1.Sum([]byte(u))
+	return hex.EncodeToString(sum[:])
+}
+
+// writeRecording stores a response as <key>.json (status and headers)
+// and <key>.body (raw body)
+func writeRecording(dir string, response *Response) error {
+	key := recordingKey(response.URL)
+
+	meta, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, key+".body"), response.Body, 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, key+".json"), meta, 0644)
+}
+
+// readRecording loads a response stored by writeRecording
+func readRecording(dir string, u string) (*Response, error) {
+	key := recordingKey(u)
+
+	meta, err := os.ReadFile(filepath.Join(dir, key+".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	response := &Response{}
+	if err := json.Unmarshal(meta, response); err != nil {
+		return nil, fmt.Errorf("decode recording for %s: %w", u, err)
+	}
+
+	response.Body, err = os.ReadFile(filepath.Join(dir, key+".body"))
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// StoreResponse writes a response to dir as RecordingFetcher would, so
+// the directory can be replayed, and returns the path of its body
+func StoreResponse(dir string, response *Response) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("create recording directory: %w", err)
+	}
+	if err := writeRecording(dir, response); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, recordingKey(response.URL)+".body"), nil
+}
+
+// RemoveStoredResponse deletes a response written by StoreResponse, given
+// the path of its body. A response that's already gone isn't an error.
+func RemoveStoredResponse(bodyPath string) error {
+	for _, path := range []string{bodyPath, strings.TrimSuffix(bodyPath, ".body") + ".json"} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// LimitedFetcher waits for the host's rate limiter before every request
+// and reports each response status back to it so the rate adapts
+type LimitedFetcher struct {
+	next    Fetcher
+	limiter *ratelimit.Limiter
+}
+
+// NewLimitedFetcher rate limits requests made through next
+func NewLimitedFetcher(next Fetcher, limiter *ratelimit.Limiter) *LimitedFetcher {
+	return &LimitedFetcher{next: next, limiter: limiter}
+}
+
+// Fetch implements Fetcher
+func (f *LimitedFetcher) Fetch(u string, header http.Header) (*Response, error) {
+	parsedURL, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	host := parsedURL.Host
+
+	release := f.limiter.Acquire(host)
+	response, err := f.next.Fetch(u, header)
+	release()
+	if err != nil {
+		return nil, err
+	}
+
+	retryAfter := ratelimit.ParseRetryAfter(response.Header.Get("Retry-After"), time.Now())
+	if response.StatusCode == http.StatusTooManyRequests || response.StatusCode == http.StatusServiceUnavailable {
+		log.Printf("%s answered %d, backing off (Retry-After %v)", host, response.StatusCode, retryAfter)
+	}
+	f.limiter.Observe(host, response.StatusCode, retryAfter)
+
+	return response, nil
+}
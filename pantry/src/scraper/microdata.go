@@ -0,0 +1,164 @@
+package scraper
+
+// Microdata and RDFa recipe markup, read into the same shape as JSON-LD
+import (
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"search-engine-indexer/src/schemaorg"
+)
+
+// markup names the attributes a syntax uses to start an item, give its
+// type and name its properties
+type markup struct {
+	scope    string
+	itemType string
+	property string
+}
+
+var (
+	// microdata is <div itemscope itemtype="http://schema.org/Recipe">
+	// with itemprop="name" inside
+	microdata = markup{scope: "itemscope", itemType: "itemtype", property: "itemprop"}
+
+	// rdfa is <div vocab="http://schema.org/" typeof="Recipe"> with
+	// property="name" inside
+	rdfa = markup{scope: "typeof", itemType: "typeof", property: "property"}
+)
+
+// urlTags hold a URL rather than text, in the given attribute
+var urlTags = map[string]string{
+	"a": "href", "area": "href", "link": "href",
+	"img": "src", "audio": "src", "video": "src", "source": "src",
+	"embed": "src", "iframe": "src", "track": "src",
+	"object": "data",
+}
+
+// extractMicrodataRecipe reads the first recipe marked up with microdata
+// or RDFa. Items are converted to JSON-LD style objects, so the recipe is
+// decoded exactly as one from JSON-LD would be.
+func (s *Scraper) extractMicrodataRecipe() *schemaorg.Recipe {
+	items := []interface{}{}
+	for _, syntax := range []markup{microdata, rdfa} {
+		s.doc.Find("[" + syntax.scope + "]").Each(func(i int, sel *goquery.Selection) {
+			// Items that are a property of another item are read with it
+			if _, nested := sel.Attr(syntax.property); !nested {
+				items = append(items, s.readItem(sel, syntax))
+			}
+		})
+	}
+
+	if len(items) == 0 {
+		return nil
+	}
+	return schemaorg.Find(items)
+}
+
+// readItem reads an item and its properties
+func (s *Scraper) readItem(sel *goquery.Selection, syntax markup) map[string]interface{} {
+	item := map[string]interface{}{}
+
+	types := []interface{}{}
+	for _, itemType := range strings.Fields(sel.AttrOr(syntax.itemType, "")) {
+		types = append(types, shortName(itemType))
+	}
+	if len(types) > 0 {
+		item["@type"] = types
+	}
+
+	s.readProperties(sel.Children(), item, syntax)
+	return item
+}
+
+// readProperties adds the properties found in elements to item, without
+// descending into other items
+func (s *Scraper) readProperties(elements *goquery.Selection, item map[string]interface{}, syntax markup) {
+	elements.Each(func(i int, sel *goquery.Selection) {
+		_, isItem := sel.Attr(syntax.scope)
+
+		if names := strings.Fields(sel.AttrOr(syntax.property, "")); len(names) > 0 {
+			var value interface{}
+			if isItem {
+				value = s.readItem(sel, syntax)
+			} else {
+				value = s.propertyValue(sel)
+			}
+			for _, name := range names {
+				addProperty(item, shortName(name), value)
+			}
+		}
+
+		if !isItem {
+			s.readProperties(sel.Children(), item, syntax)
+		}
+	})
+}
+
+// propertyValue returns an element's value: its content attribute, the
+// URL of a link or image, a time's datetime, or else its text. Lists and
+// paragraphs are put on separate lines so instructions split into steps.
+func (s *Scraper) propertyValue(sel *goquery.Selection) string {
+	if content, ok := sel.Attr("content"); ok {
+		return content
+	}
+
+	tag := goquery.NodeName(sel)
+	if attr, ok := urlTags[tag]; ok {
+		return s.resolveURL(sel.AttrOr(attr, ""))
+	}
+	switch tag {
+	case "time":
+		if datetime, ok := sel.Attr("datetime"); ok {
+			return datetime
+		}
+	case "data", "meter":
+		if value, ok := sel.Attr("value"); ok {
+			return value
+		}
+	}
+
+	if blocks := sel.Find("li, p"); blocks.Length() > 0 {
+		lines := []string{}
+		blocks.Each(func(i int, block *goquery.Selection) {
+			lines = append(lines, strings.TrimSpace(block.Text()))
+		})
+		return strings.Join(lines, "\n")
+	}
+	return sel.Text()
+}
+
+// resolveURL makes a link or image URL absolute against the page URL
+func (s *Scraper) resolveURL(href string) string {
+	base, err := url.Parse(s.url)
+	if err != nil {
+		return href
+	}
+	ref, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return href
+	}
+	return base.ResolveReference(ref).String()
+}
+
+// addProperty adds a value to an item, turning repeated properties into
+// a list
+func addProperty(item map[string]interface{}, name string, value interface{}) {
+	switch existing := item[name].(type) {
+	case nil:
+		item[name] = value
+	case []interface{}:
+		item[name] = append(existing, value)
+	default:
+		item[name] = []interface{}{existing, value}
+	}
+}
+
+// shortName strips the vocabulary from a type or property, so
+// "http://schema.org/Recipe" and "schema:Recipe" are both "Recipe"
+func shortName(name string) string {
+	if i := strings.LastIndexAny(name, "/:#"); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
@@ -0,0 +1,258 @@
+package scraper
+
+// Recipe cards rendered by WordPress recipe plugins, found by their markup
+// on any site
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"search-engine-indexer/src/sites"
+)
+
+// ingredientParts is an ingredient as a plugin's markup splits it
+type ingredientParts struct {
+	amount string
+	unit   string
+	name   string
+	notes  string
+}
+
+// recipePlugin holds the selectors for a plugin's recipe card. All but
+// card are relative to the card.
+type recipePlugin struct {
+	name string
+	card string
+
+	title       string
+	summary     string
+	image       string
+	prepTime    string
+	cookTime    string
+	totalTime   string
+	servings    string
+	notes       string
+	instruction string
+
+	// ingredients holds the ingredient list, where each ingredient follows
+	// the groupName heading it's listed under, if any
+	ingredients string
+	groupName   string
+	ingredient  string
+
+	// parts splits an ingredient into the parts the markup gives, or is
+	// nil when it doesn't split them
+	parts func(sel *goquery.Selection) ingredientParts
+}
+
+// recipePlugins are tried in order, and the first whose card is on the
+// page is used
+var recipePlugins = []recipePlugin{
+	{
+		// WP Recipe Maker
+		name:        "wprm",
+		card:        ".wprm-recipe-container",
+		title:       ".wprm-recipe-name",
+		summary:     ".wprm-recipe-summary",
+		image:       ".wprm-recipe-image img",
+		prepTime:    ".wprm-recipe-prep-time-container .wprm-recipe-time",
+		cookTime:    ".wprm-recipe-cook-time-container .wprm-recipe-time",
+		totalTime:   ".wprm-recipe-total-time-container .wprm-recipe-time",
+		servings:    ".wprm-recipe-servings-with-unit, .wprm-recipe-servings",
+		notes:       ".wprm-recipe-notes",
+		instruction: ".wprm-recipe-instruction-text",
+		ingredients: ".wprm-recipe-ingredients-container",
+		groupName:   ".wprm-recipe-group-name",
+		ingredient:  ".wprm-recipe-ingredient",
+		parts: func(sel *goquery.Selection) ingredientParts {
+			return ingredientParts{
+				amount: cardText(sel.Find(".wprm-recipe-ingredient-amount")),
+				unit:   cardText(sel.Find(".wprm-recipe-ingredient-unit")),
+				name:   cardText(sel.Find(".wprm-recipe-ingredient-name")),
+				notes:  cardText(sel.Find(".wprm-recipe-ingredient-notes")),
+			}
+		},
+	},
+	{
+		// Tasty Recipes marks the amount, and its unit, with data attributes
+		name:        "tasty-recipes",
+		card:        ".tasty-recipes",
+		title:       ".tasty-recipes-title",
+		summary:     ".tasty-recipes-description",
+		image:       ".tasty-recipes-image img",
+		prepTime:    ".tasty-recipes-prep-time",
+		cookTime:    ".tasty-recipes-cook-time",
+		totalTime:   ".tasty-recipes-total-time",
+		servings:    ".tasty-recipes-yield",
+		notes:       ".tasty-recipes-notes-body",
+		instruction: ".tasty-recipes-instructions li",
+		ingredients: ".tasty-recipes-ingredients",
+		groupName:   "h3, h4",
+		ingredient:  "li",
+		parts: func(sel *goquery.Selection) ingredientParts {
+			amount := sel.Find("[data-amount]").First()
+			if amount.Length() == 0 {
+				return ingredientParts{}
+			}
+			name := sel.Clone()
+			name.Find("[data-amount]").First().Remove()
+			return ingredientParts{
+				amount: amount.AttrOr("data-amount", ""),
+				unit:   amount.AttrOr("data-unit", ""),
+				name:   cardText(name),
+			}
+		},
+	},
+	{
+		// Mediavine Create gives groups and notes, but not the parts of
+		// an ingredient
+		name:        "mediavine-create",
+		card:        ".mv-create-card",
+		title:       ".mv-create-title",
+		summary:     ".mv-create-description",
+		image:       ".mv-create-image img",
+		prepTime:    ".mv-create-time-prep .mv-create-time-format",
+		cookTime:    ".mv-create-time-active .mv-create-time-format",
+		totalTime:   ".mv-create-time-total .mv-create-time-format",
+		servings:    ".mv-create-yield",
+		notes:       ".mv-create-notes-content",
+		instruction: ".mv-create-instructions li",
+		ingredients: ".mv-create-ingredients",
+		groupName:   "h3, h4",
+		ingredient:  "li",
+	},
+}
+
+// ingredientPartKeys are the GetRecipeData fields describing the lines in
+// "ingredients". They're only kept with the ingredients they describe.
+var ingredientPartKeys = []string{
+	"ingredient_plugin", "ingredient_amounts", "ingredient_units",
+	"ingredient_names", "ingredient_notes", "ingredient_groups",
+}
+
+// fillFromPlugin fills in empty fields from the page's recipe plugin card
+func (s *Scraper) fillFromPlugin(data *RecipeData) {
+	values := s.extractPluginRecipe()
+	if values == nil {
+		return
+	}
+
+	if data.Values["ingredients"] != "" {
+		for _, key := range ingredientPartKeys {
+			delete(values, key)
+		}
+	}
+	for key, value := range values {
+		data.fill(key, value, sites.ExtractPlugin)
+	}
+}
+
+// extractPluginRecipe reads the card of the first recipe plugin found on
+// the page, keyed as in GetRecipeData. Each ingredient part is a
+// semicolon-separated list with an entry for every line of "ingredients",
+// empty where the markup had none, and "ingredient_plugin" names the
+// plugin. It returns nil when there is no card.
+func (s *Scraper) extractPluginRecipe() map[string]string {
+	for _, plugin := range recipePlugins {
+		card := s.doc.Find(plugin.card).First()
+		if card.Length() == 0 {
+			continue
+		}
+
+		values := map[string]string{
+			"name":         cardText(card.Find(plugin.title).First()),
+			"description":  cardText(card.Find(plugin.summary).First()),
+			"prep_time":    cardText(card.Find(plugin.prepTime).First()),
+			"cook_time":    cardText(card.Find(plugin.cookTime).First()),
+			"total_time":   cardText(card.Find(plugin.totalTime).First()),
+			"servings":     cardText(card.Find(plugin.servings).First()),
+			"notes":        cardText(card.Find(plugin.notes).First()),
+			"instructions": strings.Join(cardTexts(card.Find(plugin.instruction)), ";"),
+		}
+		if image := card.Find(plugin.image).First(); image.Length() > 0 {
+			values["image"] = s.resolveURL(imageSource(image))
+		}
+
+		var lines, amounts, units, names, notes, groups []string
+		group := ""
+		selector := plugin.groupName + ", " + plugin.ingredient
+		card.Find(plugin.ingredients).First().Find(selector).Each(func(i int, sel *goquery.Selection) {
+			if sel.Is(plugin.groupName) {
+				group = listValue(cardText(sel))
+				return
+			}
+
+			line := listValue(cardText(sel))
+			if line == "" {
+				return
+			}
+			parts := ingredientParts{}
+			if plugin.parts != nil {
+				parts = plugin.parts(sel)
+			}
+			lines = append(lines, line)
+			amounts = append(amounts, listValue(parts.amount))
+			units = append(units, listValue(parts.unit))
+			names = append(names, listValue(parts.name))
+			notes = append(notes, listValue(parts.notes))
+			groups = append(groups, group)
+		})
+
+		if len(lines) > 0 {
+			values["ingredients"] = strings.Join(lines, ";")
+			values["ingredient_plugin"] = plugin.name
+			for key, list := range map[string][]string{
+				"ingredient_amounts": amounts,
+				"ingredient_units":   units,
+				"ingredient_names":   names,
+				"ingredient_notes":   notes,
+				"ingredient_groups":  groups,
+			} {
+				// A part the markup never gave is left out
+				if strings.Join(list, "") != "" {
+					values[key] = strings.Join(list, ";")
+				}
+			}
+		}
+
+		return values
+	}
+	return nil
+}
+
+// cardText returns an element's text without the screen reader labels,
+// checkbox glyphs and field labels plugins add, with whitespace collapsed
+func cardText(sel *goquery.Selection) string {
+	if sel.Length() == 0 {
+		return ""
+	}
+	sel = sel.Clone()
+	sel.Find(".sr-only, .screen-reader-text, .wprm-checkbox-container, .mv-create-strong").Remove()
+	return strings.Join(strings.Fields(sel.Text()), " ")
+}
+
+// cardTexts returns the non-empty texts of each element
+func cardTexts(sel *goquery.Selection) []string {
+	texts := []string{}
+	sel.Each(func(i int, item *goquery.Selection) {
+		if text := listValue(cardText(item)); text != "" {
+			texts = append(texts, text)
+		}
+	})
+	return texts
+}
+
+// listValue makes text safe to join into a semicolon-separated list
+func listValue(text string) string {
+	return strings.TrimSpace(strings.ReplaceAll(text, ";", ","))
+}
+
+// imageSource returns an image's URL, looking past the placeholder src
+// that lazy loading leaves
+func imageSource(image *goquery.Selection) string {
+	for _, attr := range []string{"data-lazy-src", "data-src", "src"} {
+		if src := image.AttrOr(attr, ""); src != "" {
+			return src
+		}
+	}
+	return ""
+}
@@ -0,0 +1,104 @@
+package scraper
+
+// Where each extracted recipe field came from, and how far it's trusted
+import (
+	"math"
+	"strings"
+	"unicode"
+
+	"search-engine-indexer/src/sites"
+	"search-engine-indexer/src/structs"
+)
+
+// Sources of fields filled outside the extraction steps
+const (
+	// SourceMeta is the page's <title>, meta description or og:image
+	SourceMeta = "meta"
+
+	// SourceTitle is a name copied from the page title when no step found
+	// one
+	SourceTitle = "title"
+)
+
+// sourceConfidence is how far a value from each source is trusted, from 0
+// to 1. Recipe cards and structured data are written for machines; the
+// narrative step guesses from the page text.
+var sourceConfidence = map[string]float64{
+	sites.ExtractPlugin:    0.95,
+	sites.ExtractJSONLD:    0.9,
+	sites.ExtractMicrodata: 0.85,
+	sites.ExtractSelectors: 0.7,
+	SourceMeta:             0.6,
+	SourceTitle:            0.4,
+	sites.ExtractNarrative: 0.3,
+}
+
+// RecipeData is the recipe GetRecipeData extracts from a page
+type RecipeData struct {
+	// Values holds the fields as text, keyed by name: "name",
+	// "ingredients", "prep_time" and so on. Lists are semicolon separated.
+	Values map[string]string
+
+	// Extraction records the source and confidence of every non-empty
+	// field, except the ingredient parts, which share the source of
+	// "ingredients"
+	Extraction map[string]structs.FieldExtraction
+}
+
+// newRecipeData returns an empty RecipeData
+func newRecipeData() *RecipeData {
+	return &RecipeData{
+		Values:     make(map[string]string),
+		Extraction: make(map[string]structs.FieldExtraction),
+	}
+}
+
+// fill sets a field that's still empty, recording its source
+func (d *RecipeData) fill(key, value, source string) {
+	if value == "" || d.Values[key] != "" {
+		return
+	}
+	d.set(key, value, source)
+}
+
+// set sets a field whatever it held, recording its source
+func (d *RecipeData) set(key, value, source string) {
+	d.Values[key] = value
+	for _, part := range ingredientPartKeys {
+		if key == part {
+			return
+		}
+	}
+	d.Extraction[key] = structs.FieldExtraction{
+		Source:     source,
+		Confidence: fieldConfidence(key, value, source),
+	}
+}
+
+// fieldConfidence is the source's confidence, halved when the value
+// doesn't look like the field: a time or calorie count without a number,
+// a single ingredient or step, or an image that isn't a URL
+func fieldConfidence(key, value, source string) float64 {
+	confidence := sourceConfidence[source]
+
+	plausible := true
+	switch key {
+	case "prep_time", "cook_time", "total_time", "calories", "servings":
+		plausible = strings.IndexFunc(value, unicode.IsDigit) >= 0
+	case "ingredients", "instructions":
+		lines := 0
+		for _, line := range strings.Split(value, ";") {
+			if strings.TrimSpace(line) != "" {
+				lines++
+			}
+		}
+		plausible = lines > 1
+	case "image":
+		plausible = strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://")
+	}
+	if !plausible {
+		confidence /= 2
+	}
+
+	return math.Round(confidence*100) / 100
+}
@@ -0,0 +1,354 @@
+package scraper
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UserAgent is sent with every request and used to pick the robots.txt
+// group that applies to us. Override it with SetUserAgent.
+var UserAgent = "RecipeSmithBot/1.0 (+https://github.com/N0tT1m/recipe-smith)"
+
+// SetUserAgent changes the User-Agent header and robots.txt token used by
+// the scraper. Cached robots.txt rules are dropped so they are re-evaluated
+// against the new token.
+func SetUserAgent(ua string) {
+	ua = strings.TrimSpace(ua)
+	if ua == "" {
+		return
+	}
+	UserAgent = ua
+	Robots.Reset()
+}
+
+// robotsToken returns the product token of the User-Agent, e.g.
+// "recipesmithbot" for "RecipeSmithBot/1.0 (...)"
+func robotsToken() string {
+	token := UserAgent
+	if i := strings.IndexAny(token, "/ "); i >= 0 {
+		token = token[:i]
+	}
+	return strings.ToLower(token)
+}
+
+const (
+	// How long a fetched robots.txt is trusted
+	robotsTTL = 24 * time.Hour
+
+	// How long a failed robots.txt fetch is remembered before retrying
+	robotsErrorTTL = 1 * time.Hour
+
+	// robots.txt files larger than this are truncated (RFC 9309 says 500 KiB)
+	robotsMaxSize = 500 * 1024
+)
+
+// robotsRule is a single Allow or Disallow line
+type robotsRule struct {
+	allow   bool
+	path    string
+	pattern *regexp.Regexp
+}
+
+// robotsGroup is the set of rules that apply to one or more user agents
+type robotsGroup struct {
+	agents     []string
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+// RobotsRules is the parsed robots.txt for a single host
+type RobotsRules struct {
+	groups   []robotsGroup
+	sitemaps []string
+
+	// disallowAll is set when robots.txt could not be fetched because of a
+	// server error, which RFC 9309 treats as a full disallow
+	disallowAll bool
+
+	expires time.Time
+}
+
+// ParseRobots parses the contents of a robots.txt file
+func ParseRobots(r io.Reader) *RobotsRules {
+	rules := &RobotsRules{}
+
+	var current *robotsGroup
+	inAgents := false
+
+	scanner := bufio.NewScanner(io.LimitReader(r, robotsMaxSize))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// Consecutive user-agent lines share the same group
+			if current == nil || !inAgents {
+				rules.groups = append(rules.groups, robotsGroup{})
+				current = &rules.groups[len(rules.groups)-1]
+			}
+			current.agents = append(current.agents, strings.ToLower(value))
+			inAgents = true
+
+		case "allow", "disallow":
+			inAgents = false
+			if current == nil {
+				continue
+			}
+			// An empty Disallow means "allow everything" and adds no rule
+			if value == "" {
+				continue
+			}
+			current.rules = append(current.rules, robotsRule{
+				allow:   key == "allow",
+				path:    value,
+				pattern: robotsPattern(value),
+			})
+
+		case "crawl-delay":
+			inAgents = false
+			if current == nil {
+				continue
+			}
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+				current.crawlDelay = time.Duration(seconds * float64(time.Second))
+			}
+
+		case "sitemap":
+			// Sitemaps are not tied to a group
+			if value != "" {
+				rules.sitemaps = append(rules.sitemaps, value)
+			}
+
+		default:
+			inAgents = false
+		}
+	}
+
+	return rules
+}
+
+// robotsPattern compiles a robots.txt path with * and $ wildcards
+func robotsPattern(path string) *regexp.Regexp {
+	anchored := strings.HasSuffix(path, "$")
+	path = strings.TrimSuffix(path, "$")
+
+	parts := strings.Split(path, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+
+	expr := "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil
+	}
+	return re
+}
+
+// group returns the group that applies to the given token, falling back
+// to the "*" group
+func (r *RobotsRules) group(token string) *robotsGroup {
+	var wildcard *robotsGroup
+	var best *robotsGroup
+	bestLen := 0
+
+	for i := range r.groups {
+		g := &r.groups[i]
+		for _, agent := range g.agents {
+			if agent == "*" {
+				if wildcard == nil {
+					wildcard = g
+				}
+				continue
+			}
+			if strings.Contains(token, agent) && len(agent) > bestLen {
+				best = g
+				bestLen = len(agent)
+			}
+		}
+	}
+
+	if best != nil {
+		return best
+	}
+	return wildcard
+}
+
+// Allowed reports whether the given path (with query) may be fetched.
+// The longest matching rule wins and Allow wins a tie.
+func (r *RobotsRules) Allowed(path string) bool {
+	if r.disallowAll {
+		return false
+	}
+	if path == "/robots.txt" {
+		return true
+	}
+
+	g := r.group(robotsToken())
+	if g == nil {
+		return true
+	}
+
+	allowed := true
+	matched := -1
+	for _, rule := range g.rules {
+		if rule.pattern == nil || !rule.pattern.MatchString(path) {
+			continue
+		}
+		if len(rule.path) > matched || (len(rule.path) == matched && rule.allow) {
+			allowed = rule.allow
+			matched = len(rule.path)
+		}
+	}
+
+	return allowed
+}
+
+// CrawlDelay returns the Crawl-delay that applies to us, or zero
+func (r *RobotsRules) CrawlDelay() time.Duration {
+	if g := r.group(robotsToken()); g != nil {
+		return g.crawlDelay
+	}
+	return 0
+}
+
+// Sitemaps returns the Sitemap URLs listed in robots.txt
+func (r *RobotsRules) Sitemaps() []string {
+	return r.sitemaps
+}
+
+// RobotsCache fetches and caches robots.txt per scheme and host
+type RobotsCache struct {
+	mu    sync.Mutex
+	hosts map[string]*RobotsRules
+
+	// fetching holds a channel per host that is closed once an in-progress
+	// fetch completes, so concurrent workers don't fetch the same file
+	fetching map[string]chan struct{}
+}
+
+// Robots is the shared robots.txt cache used by the crawler
+var Robots = NewRobotsCache()
+
+// NewRobotsCache creates an empty robots.txt cache
+func NewRobotsCache() *RobotsCache {
+	return &RobotsCache{
+		hosts:    make(map[string]*RobotsRules),
+		fetching: make(map[string]chan struct{}),
+	}
+}
+
+// Reset drops every cached robots.txt
+func (c *RobotsCache) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hosts = make(map[string]*RobotsRules)
+}
+
+// Allowed reports whether robots.txt permits fetching the URL
+func (c *RobotsCache) Allowed(u string) bool {
+	parsedURL, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+
+	path := parsedURL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if parsedURL.RawQuery != "" {
+		path += "?" + parsedURL.RawQuery
+	}
+
+	return c.Rules(parsedURL).Allowed(path)
+}
+
+// CrawlDelay returns the Crawl-delay robots.txt asks for on the URL's host
+func (c *RobotsCache) CrawlDelay(u string) time.Duration {
+	parsedURL, err := url.Parse(u)
+	if err != nil {
+		return 0
+	}
+	return c.Rules(parsedURL).CrawlDelay()
+}
+
+// Rules returns the robots.txt rules for the URL's host, fetching them if
+// they are not cached or have expired
+func (c *RobotsCache) Rules(u *url.URL) *RobotsRules {
+	key := strings.ToLower(u.Scheme + "://" + u.Host)
+
+	for {
+		c.mu.Lock()
+		if rules, ok := c.hosts[key]; ok && time.Now().Before(rules.expires) {
+			c.mu.Unlock()
+			return rules
+		}
+		if wait, ok := c.fetching[key]; ok {
+			c.mu.Unlock()
+			<-wait
+			continue
+		}
+		done := make(chan struct{})
+		c.fetching[key] = done
+		c.mu.Unlock()
+
+		rules := c.fetch(key)
+
+		c.mu.Lock()
+		c.hosts[key] = rules
+		delete(c.fetching, key)
+		c.mu.Unlock()
+		close(done)
+
+		return rules
+	}
+}
+
+// fetch downloads and parses robots.txt for a scheme and host
+func (c *RobotsCache) fetch(origin string) *RobotsRules {
+	robotsURL := origin + "/robots.txt"
+
+	response, err := DefaultFetcher.Fetch(robotsURL, http.Header{"Accept": {"text/plain,*/*;q=0.8"}})
+	if err != nil {
+		// Unreachable robots.txt is treated as a full disallow until retried
+		log.Printf("Failed to fetch %s: %v", robotsURL, err)
+		return &RobotsRules{disallowAll: true, expires: time.Now().Add(robotsErrorTTL)}
+	}
+	switch {
+	case response.StatusCode >= 500:
+		log.Printf("Server error (%d) fetching %s, disallowing host", response.StatusCode, robotsURL)
+		return &RobotsRules{disallowAll: true, expires: time.Now().Add(robotsErrorTTL)}
+	case response.StatusCode >= 400:
+		// No robots.txt means no restrictions
+		return &RobotsRules{expires: time.Now().Add(robotsTTL)}
+	case response.StatusCode != 200:
+		return &RobotsRules{expires: time.Now().Add(robotsErrorTTL)}
+	}
+
+	rules := ParseRobots(bytes.NewReader(response.Body))
+	rules.expires = time.Now().Add(robotsTTL)
+	return rules
+}
@@ -2,18 +2,22 @@ package scraper
 
 import (
 	"bytes"
-	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
+	"search-engine-indexer/src/canonical"
+	"search-engine-indexer/src/schemaorg"
+	"search-engine-indexer/src/sites"
 )
 
 // RecipeSite defines the structure for recipe site configurations
@@ -41,185 +45,68 @@ type Scraper struct {
 	site *RecipeSite
 }
 
-var recipeSites = []RecipeSite{
-	{
-		Domain: "pinchofyum.com",
-		URLPatterns: []string{
-			"/recipe/",
-			"/recipes/",
-		},
-		Selectors: SiteSelectors{
-			RecipeTitle:       "h1.entry-title, h1.recipe-title",
-			RecipeDescription: ".recipe-description, .entry-content p:first-of-type",
-			RecipeIngredients: ".recipe-ingredients li, .wp-block-recipe-card-ingredients li",
-			RecipeInstructions: ".recipe-instructions li, .wp-block-recipe-card-instructions li",
-			RecipeTime:        ".recipe-time, .prep-time, .cook-time",
-			RecipeServings:    ".recipe-servings, .servings",
-			RecipeLinks:       []string{"a[href*='/recipe/']", "a[href*='/recipes/']"},
-		},
-	},
-	{
-		Domain: "minimalistbaker.com",
-		URLPatterns: []string{
-			"/recipe/",
-			"/recipes/",
-		},
-		Selectors: SiteSelectors{
-			RecipeTitle:       "h1.entry-title, h1.recipe-title",
-			RecipeDescription: ".recipe-description, .entry-summary",
-			RecipeIngredients: ".recipe-ingredients li, ul.ingredients li",
-			RecipeInstructions: ".recipe-instructions li, ol.instructions li",
-			RecipeTime:        ".recipe-time, .prep-time, .total-time",
-			RecipeServings:    ".recipe-servings, .yield",
-			RecipeLinks:       []string{"a[href*='/recipe/']", "a[href*='/recipes/']"},
-		},
-	},
-	{
-		Domain: "cookieandkate.com",
-		URLPatterns: []string{
-			"/recipe/",
-			"/recipes/",
-		},
-		Selectors: SiteSelectors{
-			RecipeTitle:       "h1.entry-title, h1.recipe-title",
-			RecipeDescription: ".recipe-description, .entry-summary",
-			RecipeIngredients: ".recipe-ingredients li, .ingredients li",
-			RecipeInstructions: ".recipe-instructions li, .instructions li",
-			RecipeTime:        ".recipe-time, .prep-time, .cook-time",
-			RecipeServings:    ".recipe-servings, .servings",
-			RecipeLinks:       []string{"a[href*='/recipe/']", "a[href*='/recipes/']"},
-		},
-	},
-	{
-		Domain: "loveandlemons.com",
-		URLPatterns: []string{
-			"/recipe/",
-			"/recipes/",
-		},
-		Selectors: SiteSelectors{
-			RecipeTitle:       "h1.entry-title, h1.recipe-title",
-			RecipeDescription: ".recipe-description, .entry-summary",
-			RecipeIngredients: ".recipe-ingredients li, .ingredients li",
-			RecipeInstructions: ".recipe-instructions li, .instructions li",
-			RecipeTime:        ".recipe-time, .prep-time, .cook-time",
-			RecipeServings:    ".recipe-servings, .servings",
-			RecipeLinks:       []string{"a[href*='/recipe/']", "a[href*='/recipes/']"},
-		},
-	},
-	{
-		Domain: "smittenkitchen.com",
-		URLPatterns: []string{
-			"/recipe/",
-			"/recipes/",
-			"/blog/",
-			"/20", // Year-based URLs like /2025/06/recipe-name/
-		},
-		Selectors: SiteSelectors{
-			RecipeTitle:       "h1.entry-title, h1.recipe-title, h1, .post-title",
-			RecipeDescription: ".recipe-description, .entry-summary, .entry-content p:first-of-type",
-			RecipeIngredients: ".recipe-ingredients li, .ingredients li, .entry-content p:contains('cup'), .entry-content p:contains('tablespoon'), .entry-content p:contains('teaspoon')",
-			RecipeInstructions: ".recipe-instructions li, .instructions li, .entry-content p:contains('mix'), .entry-content p:contains('combine'), .entry-content p:contains('heat')",
-			RecipeTime:        ".recipe-time, .prep-time, .cook-time, .entry-content p:contains('minute'), .entry-content p:contains('hour')",
-			RecipeServings:    ".recipe-servings, .servings, .entry-content p:contains('serve'), .entry-content p:contains('yield')",
-			RecipeLinks:       []string{"a[href*='/recipe/']", "a[href*='/recipes/']", "a[href*='/blog/']", "a[href*='/20']"},
-		},
-	},
-	{
-		Domain: "seriouseats.com",
-		URLPatterns: []string{
-			"/recipe/",
-			"/recipes/",
-		},
-		Selectors: SiteSelectors{
-			RecipeTitle:       "h1.heading__title, h1.recipe-title",
-			RecipeDescription: ".recipe-about, .recipe-description",
-			RecipeIngredients: ".recipe-ingredients li, .structured-ingredients__list-item",
-			RecipeInstructions: ".recipe-procedures li, .recipe-instructions li",
-			RecipeTime:        ".recipe-time, .total-time, .active-time",
-			RecipeServings:    ".recipe-yield, .servings",
-			RecipeLinks:       []string{"a[href*='/recipe/']", "a[href*='/recipes/']"},
-		},
-	},
-	{
-		Domain: "halfbakedharvest.com",
-		URLPatterns: []string{
-			"/recipe/",
-			"/recipes/",
-		},
-		Selectors: SiteSelectors{
-			RecipeTitle:       "h1.entry-title, h1.recipe-title",
-			RecipeDescription: ".recipe-description, .entry-summary",
-			RecipeIngredients: ".recipe-ingredients li, .ingredients li",
-			RecipeInstructions: ".recipe-instructions li, .instructions li",
-			RecipeTime:        ".recipe-time, .prep-time, .cook-time",
-			RecipeServings:    ".recipe-servings, .servings",
-			RecipeLinks:       []string{"a[href*='/recipe/']", "a[href*='/recipes/']"},
-		},
-	},
-	{
-		Domain: "101cookbooks.com",
-		URLPatterns: []string{
-			"/recipe/",
-			"/recipes/",
-		},
-		Selectors: SiteSelectors{
-			RecipeTitle:       "h1.entry-title, h1.recipe-title",
-			RecipeDescription: ".recipe-description, .entry-summary",
-			RecipeIngredients: ".recipe-ingredients li, .ingredients li",
-			RecipeInstructions: ".recipe-instructions li, .instructions li",
-			RecipeTime:        ".recipe-time, .prep-time, .cook-time",
-			RecipeServings:    ".recipe-servings, .servings",
-			RecipeLinks:       []string{"a[href*='/recipe/']", "a[href*='/recipes/']"},
-		},
-	},
-	{
-		Domain: "food52.com",
-		URLPatterns: []string{
-			"/recipe/",
-			"/recipes/",
-		},
-		Selectors: SiteSelectors{
-			RecipeTitle:       "h1, .recipe-title, .recipe-header-title, [data-testid='recipe-title']",
-			RecipeDescription: ".recipe-summary, .recipe-description, .recipe-intro, .recipe-about, .intro",
-			RecipeIngredients: ".recipe-ingredients li, .ingredients li, .ingredient-list li, [data-testid='ingredient'], .recipe-ingredient",
-			RecipeInstructions: ".recipe-instructions li, .instructions li, .direction-list li, [data-testid='instruction'], .recipe-instruction, .recipe-method li",
-			RecipeTime:        ".recipe-time, .prep-time, .cook-time, .total-time, [data-testid='recipe-time']",
-			RecipeServings:    ".recipe-servings, .servings, .yield, [data-testid='servings']",
-			RecipeLinks:       []string{"a[href*='/recipe/']", "a[href*='/recipes/']"},
-		},
-	},
-	{
-		Domain: "budgetbytes.com",
-		URLPatterns: []string{
-			"/recipe/",
-			"/recipes/",
-		},
-		Selectors: SiteSelectors{
-			RecipeTitle:       "h1.entry-title, h1.recipe-title",
-			RecipeDescription: ".recipe-description, .entry-summary",
-			RecipeIngredients: ".recipe-ingredients li, .ingredients li",
-			RecipeInstructions: ".recipe-instructions li, .instructions li",
-			RecipeTime:        ".recipe-time, .prep-time, .cook-time",
-			RecipeServings:    ".recipe-servings, .servings",
-			RecipeLinks:       []string{"a[href*='/recipe/']", "a[href*='/recipes/']"},
-		},
-	},
-	{
-		Domain: "thewoksoflife.com",
-		URLPatterns: []string{
-			"/recipe/",
-			"/recipes/",
-		},
-		Selectors: SiteSelectors{
-			RecipeTitle:       "h1.entry-title, h1.recipe-title",
-			RecipeDescription: ".recipe-description, .entry-summary",
-			RecipeIngredients: ".recipe-ingredients li, .ingredients li",
-			RecipeInstructions: ".recipe-instructions li, .instructions li",
-			RecipeTime:        ".recipe-time, .prep-time, .cook-time",
-			RecipeServings:    ".recipe-servings, .servings",
-			RecipeLinks:       []string{"a[href*='/recipe/']", "a[href*='/recipes/']"},
-		},
-	},
+// recipeSites holds the sites with extraction selectors, loaded from the
+// site definition file with SetSites
+var recipeSites []RecipeSite
+
+// extractionOrders holds each site's extraction order by domain, and
+// defaultExtractionOrder the order for every other host
+var (
+	extractionOrders       = map[string][]string{}
+	defaultExtractionOrder = sites.DefaultExtractionOrder
+)
+
+// SetSites replaces the recipe site configurations. Sites without
+// selectors are skipped since there is nothing site-specific to scrape,
+// but their extraction order is kept.
+func SetSites(defs []sites.Site) {
+	configured := make([]RecipeSite, 0, len(defs))
+	orders := make(map[string][]string, len(defs))
+	for _, def := range defs {
+		if len(def.ExtractionOrder) > 0 {
+			orders[def.Domain] = def.ExtractionOrder
+		}
+		if def.Selectors.IsZero() {
+			continue
+		}
+		configured = append(configured, RecipeSite{
+			Domain:      def.Domain,
+			URLPatterns: def.URLPatterns,
+			Selectors: SiteSelectors{
+				RecipeTitle:        def.Selectors.Title,
+				RecipeDescription:  def.Selectors.Description,
+				RecipeIngredients:  def.Selectors.Ingredients,
+				RecipeInstructions: def.Selectors.Instructions,
+				RecipeTime:         def.Selectors.Time,
+				RecipeServings:     def.Selectors.Servings,
+				RecipeLinks:        def.Selectors.Links,
+			},
+		})
+	}
+	recipeSites = configured
+	extractionOrders = orders
+}
+
+// SetDefaultExtractionOrder sets the extraction order for hosts without
+// a site definition
+func SetDefaultExtractionOrder(order []string) {
+	if len(order) == 0 {
+		order = sites.DefaultExtractionOrder
+	}
+	defaultExtractionOrder = order
+}
+
+// extractionOrder returns the extraction steps to try for a URL
+func extractionOrder(u string) []string {
+	if parsedURL, err := url.Parse(u); err == nil {
+		host := strings.ToLower(parsedURL.Hostname())
+		for domain, order := range extractionOrders {
+			if sites.MatchesDomain(host, domain) {
+				return order
+			}
+		}
+	}
+	return defaultExtractionOrder
 }
 
 // getSiteConfig returns the recipe site configuration for a given URL
@@ -240,6 +127,24 @@ func getSiteConfig(u string) *RecipeSite {
 	return nil
 }
 
+// SiteDomains returns the domain of every configured recipe site
+func SiteDomains() []string {
+	domains := make([]string, 0, len(recipeSites))
+	for _, site := range recipeSites {
+		domains = append(domains, site.Domain)
+	}
+	return domains
+}
+
+// SiteDomain returns the configured recipe site domain for a URL, or an
+// empty string if no site config matches
+func SiteDomain(u string) string {
+	if site := getSiteConfig(u); site != nil {
+		return site.Domain
+	}
+	return ""
+}
+
 // isRecipeURL checks if the URL matches recipe patterns for the site
 func isRecipeURL(u string, site *RecipeSite) bool {
 	if site == nil {
@@ -256,35 +161,32 @@ func isRecipeURL(u string, site *RecipeSite) bool {
 
 // NewScraper builds a new scraper for the website with retries and better error handling
 func NewScraper(u string) *Scraper {
-	if !strings.HasPrefix(u, "http") {
+	response := FetchPage(u, nil)
+	if response == nil {
 		return nil
 	}
 
-	// Add rate limiting and retries
-	client := &http.Client{
-		Timeout: 45 * time.Second,
+	s, err := NewScraperFromBody(u, response.Body)
+	if err != nil {
+		log.Printf("Failed to parse HTML for %s: %v", u, err)
+		return nil
 	}
 
-	// Try up to 3 times with exponential backoff
-	for attempt := 1; attempt <= 3; attempt++ {
-		req, err := http.NewRequest("GET", u, nil)
-		if err != nil {
-			log.Printf("Failed to create request for %s (attempt %d): %v", u, attempt, err)
-			if attempt == 3 {
-				return nil
-			}
-			time.Sleep(time.Duration(attempt) * time.Second)
-			continue
-		}
+	return s
+}
 
-		// Set more realistic headers
-		req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
-		req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,*/*;q=0.8")
-		req.Header.Set("Accept-Language", "en-US,en;q=0.5")
-		req.Header.Set("Connection", "keep-alive")
-		req.Header.Set("Upgrade-Insecure-Requests", "1")
+// FetchPage fetches a page with retries and returns nil if it could not
+// be fetched. Conditional requests may pass If-None-Match or
+// If-Modified-Since in header, in which case a 304 response is returned
+// as-is with an empty body.
+func FetchPage(u string, header http.Header) *Response {
+	if !strings.HasPrefix(u, "http") {
+		return nil
+	}
 
-		response, err := client.Do(req)
+	// Try up to 3 times with exponential backoff
+	for attempt := 1; attempt <= 3; attempt++ {
+		response, err := DefaultFetcher.Fetch(u, header)
 		if err != nil {
 			log.Printf("Failed to fetch %s (attempt %d): %v", u, attempt, err)
 			if attempt == 3 {
@@ -293,16 +195,19 @@ func NewScraper(u string) *Scraper {
 			time.Sleep(time.Duration(attempt) * 2 * time.Second)
 			continue
 		}
-		defer response.Body.Close()
 
 		// Handle various status codes more gracefully
-		if response.StatusCode == 404 {
+		if response.StatusCode == 304 {
+			return response
+		} else if response.StatusCode == 404 {
 			log.Printf("Page not found (404) for %s", u)
 			return nil
 		} else if response.StatusCode == 403 {
 			log.Printf("Access forbidden (403) for %s", u)
 			return nil
-		} else if response.StatusCode >= 500 {
+		} else if response.StatusCode >= 500 || response.StatusCode == 429 {
+			// A rate-limited fetcher also waits out any Retry-After before
+			// the next attempt
 			log.Printf("Server error (%d) for %s (attempt %d)", response.StatusCode, u, attempt)
 			if attempt == 3 {
 				return nil
@@ -314,60 +219,31 @@ func NewScraper(u string) *Scraper {
 			return nil
 		}
 
-		// Successfully fetched page
-
-		// Read response body and handle compression
-		var reader io.Reader = response.Body
-		
-		// Check if response is gzipped and decompress if needed
-		if response.Header.Get("Content-Encoding") == "gzip" {
-			gzipReader, err := gzip.NewReader(response.Body)
-			if err != nil {
-				fmt.Printf("Failed to create gzip reader for %s (attempt %d): %v\n", u, attempt, err)
-				if attempt == 3 {
-					return nil
-				}
-				time.Sleep(time.Duration(attempt) * time.Second)
-				continue
-			}
-			defer gzipReader.Close()
-			reader = gzipReader
-		}
-		
-		bodyBytes, err := io.ReadAll(reader)
-		if err != nil {
-			fmt.Printf("Failed to read response body for %s (attempt %d): %v\n", u, attempt, err)
-			if attempt == 3 {
-				return nil
-			}
-			time.Sleep(time.Duration(attempt) * time.Second)
-			continue
-		}
-
-		// Response body read successfully
-
-		d, err := goquery.NewDocumentFromReader(bytes.NewReader(bodyBytes))
-		if err != nil {
-			log.Printf("Failed to parse HTML for %s (attempt %d): %v", u, attempt, err)
-			if attempt == 3 {
-				return nil
-			}
-			time.Sleep(time.Duration(attempt) * time.Second)
-			continue
-		}
+		return response
+	}
 
-		site := getSiteConfig(u)
+	return nil
+}
 
-		// HTML parsed successfully
+// ContentHash returns a hex SHA-256 of a page body, used to tell whether
+// a page changed between crawls
+func ContentHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
 
-		return &Scraper{
-			url:  u,
-			doc:  d,
-			site: site,
-		}
+// NewScraperFromBody builds a scraper from an already fetched HTML body
+func NewScraperFromBody(u string, body []byte) (*Scraper, error) {
+	d, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	return &Scraper{
+		url:  u,
+		doc:  d,
+		site: getSiteConfig(u),
+	}, nil
 }
 
 // Body returns a string with the body of the page
@@ -457,63 +333,47 @@ func (s *Scraper) extractJSONLD() string {
 			return
 		}
 
-		if recipe := s.findRecipeInJSON(data); recipe != nil {
-			if title, ok := recipe["name"].(string); ok && title != "" {
-				recipeData.WriteString("TITLE: " + title + "\n\n")
+		if recipe := schemaorg.Find(data); recipe != nil {
+			if recipe.Name != "" {
+				recipeData.WriteString("TITLE: " + recipe.Name + "\n\n")
 			}
 
-			if description, ok := recipe["description"].(string); ok && description != "" {
-				recipeData.WriteString("DESCRIPTION: " + description + "\n\n")
+			if recipe.Description != "" {
+				recipeData.WriteString("DESCRIPTION: " + recipe.Description + "\n\n")
 			}
 
 			// Extract ingredients
-			if ingredients, ok := recipe["recipeIngredient"].([]interface{}); ok {
+			if len(recipe.Ingredients) > 0 {
 				recipeData.WriteString("INGREDIENTS:\n")
-				for _, ing := range ingredients {
-					if ingredient, ok := ing.(string); ok {
-						recipeData.WriteString("- " + ingredient + "\n")
-					}
+				for _, ingredient := range recipe.Ingredients {
+					recipeData.WriteString("- " + ingredient + "\n")
 				}
 				recipeData.WriteString("\n")
 			}
 
 			// Extract instructions
-			if instructions, ok := recipe["recipeInstructions"].([]interface{}); ok {
+			if len(recipe.Instructions) > 0 {
 				recipeData.WriteString("INSTRUCTIONS:\n")
-				for idx, inst := range instructions {
-					var instruction string
-					if instObj, ok := inst.(map[string]interface{}); ok {
-						if text, ok := instObj["text"].(string); ok {
-							instruction = text
-						}
-					} else if instStr, ok := inst.(string); ok {
-						instruction = instStr
-					}
-					if instruction != "" {
-						recipeData.WriteString(fmt.Sprintf("%d. %s\n", idx+1, instruction))
-					}
+				for idx, step := range recipe.Instructions {
+					recipeData.WriteString(fmt.Sprintf("%d. %s\n", idx+1, step.Text))
 				}
 				recipeData.WriteString("\n")
 			}
 
 			// Extract timing
-			if prepTime, ok := recipe["prepTime"].(string); ok && prepTime != "" {
-				recipeData.WriteString("PREP TIME: " + prepTime + "\n")
+			if recipe.PrepTime != "" {
+				recipeData.WriteString("PREP TIME: " + recipe.PrepTime + "\n")
 			}
-			if cookTime, ok := recipe["cookTime"].(string); ok && cookTime != "" {
-				recipeData.WriteString("COOK TIME: " + cookTime + "\n")
+			if recipe.CookTime != "" {
+				recipeData.WriteString("COOK TIME: " + recipe.CookTime + "\n")
 			}
-			if totalTime, ok := recipe["totalTime"].(string); ok && totalTime != "" {
-				recipeData.WriteString("TOTAL TIME: " + totalTime + "\n")
+			if recipe.TotalTime != "" {
+				recipeData.WriteString("TOTAL TIME: " + recipe.TotalTime + "\n")
 			}
 
 			// Extract servings/yield
-			if yield, ok := recipe["recipeYield"].(string); ok && yield != "" {
-				recipeData.WriteString("SERVINGS: " + yield + "\n")
-			} else if yieldArr, ok := recipe["recipeYield"].([]interface{}); ok && len(yieldArr) > 0 {
-				if yieldStr, ok := yieldArr[0].(string); ok {
-					recipeData.WriteString("SERVINGS: " + yieldStr + "\n")
-				}
+			if len(recipe.Yield) > 0 {
+				recipeData.WriteString("SERVINGS: " + recipe.Yield[0] + "\n")
 			}
 		}
 	})
@@ -521,43 +381,19 @@ func (s *Scraper) extractJSONLD() string {
 	return recipeData.String()
 }
 
-// findRecipeInJSON recursively searches for Recipe schema in JSON-LD data
-func (s *Scraper) findRecipeInJSON(data interface{}) map[string]interface{} {
-	switch v := data.(type) {
-	case map[string]interface{}:
-		if typeField, ok := v["@type"].(string); ok && strings.ToLower(typeField) == "recipe" {
-			return v
-		}
-		// Search in nested objects
-		for _, value := range v {
-			if recipe := s.findRecipeInJSON(value); recipe != nil {
-				return recipe
-			}
-		}
-	case []interface{}:
-		// Search in array elements
-		for _, item := range v {
-			if recipe := s.findRecipeInJSON(item); recipe != nil {
-				return recipe
-			}
-		}
-	}
-	return nil
-}
-
+// buildLinks resolves a link against the page URL. Links are kept as
+// written so they are fetched from the host the site links to; the
+// frontier dedupes them on their canonical form.
 func (s *Scraper) buildLinks(href string) string {
-	var link string
-
-	if strings.HasPrefix(href, "/") {
-		link = strings.Join([]string{s.url, href}, "")
-	} else {
-		link = href
-	}
-
-	link = strings.TrimRight(link, "/")
-	link = strings.TrimRight(link, ":")
+	return canonical.Absolute(s.url, href)
+}
 
-	return link
+// CanonicalURL returns the page's canonical URL, honouring
+// <link rel="canonical"> and og:url when they point at the same site
+func (s *Scraper) CanonicalURL() string {
+	linkCanonical, _ := s.doc.Find("link[rel='canonical']").First().Attr("href")
+	ogURL, _ := s.doc.Find("meta[property='og:url']").First().Attr("content")
+	return canonical.Declared(s.url, linkCanonical, ogURL)
 }
 
 // Links returns an array with all the links from the website
@@ -716,173 +552,132 @@ func (s *Scraper) cleanText(text string) string {
 	return text
 }
 
-// GetRecipeData extracts comprehensive recipe data from the page
-func (s *Scraper) GetRecipeData() map[string]string {
-	data := make(map[string]string)
+// GetRecipeData extracts comprehensive recipe data from the page. The
+// extraction steps run in the site's extraction order, each filling in
+// the fields the ones before it left empty, and the source of each field
+// is recorded with it.
+func (s *Scraper) GetRecipeData() *RecipeData {
+	data := newRecipeData()
 
 	// Get title and description
 	title, description := s.MetaDataInformation()
-	data["title"] = title
-	data["description"] = description
-
-	// First try JSON-LD structured data
-	jsonRecipe := s.extractJSONLDRecipe()
-	if jsonRecipe != nil {
-		if name, ok := jsonRecipe["name"].(string); ok {
-			data["name"] = name
-		}
-		if desc, ok := jsonRecipe["description"].(string); ok && data["description"] == "" {
-			data["description"] = desc
-		}
-		if image, ok := jsonRecipe["image"].(string); ok {
-			data["image"] = image
-		} else if imageArr, ok := jsonRecipe["image"].([]interface{}); ok && len(imageArr) > 0 {
-			if imageStr, ok := imageArr[0].(string); ok {
-				data["image"] = imageStr
-			}
-		}
-		if prepTime, ok := jsonRecipe["prepTime"].(string); ok {
-			data["prep_time"] = prepTime
-		}
-		if cookTime, ok := jsonRecipe["cookTime"].(string); ok {
-			data["cook_time"] = cookTime
-		}
-		if totalTime, ok := jsonRecipe["totalTime"].(string); ok {
-			data["total_time"] = totalTime
-		}
-		if calories, ok := jsonRecipe["calories"].(string); ok {
-			data["calories"] = calories
-		}
-		if yield, ok := jsonRecipe["recipeYield"].(string); ok {
-			data["servings"] = yield
-		} else if yieldArr, ok := jsonRecipe["recipeYield"].([]interface{}); ok && len(yieldArr) > 0 {
-			if yieldStr, ok := yieldArr[0].(string); ok {
-				data["servings"] = yieldStr
-			}
-		}
+	data.fill("title", title, SourceMeta)
+	data.fill("description", description, SourceMeta)
 
-		// Extract ingredients
-		if ingredients, ok := jsonRecipe["recipeIngredient"].([]interface{}); ok {
-			var ingredientsList []string
-			for _, ing := range ingredients {
-				if ingredient, ok := ing.(string); ok {
-					ingredientsList = append(ingredientsList, strings.TrimSpace(ingredient))
-				}
-			}
-			data["ingredients"] = strings.Join(ingredientsList, ";")
+	for _, step := range extractionOrder(s.url) {
+		switch step {
+		case sites.ExtractPlugin:
+			s.fillFromPlugin(data)
+		case sites.ExtractJSONLD:
+			fillFromRecipe(data, s.extractJSONLDRecipe(), step)
+		case sites.ExtractMicrodata:
+			fillFromRecipe(data, s.extractMicrodataRecipe(), step)
+		case sites.ExtractSelectors:
+			s.fillFromSelectors(data)
+		case sites.ExtractNarrative:
+			s.fillFromNarrative(data)
 		}
+	}
 
-		// Extract instructions
-		if instructions, ok := jsonRecipe["recipeInstructions"].([]interface{}); ok {
-			var instructionsList []string
-			for _, inst := range instructions {
-				var instruction string
-				if instObj, ok := inst.(map[string]interface{}); ok {
-					if text, ok := instObj["text"].(string); ok {
-						instruction = text
-					}
-				} else if instStr, ok := inst.(string); ok {
-					instruction = instStr
-				}
-				if instruction != "" {
-					instructionsList = append(instructionsList, strings.TrimSpace(instruction))
+	// Try to extract image from meta tags if not found
+	if data.Values["image"] == "" {
+		s.doc.Find("meta").Each(func(index int, item *goquery.Selection) {
+			if item.AttrOr("property", "") == "og:image" || item.AttrOr("name", "") == "twitter:image" {
+				if content := item.AttrOr("content", ""); content != "" {
+					data.set("image", content, SourceMeta)
 				}
 			}
-			data["instructions"] = strings.Join(instructionsList, ";")
-		}
+		})
 	}
 
-	// If JSON-LD didn't provide data, try site-specific selectors
-	if s.site != nil && isRecipeURL(s.url, s.site) {
-		selectors := s.site.Selectors
+	// Use title as name if name is still empty
+	data.fill("name", data.Values["title"], SourceTitle)
 
-		if data["name"] == "" {
-			if name := s.doc.Find(selectors.RecipeTitle).First().Text(); name != "" {
-				data["name"] = strings.TrimSpace(name)
-			}
-		}
+	return data
+}
 
-		if data["description"] == "" {
-			if desc := s.doc.Find(selectors.RecipeDescription).First().Text(); desc != "" {
-				data["description"] = strings.TrimSpace(desc)
-			}
-		}
+// fillFromSelectors fills in empty fields with the site's CSS selectors,
+// on recipe pages of sites that have them
+func (s *Scraper) fillFromSelectors(data *RecipeData) {
+	if s.site == nil || !isRecipeURL(s.url, s.site) {
+		return
+	}
 
-		if data["ingredients"] == "" {
-			var ingredients []string
-			s.doc.Find(selectors.RecipeIngredients).Each(func(i int, sel *goquery.Selection) {
-				ingredient := strings.TrimSpace(sel.Text())
-				if ingredient != "" {
-					ingredients = append(ingredients, ingredient)
-				}
-			})
-			if len(ingredients) > 0 {
-				data["ingredients"] = strings.Join(ingredients, ";")
-			}
-		}
+	selectors := s.site.Selectors
+	source := sites.ExtractSelectors
 
-		if data["instructions"] == "" {
-			var instructions []string
-			s.doc.Find(selectors.RecipeInstructions).Each(func(i int, sel *goquery.Selection) {
-				instruction := strings.TrimSpace(sel.Text())
-				if instruction != "" {
-					instructions = append(instructions, instruction)
-				}
-			})
-			if len(instructions) > 0 {
-				data["instructions"] = strings.Join(instructions, ";")
-			}
-		}
+	if data.Values["name"] == "" {
+		name := s.doc.Find(selectors.RecipeTitle).First().Text()
+		data.fill("name", strings.TrimSpace(name), source)
+	}
 
-		if data["prep_time"] == "" || data["cook_time"] == "" || data["total_time"] == "" {
-			if timeText := s.doc.Find(selectors.RecipeTime).First().Text(); timeText != "" {
-				data["total_time"] = strings.TrimSpace(timeText)
-			}
-		}
+	if data.Values["description"] == "" {
+		desc := s.doc.Find(selectors.RecipeDescription).First().Text()
+		data.fill("description", strings.TrimSpace(desc), source)
+	}
 
-		if data["servings"] == "" {
-			if servings := s.doc.Find(selectors.RecipeServings).First().Text(); servings != "" {
-				data["servings"] = strings.TrimSpace(servings)
+	if data.Values["ingredients"] == "" {
+		var ingredients []string
+		s.doc.Find(selectors.RecipeIngredients).Each(func(i int, sel *goquery.Selection) {
+			ingredient := strings.TrimSpace(sel.Text())
+			if ingredient != "" {
+				ingredients = append(ingredients, ingredient)
 			}
-		}
+		})
+		data.fill("ingredients", strings.Join(ingredients, ";"), source)
 	}
 
-	// Try to extract image from meta tags if not found
-	if data["image"] == "" {
-		s.doc.Find("meta").Each(func(index int, item *goquery.Selection) {
-			if item.AttrOr("property", "") == "og:image" || item.AttrOr("name", "") == "twitter:image" {
-				if content := item.AttrOr("content", ""); content != "" {
-					data["image"] = content
-				}
+	if data.Values["instructions"] == "" {
+		var instructions []string
+		s.doc.Find(selectors.RecipeInstructions).Each(func(i int, sel *goquery.Selection) {
+			instruction := strings.TrimSpace(sel.Text())
+			if instruction != "" {
+				instructions = append(instructions, instruction)
 			}
 		})
+		data.fill("instructions", strings.Join(instructions, ";"), source)
 	}
 
-	// Use title as name if name is still empty
-	if data["name"] == "" {
-		data["name"] = data["title"]
+	if data.Values["prep_time"] == "" || data.Values["cook_time"] == "" || data.Values["total_time"] == "" {
+		if timeText := strings.TrimSpace(s.doc.Find(selectors.RecipeTime).First().Text()); timeText != "" {
+			data.set("total_time", timeText, source)
+		}
 	}
 
-	// If we still don't have ingredients or instructions, try narrative extraction
-	// This is particularly useful for blog-style recipe sites like smittenkitchen.com
-	if (data["ingredients"] == "" || data["instructions"] == "") {
-		narrativeData := s.extractNarrativeRecipe()
-		
-		if data["ingredients"] == "" && narrativeData["ingredients"] != "" {
-			data["ingredients"] = narrativeData["ingredients"]
-		}
-		
-		if data["instructions"] == "" && narrativeData["instructions"] != "" {
-			data["instructions"] = narrativeData["instructions"]
-		}
+	if data.Values["servings"] == "" {
+		servings := s.doc.Find(selectors.RecipeServings).First().Text()
+		data.fill("servings", strings.TrimSpace(servings), source)
 	}
+}
 
-	return data
+// fillFromNarrative fills in missing ingredients or instructions from the
+// page text. This is particularly useful for blog-style recipe sites like
+// smittenkitchen.com.
+func (s *Scraper) fillFromNarrative(data *RecipeData) {
+	if data.Values["ingredients"] != "" && data.Values["instructions"] != "" {
+		return
+	}
+
+	narrativeData := s.extractNarrativeRecipe()
+	data.fill("ingredients", narrativeData["ingredients"], sites.ExtractNarrative)
+	data.fill("instructions", narrativeData["instructions"], sites.ExtractNarrative)
+}
+
+// fillFromRecipe copies a schema.org recipe's values into the fields
+// still empty, from the given source
+func fillFromRecipe(data *RecipeData, recipe *schemaorg.Recipe, source string) {
+	if recipe == nil {
+		return
+	}
+
+	for key, value := range recipeValues(recipe) {
+		data.fill(key, value, source)
+	}
 }
 
 // extractJSONLDRecipe extracts the first recipe from JSON-LD data
-func (s *Scraper) extractJSONLDRecipe() map[string]interface{} {
-	var recipe map[string]interface{}
+func (s *Scraper) extractJSONLDRecipe() *schemaorg.Recipe {
+	var recipe *schemaorg.Recipe
 
 	s.doc.Find("script[type='application/ld+json']").Each(func(i int, sel *goquery.Selection) {
 		if recipe != nil {
@@ -908,12 +703,71 @@ func (s *Scraper) extractJSONLDRecipe() map[string]interface{} {
 			}
 		}
 
-		recipe = s.findRecipeInJSON(data)
+		recipe = schemaorg.Find(data)
 	})
 
 	return recipe
 }
 
+// recipeValues maps a schema.org recipe onto GetRecipeData's fields.
+// Categories, cuisines, keywords and diets are semicolon separated like
+// ingredients, and the steps of each HowToSection are kept in order.
+func recipeValues(recipe *schemaorg.Recipe) map[string]string {
+	values := map[string]string{
+		"name":        recipe.Name,
+		"description": recipe.Description,
+		"prep_time":   recipe.PrepTime,
+		"cook_time":   recipe.CookTime,
+		"total_time":  recipe.TotalTime,
+		"calories":    recipe.Nutrition.Calories,
+		"ingredients": strings.Join(recipe.Ingredients, ";"),
+		"categories":  strings.Join(recipe.Categories, ";"),
+		"cuisines":    strings.Join(recipe.Cuisines, ";"),
+		"keywords":    strings.Join(recipe.Keywords, ";"),
+		"diets":       strings.Join(recipe.Diets, ";"),
+		"author":      strings.Join(recipe.Authors, ", "),
+
+		"nutrition_serving_size":  recipe.Nutrition.ServingSize,
+		"nutrition_fat":           recipe.Nutrition.Fat,
+		"nutrition_saturated_fat": recipe.Nutrition.SaturatedFat,
+		"nutrition_cholesterol":   recipe.Nutrition.Cholesterol,
+		"nutrition_sodium":        recipe.Nutrition.Sodium,
+		"nutrition_carbohydrates": recipe.Nutrition.Carbohydrates,
+		"nutrition_fiber":         recipe.Nutrition.Fiber,
+		"nutrition_sugar":         recipe.Nutrition.Sugar,
+		"nutrition_protein":       recipe.Nutrition.Protein,
+	}
+
+	if len(recipe.Images) > 0 {
+		values["image"] = recipe.Images[0]
+	}
+	if len(recipe.Yield) > 0 {
+		values["servings"] = recipe.Yield[0]
+	}
+
+	instructions := make([]string, len(recipe.Instructions))
+	for i, step := range recipe.Instructions {
+		instructions[i] = step.Text
+	}
+	values["instructions"] = strings.Join(instructions, ";")
+
+	if recipe.Rating != nil {
+		values["rating_value"] = strconv.FormatFloat(recipe.Rating.Value, 'f', -1, 64)
+		if recipe.Rating.Count > 0 {
+			values["rating_count"] = strconv.Itoa(recipe.Rating.Count)
+		}
+	}
+
+	if recipe.Video != nil {
+		values["video_url"] = recipe.Video.ContentURL
+		if values["video_url"] == "" {
+			values["video_url"] = recipe.Video.EmbedURL
+		}
+	}
+
+	return values
+}
+
 // extractNarrativeRecipe attempts to extract recipe data from narrative text for sites like smittenkitchen.com
 func (s *Scraper) extractNarrativeRecipe() map[string]string {
 	data := make(map[string]string)
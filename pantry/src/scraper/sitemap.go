@@ -0,0 +1,187 @@
+package scraper
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Sitemaps may be at most 50 MB uncompressed according to sitemaps.org
+	sitemapMaxSize = 50 * 1024 * 1024
+
+	// Upper bound on how many sitemap files are read for a single domain
+	sitemapMaxFiles = 500
+)
+
+// SitemapEntry is a page URL listed in a sitemap
+type SitemapEntry struct {
+	URL     string
+	LastMod time.Time
+	Sitemap string
+}
+
+// sitemapDocument covers both <urlset> and <sitemapindex> documents
+type sitemapDocument struct {
+	URLs     []sitemapLocation `xml:"url"`
+	Sitemaps []sitemapLocation `xml:"sitemap"`
+}
+
+// sitemapLocation is a <url> or <sitemap> element
+type sitemapLocation struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// DiscoverSitemaps returns the sitemap URLs for a domain: every Sitemap
+// line in robots.txt, or /sitemap.xml when robots.txt lists none
+func DiscoverSitemaps(domain string) []string {
+	origin := domain
+	if !strings.HasPrefix(origin, "http") {
+		origin = "https://" + origin
+	}
+	origin = strings.TrimRight(origin, "/")
+
+	parsedURL, err := url.Parse(origin)
+	if err != nil {
+		return nil
+	}
+
+	rules := Robots.Rules(parsedURL)
+	if sitemaps := rules.Sitemaps(); len(sitemaps) > 0 {
+		return sitemaps
+	}
+
+	return []string{origin + "/sitemap.xml"}
+}
+
+// WalkSitemaps reads the given sitemaps, following sitemap indexes
+// (including gzipped ones), and calls fn for every page URL found. Walking
+// stops early if fn returns false.
+func WalkSitemaps(roots []string, fn func(SitemapEntry) bool) error {
+	queue := append([]string(nil), roots...)
+	seen := make(map[string]bool)
+	files := 0
+
+	for len(queue) > 0 {
+		sitemapURL := queue[0]
+		queue = queue[1:]
+
+		if seen[sitemapURL] {
+			continue
+		}
+		seen[sitemapURL] = true
+
+		files++
+		if files > sitemapMaxFiles {
+			return fmt.Errorf("stopped after reading %d sitemaps", sitemapMaxFiles)
+		}
+
+		if !Robots.Allowed(sitemapURL) {
+			log.Printf("Sitemap disallowed by robots.txt: %s", sitemapURL)
+			continue
+		}
+
+		doc, err := fetchSitemap(sitemapURL)
+		if err != nil {
+			log.Printf("Failed to read sitemap %s: %v", sitemapURL, err)
+			continue
+		}
+
+		for _, child := range doc.Sitemaps {
+			if loc := strings.TrimSpace(child.Loc); loc != "" {
+				queue = append(queue, loc)
+			}
+		}
+
+		for _, page := range doc.URLs {
+			loc := strings.TrimSpace(page.Loc)
+			if loc == "" {
+				continue
+			}
+			entry := SitemapEntry{
+				URL:     loc,
+				LastMod: ParseLastMod(page.LastMod),
+				Sitemap: sitemapURL,
+			}
+			if !fn(entry) {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+// fetchSitemap downloads and decodes a single sitemap or sitemap index
+func fetchSitemap(sitemapURL string) (*sitemapDocument, error) {
+	response, err := DefaultFetcher.Fetch(sitemapURL, http.Header{"Accept": {"application/xml,text/xml;q=0.9,*/*;q=0.8"}})
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != 200 {
+		return nil, fmt.Errorf("status code %d", response.StatusCode)
+	}
+
+	return decodeSitemap(bytes.NewReader(response.Body))
+}
+
+// decodeSitemap parses a sitemap, transparently handling gzip whether it
+// was served as a .gz file or with Content-Encoding
+func decodeSitemap(r io.Reader) (*sitemapDocument, error) {
+	buffered := bufio.NewReader(r)
+
+	var reader io.Reader = buffered
+	if magic, err := buffered.Peek(2); err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	doc := &sitemapDocument{}
+	decoder := xml.NewDecoder(io.LimitReader(reader, sitemapMaxSize))
+	decoder.Strict = false
+	if err := decoder.Decode(doc); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+// ParseLastMod parses a W3C datetime as used by sitemap <lastmod>. It
+// returns the zero time when the value is missing or malformed.
+func ParseLastMod(value string) time.Time {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}
+	}
+
+	layouts := []string{
+		time.RFC3339Nano,
+		time.RFC3339,
+		"2006-01-02T15:04Z07:00",
+		"2006-01-02T15:04:05",
+		"2006-01-02",
+		"2006-01",
+		"2006",
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
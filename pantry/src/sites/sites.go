@@ -0,0 +1,341 @@
+package sites
+
+// Recipe site definitions loaded from an external YAML or JSON file
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/cascadia"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPath is the site definition file read at startup
+const DefaultPath = "sites.yaml"
+
+// The steps GetRecipeData takes to extract a recipe. Each one fills in the
+// fields the steps before it left empty.
+const (
+	ExtractPlugin    = "plugin"
+	ExtractJSONLD    = "json-ld"
+	ExtractMicrodata = "microdata"
+	ExtractSelectors = "selectors"
+	ExtractNarrative = "narrative"
+)
+
+// DefaultExtractionOrder is used when neither a site nor the file's
+// defaults set extraction_order
+var DefaultExtractionOrder = []string{ExtractPlugin, ExtractJSONLD, ExtractMicrodata, ExtractSelectors, ExtractNarrative}
+
+// Selectors holds the CSS selectors used to extract a recipe from a site
+type Selectors struct {
+	Title        string   `yaml:"title" json:"title"`
+	Description  string   `yaml:"description" json:"description"`
+	Ingredients  string   `yaml:"ingredients" json:"ingredients"`
+	Instructions string   `yaml:"instructions" json:"instructions"`
+	Time         string   `yaml:"time" json:"time"`
+	Servings     string   `yaml:"servings" json:"servings"`
+	Links        []string `yaml:"links" json:"links"`
+}
+
+// IsZero reports whether no selectors were configured
+func (s Selectors) IsZero() bool {
+	return s.Title == "" && s.Description == "" && s.Ingredients == "" &&
+		s.Instructions == "" && s.Time == "" && s.Servings == "" && len(s.Links) == 0
+}
+
+// RateLimit makes crawling a site politer than the global -delay and
+// -max-requests settings. It can only slow a site down, never speed it up.
+type RateLimit struct {
+	Delay         Duration `yaml:"delay" json:"delay"`
+	MaxConcurrent int      `yaml:"max_concurrent" json:"max_concurrent"`
+}
+
+// Site is a single recipe site definition
+type Site struct {
+	Domain string   `yaml:"domain" json:"domain"`
+	Seeds  []string `yaml:"seeds" json:"seeds"`
+
+	// URLPatterns identify recipe detail pages, which links are followed
+	// and when the selectors below apply
+	URLPatterns []string `yaml:"url_patterns" json:"url_patterns"`
+
+	// IndexPaths are the paths accepted when storing a recipe. They
+	// default to URLPatterns.
+	IndexPaths []string `yaml:"index_paths" json:"index_paths"`
+
+	// ListingPatterns identify category and index pages whose links are
+	// queued but which are not stored. They default to the file's
+	// defaults.listing_patterns.
+	ListingPatterns []string `yaml:"listing_patterns" json:"listing_patterns"`
+
+	Selectors Selectors `yaml:"selectors" json:"selectors"`
+	RateLimit RateLimit `yaml:"rate_limit" json:"rate_limit"`
+
+	// RecrawlInterval is how often "serve" re-checks the site's seeds,
+	// sitemaps and stale recipes. It defaults to defaults.recrawl_interval.
+	RecrawlInterval Duration `yaml:"recrawl_interval" json:"recrawl_interval"`
+
+	// ExtractionOrder lists the extraction steps to try, first to last.
+	// It defaults to defaults.extraction_order.
+	ExtractionOrder []string `yaml:"extraction_order" json:"extraction_order"`
+}
+
+// Defaults apply to every site that doesn't override them, and to URLs on
+// hosts that have no site definition
+type Defaults struct {
+	ListingPatterns []string `yaml:"listing_patterns" json:"listing_patterns"`
+	RecrawlInterval Duration `yaml:"recrawl_interval" json:"recrawl_interval"`
+	ExtractionOrder []string `yaml:"extraction_order" json:"extraction_order"`
+}
+
+// Config is the contents of a site definition file
+type Config struct {
+	Defaults Defaults `yaml:"defaults" json:"defaults"`
+	Sites    []Site   `yaml:"sites" json:"sites"`
+}
+
+// Duration is a time.Duration written as "1.5s" or a number of seconds
+type Duration time.Duration
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	return d.parse(value.Value)
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	return d.parse(strings.Trim(string(data), `"`))
+}
+
+// parse reads either a Go duration string or a plain number of seconds
+func (d *Duration) parse(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		*d = 0
+		return nil
+	}
+
+	if parsed, err := time.ParseDuration(value); err == nil {
+		*d = Duration(parsed)
+		return nil
+	}
+
+	var seconds float64
+	if _, err := fmt.Sscanf(value, "%g", &seconds); err != nil {
+		return fmt.Errorf("invalid duration %q", value)
+	}
+	*d = Duration(seconds * float64(time.Second))
+	return nil
+}
+
+// Load reads and validates a site definition file. Files ending in .json
+// are read as JSON, everything else as YAML.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read site definitions: %w", err)
+	}
+
+	cfg := &Config{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, cfg)
+	} else {
+		err = yaml.Unmarshal(data, cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	cfg.applyDefaults()
+
+	if errs := cfg.Validate(); len(errs) > 0 {
+		messages := make([]string, len(errs))
+		for i, err := range errs {
+			messages[i] = "  " + err.Error()
+		}
+		return nil, fmt.Errorf("invalid site definitions in %s:\n%s", path, strings.Join(messages, "\n"))
+	}
+
+	return cfg, nil
+}
+
+// applyDefaults fills in per-site values that were left out
+func (c *Config) applyDefaults() {
+	if len(c.Defaults.ExtractionOrder) == 0 {
+		c.Defaults.ExtractionOrder = DefaultExtractionOrder
+	}
+
+	for i := range c.Sites {
+		site := &c.Sites[i]
+		site.Domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(site.Domain), "www."))
+
+		if len(site.IndexPaths) == 0 {
+			site.IndexPaths = site.URLPatterns
+		}
+		if len(site.ListingPatterns) == 0 {
+			site.ListingPatterns = c.Defaults.ListingPatterns
+		}
+		if site.RecrawlInterval == 0 {
+			site.RecrawlInterval = c.Defaults.RecrawlInterval
+		}
+		if len(site.ExtractionOrder) == 0 {
+			site.ExtractionOrder = c.Defaults.ExtractionOrder
+		}
+	}
+}
+
+// Validate checks every site definition and returns all problems found
+func (c *Config) Validate() []error {
+	errs := []error{}
+	seen := make(map[string]bool)
+
+	if len(c.Sites) == 0 {
+		errs = append(errs, fmt.Errorf("no sites defined"))
+	}
+	if err := validateExtractionOrder(c.Defaults.ExtractionOrder); err != nil {
+		errs = append(errs, fmt.Errorf("defaults: %w", err))
+	}
+
+	for i, site := range c.Sites {
+		name := site.Domain
+		if name == "" {
+			name = fmt.Sprintf("sites[%d]", i)
+		}
+		fail := func(format string, args ...interface{}) {
+			errs = append(errs, fmt.Errorf("%s: %s", name, fmt.Sprintf(format, args...)))
+		}
+
+		switch {
+		case site.Domain == "":
+			fail("domain is required")
+		case strings.Contains(site.Domain, "/") || strings.Contains(site.Domain, ":"):
+			fail("domain must be a bare host name, got %q", site.Domain)
+		case seen[site.Domain]:
+			fail("duplicate domain")
+		}
+		seen[site.Domain] = true
+
+		for _, seed := range site.Seeds {
+			parsedURL, err := url.Parse(seed)
+			if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+				fail("seed %q is not an absolute http(s) URL", seed)
+				continue
+			}
+			if !MatchesDomain(parsedURL.Hostname(), site.Domain) {
+				fail("seed %q is not on %s", seed, site.Domain)
+			}
+		}
+
+		if len(site.URLPatterns) == 0 {
+			fail("at least one url_pattern is required")
+		}
+		for _, pattern := range append(append([]string{}, site.URLPatterns...), site.IndexPaths...) {
+			if strings.TrimSpace(pattern) == "" {
+				fail("url patterns must not be empty")
+			}
+		}
+
+		selectors := map[string]string{
+			"title":        site.Selectors.Title,
+			"description":  site.Selectors.Description,
+			"ingredients":  site.Selectors.Ingredients,
+			"instructions": site.Selectors.Instructions,
+			"time":         site.Selectors.Time,
+			"servings":     site.Selectors.Servings,
+		}
+		for i, link := range site.Selectors.Links {
+			selectors[fmt.Sprintf("links[%d]", i)] = link
+		}
+		for field, selector := range selectors {
+			if selector == "" {
+				continue
+			}
+			if _, err := cascadia.Compile(selector); err != nil {
+				fail("selectors.%s %q: %v", field, selector, err)
+			}
+		}
+		if !site.Selectors.IsZero() && len(site.Selectors.Links) == 0 {
+			fail("selectors.links is required when selectors are configured")
+		}
+
+		if site.RateLimit.Delay < 0 {
+			fail("rate_limit.delay must not be negative")
+		}
+		if site.RateLimit.MaxConcurrent < 0 {
+			fail("rate_limit.max_concurrent must not be negative")
+		}
+		if site.RecrawlInterval < 0 {
+			fail("recrawl_interval must not be negative")
+		}
+		if err := validateExtractionOrder(site.ExtractionOrder); err != nil {
+			fail("%v", err)
+		}
+	}
+
+	return errs
+}
+
+// validateExtractionOrder checks that an extraction order only names
+// known steps, each at most once
+func validateExtractionOrder(order []string) error {
+	known := make(map[string]bool)
+	for _, step := range DefaultExtractionOrder {
+		known[step] = true
+	}
+
+	seen := make(map[string]bool)
+	for _, step := range order {
+		switch {
+		case !known[step]:
+			return fmt.Errorf("extraction_order: unknown step %q, expected one of %s", step, strings.Join(DefaultExtractionOrder, ", "))
+		case seen[step]:
+			return fmt.Errorf("extraction_order: %q is listed twice", step)
+		}
+		seen[step] = true
+	}
+	return nil
+}
+
+// Seeds returns every site's seed URLs in file order
+func (c *Config) Seeds() []string {
+	seeds := []string{}
+	for _, site := range c.Sites {
+		seeds = append(seeds, site.Seeds...)
+	}
+	return seeds
+}
+
+// Lookup returns the site definition for a host, ignoring a www. prefix
+// and matching subdomains of the configured domain
+func (c *Config) Lookup(host string) *Site {
+	host = strings.ToLower(host)
+	if i := strings.Index(host, ":"); i >= 0 {
+		host = host[:i]
+	}
+
+	for i := range c.Sites {
+		if MatchesDomain(host, c.Sites[i].Domain) {
+			return &c.Sites[i]
+		}
+	}
+	return nil
+}
+
+// ListingPatterns returns the listing page patterns for a host
+func (c *Config) ListingPatterns(host string) []string {
+	if site := c.Lookup(host); site != nil {
+		return site.ListingPatterns
+	}
+	return c.Defaults.ListingPatterns
+}
+
+// MatchesDomain reports whether host is domain or one of its subdomains
+func MatchesDomain(host, domain string) bool {
+	host = strings.ToLower(host)
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
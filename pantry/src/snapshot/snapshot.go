@@ -0,0 +1,381 @@
+package snapshot
+
+// Recipe snapshots written as rolling, gzip-compressed NDJSON segments
+// described by a manifest
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"search-engine-indexer/src/structs"
+)
+
+// DefaultDir is where snapshots are written
+const DefaultDir = "recipe_snapshots"
+
+// Segment limits. A segment is closed and a new one started once either
+// is reached.
+var (
+	MaxSegmentRecords       = 2000
+	MaxSegmentBytes   int64 = 64 << 20 // uncompressed
+)
+
+// Where the recipes in a snapshot came from
+const (
+	SourceIndex   = "index"
+	SourceCrawl   = "crawl"
+	SourceBackups = "backups"
+)
+
+// Segment is one gzip-compressed NDJSON file of a snapshot
+type Segment struct {
+	File   string    `json:"file"`
+	Count  int       `json:"count"`
+	Bytes  int64     `json:"bytes"`
+	SHA256 string    `json:"sha256"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+}
+
+// Manifest describes a snapshot: its segments with their checksums, how
+// many recipes it holds and the range of their last_changed times.
+// Incremental snapshots only hold recipes changed since Since, and Base
+// names the snapshot they build on.
+type Manifest struct {
+	ID          string    `json:"id"`
+	Source      string    `json:"source"`
+	Created     time.Time `json:"created"`
+	Incremental bool      `json:"incremental"`
+	Base        string    `json:"base,omitempty"`
+	Since       time.Time `json:"since"`
+
+	// Until is the time the snapshot is complete up to, the starting point
+	// for the next incremental snapshot. It is zero for crawl snapshots,
+	// which only hold the recipes created by one crawl.
+	Until time.Time `json:"until"`
+
+	Count    int       `json:"count"`
+	From     time.Time `json:"from"`
+	To       time.Time `json:"to"`
+	Segments []Segment `json:"segments"`
+
+	// Complete is false while the snapshot is still being written
+	Complete bool `json:"complete"`
+}
+
+// Options for a new snapshot
+type Options struct {
+	Source      string
+	Incremental bool
+	Base        string
+	Since       time.Time
+	Until       time.Time
+}
+
+// Writer appends recipes to a snapshot. It is safe for concurrent use.
+type Writer struct {
+	dir      string
+	manifest Manifest
+
+	mu      sync.Mutex
+	file    *os.File
+	hash    hash.Hash
+	counter *countingWriter
+	gz      *gzip.Writer
+	buf     *bufio.Writer
+	segment Segment
+	written int64
+}
+
+// Create starts a new snapshot in dir
+func Create(dir string, opts Options) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	id := now.Format("20060102T150405Z")
+	for i := 2; fileExists(filepath.Join(dir, manifestName(id))); i++ {
+		id = fmt.Sprintf("%s-%d", now.Format("20060102T150405Z"), i)
+	}
+
+	w := &Writer{
+		dir: dir,
+		manifest: Manifest{
+			ID:          id,
+			Source:      opts.Source,
+			Created:     now,
+			Incremental: opts.Incremental,
+			Base:        opts.Base,
+			Since:       opts.Since,
+			Until:       opts.Until,
+			Segments:    []Segment{},
+		},
+	}
+	return w, w.saveManifest()
+}
+
+// ID returns the snapshot's ID
+func (w *Writer) ID() string {
+	return w.manifest.ID
+}
+
+// Write appends a recipe, starting a new segment when the current one is full
+func (w *Writer) Write(p structs.Page) error {
+	line, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		if err := w.openSegment(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := w.buf.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	w.written += int64(len(line) + 1)
+
+	changed := recordTime(p)
+	w.segment.Count++
+	if w.segment.From.IsZero() || changed.Before(w.segment.From) {
+		w.segment.From = changed
+	}
+	if changed.After(w.segment.To) {
+		w.segment.To = changed
+	}
+
+	if w.segment.Count >= MaxSegmentRecords || w.written >= MaxSegmentBytes {
+		return w.closeSegment()
+	}
+	return nil
+}
+
+// Close finishes the open segment and marks the manifest complete
+func (w *Writer) Close() (*Manifest, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file != nil {
+		if err := w.closeSegment(); err != nil {
+			return &w.manifest, err
+		}
+	}
+
+	w.manifest.Complete = true
+	return &w.manifest, w.saveManifest()
+}
+
+// openSegment starts the next segment file. The caller must hold w.mu.
+func (w *Writer) openSegment() error {
+	name := fmt.Sprintf("snapshot-%s-%04d.ndjson.gz", w.manifest.ID, len(w.manifest.Segments)+1)
+	file, err := os.Create(filepath.Join(w.dir, name))
+	if err != nil {
+		return err
+	}
+
+	w.file = file
+	w.hash = sha256.New()
+	w.counter = &countingWriter{w: io.MultiWriter(file, w.hash)}
+	w.gz = gzip.NewWriter(w.counter)
+	w.buf = bufio.NewWriter(w.gz)
+	w.segment = Segment{File: name}
+	w.written = 0
+	return nil
+}
+
+// closeSegment flushes the open segment, records it in the manifest and
+// saves the manifest so finished segments survive a crash. The caller must
+// hold w.mu.
+func (w *Writer) closeSegment() error {
+	err := w.buf.Flush()
+	if closeErr := w.gz.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	w.file = nil
+	if err != nil {
+		return err
+	}
+
+	w.segment.Bytes = w.counter.n
+	w.segment.SHA256 = hex.EncodeToString(w.hash.Sum(nil))
+
+	m := &w.manifest
+	m.Segments = append(m.Segments, w.segment)
+	m.Count += w.segment.Count
+	if m.From.IsZero() || w.segment.From.Before(m.From) {
+		m.From = w.segment.From
+	}
+	if w.segment.To.After(m.To) {
+		m.To = w.segment.To
+	}
+
+	return w.saveManifest()
+}
+
+// saveManifest writes the manifest atomically. The caller must hold w.mu
+// or be the only user of w.
+func (w *Writer) saveManifest() error {
+	data, err := json.MarshalIndent(w.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(w.dir, manifestName(w.manifest.ID))
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// List returns every manifest in dir, oldest first
+func List(dir string) ([]*Manifest, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "snapshot-*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := []*Manifest{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		m := &Manifest{}
+		if err := json.Unmarshal(data, m); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+		manifests = append(manifests, m)
+	}
+
+	sort.Slice(manifests, func(i, j int) bool {
+		return manifests[i].Created.Before(manifests[j].Created)
+	})
+	return manifests, nil
+}
+
+// LatestBase returns the newest complete snapshot an incremental snapshot
+// can build on, or nil if there is none
+func LatestBase(dir string) (*Manifest, error) {
+	manifests, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(manifests) - 1; i >= 0; i-- {
+		if manifests[i].Complete && !manifests[i].Until.IsZero() {
+			return manifests[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// Verify checks a segment's size and checksum
+func Verify(dir string, segment Segment) error {
+	file, err := os.Open(filepath.Join(dir, segment.File))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, file)
+	if err != nil {
+		return err
+	}
+	if n != segment.Bytes {
+		return fmt.Errorf("%d bytes, manifest says %d", n, segment.Bytes)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != segment.SHA256 {
+		return fmt.Errorf("checksum mismatch")
+	}
+	return nil
+}
+
+// ReadSegment calls fn for every recipe in a segment
+func ReadSegment(dir string, segment Segment, fn func(structs.Page) error) error {
+	file, err := os.Open(filepath.Join(dir, segment.File))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", segment.File, err)
+	}
+	defer gz.Close()
+
+	decoder := json.NewDecoder(gz)
+	for line := 1; ; line++ {
+		var p structs.Page
+		err := decoder.Decode(&p)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s record %d: %w", segment.File, line, err)
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+}
+
+// IsSnapshotDir reports whether dir holds snapshot manifests
+func IsSnapshotDir(dir string) bool {
+	paths, _ := filepath.Glob(filepath.Join(dir, "snapshot-*.json"))
+	return len(paths) > 0
+}
+
+// manifestName returns the manifest file name for a snapshot ID
+func manifestName(id string) string {
+	return "snapshot-" + id + ".json"
+}
+
+// recordTime is the time a recipe last changed, falling back to when it
+// was crawled
+func recordTime(p structs.Page) time.Time {
+	if !p.LastChanged.IsZero() {
+		return p.LastChanged
+	}
+	return p.CrawlDate
+}
+
+// fileExists reports whether a path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write implements io.Writer
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -1,12 +1,27 @@
 package structs
 
 import (
-	"fmt"
+	"math"
 	"regexp"
+	"search-engine-indexer/src/ingredient"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// Older crawls stored these in place of ingredients and instructions that
+// couldn't be extracted from a page
+const (
+	PlaceholderIngredients  = "Ingredients mentioned in page but not structured"
+	PlaceholderInstructions = "Instructions mentioned in page but not structured"
+)
+
+// IsPlaceholder reports whether an ingredients or instructions string is
+// the given placeholder, ignoring case and surrounding space
+func IsPlaceholder(value, placeholder string) bool {
+	return strings.EqualFold(strings.TrimSpace(value), placeholder)
+}
+
 // Page is the main struct for storing recipe data
 type Page struct {
 	ID           string    `json:"id"`
@@ -25,7 +40,72 @@ type Page struct {
 	Instructions string    `json:"instructions"`
 	SourceSite   string    `json:"source_site"`
 	CrawlDate    time.Time `json:"crawl_date"`
+	LastChanged  time.Time `json:"last_changed"`
 	Categories   string    `json:"categories,omitempty"`
+
+	// Details from the page's schema.org Recipe markup. Categories above
+	// comes from its recipeCategory.
+	Cuisines    []string   `json:"cuisines,omitempty"`
+	Keywords    []string   `json:"keywords,omitempty"`
+	Diets       []string   `json:"diets,omitempty"`
+	Author      string     `json:"author,omitempty"`
+	RatingValue *float64   `json:"rating_value,omitempty"`
+	RatingCount *int       `json:"rating_count,omitempty"`
+	VideoURL    string     `json:"video_url,omitempty"`
+	Nutrition   *Nutrition `json:"nutrition,omitempty"`
+
+	// Notes are the cook's notes from a recipe plugin's card
+	Notes string `json:"notes,omitempty"`
+
+	// Numeric values parsed from the time, calorie and servings strings
+	// above, nil when they couldn't be parsed
+	PrepMinutes  *int `json:"prep_minutes,omitempty"`
+	CookMinutes  *int `json:"cook_minutes,omitempty"`
+	TotalMinutes *int `json:"total_minutes,omitempty"`
+	CaloriesKcal *int `json:"calories_kcal,omitempty"`
+	ServingsMin  *int `json:"servings_min,omitempty"`
+	ServingsMax  *int `json:"servings_max,omitempty"`
+
+	// IngredientItems holds each line of Ingredients split into its parts,
+	// indexed as nested documents so ingredient queries match per line.
+	// IngredientPlugin names the recipe plugin whose markup the parts were
+	// read from, and is empty when they were parsed from the lines.
+	IngredientItems  []RecipeIngredient `json:"ingredient_items,omitempty"`
+	IngredientPlugin string             `json:"ingredient_plugin,omitempty"`
+
+	// Extraction records the source and confidence of each field, keyed
+	// as in GetRecipeData, and Confidence is the lowest confidence of the
+	// name, ingredients and instructions
+	Extraction map[string]FieldExtraction `json:"extraction,omitempty"`
+	Confidence *float64                   `json:"confidence,omitempty"`
+
+	// Fingerprint holds the ingredient and instruction band keys used to
+	// find near-duplicates, and DuplicateGroup the ID shared by every copy
+	// of the same recipe
+	Fingerprint    []string `json:"fingerprint,omitempty"`
+	DuplicateGroup string   `json:"duplicate_group,omitempty"`
+}
+
+// Nutrition holds the nutrition values of one serving as written on the
+// page, e.g. "12 g". Calories are kept in Page.Calories.
+type Nutrition struct {
+	ServingSize   string `json:"serving_size,omitempty"`
+	Fat           string `json:"fat,omitempty"`
+	SaturatedFat  string `json:"saturated_fat,omitempty"`
+	Cholesterol   string `json:"cholesterol,omitempty"`
+	Sodium        string `json:"sodium,omitempty"`
+	Carbohydrates string `json:"carbohydrates,omitempty"`
+	Fiber         string `json:"fiber,omitempty"`
+	Sugar         string `json:"sugar,omitempty"`
+	Protein       string `json:"protein,omitempty"`
+}
+
+// FieldExtraction records where a recipe field came from. Source is the
+// extraction step that filled it, such as "json-ld" or "narrative", and
+// Confidence how far values from it are trusted, from 0 to 1.
+type FieldExtraction struct {
+	Source     string  `json:"source"`
+	Confidence float64 `json:"confidence"`
 }
 
 // APIResponse represents a generic API response
@@ -41,13 +121,27 @@ type SearchResult struct {
 	Pages     []Page `json:"pages"`
 }
 
-// RecipeIngredient represents a single ingredient with its components
+// RecipeIngredient represents a single ingredient with its components.
+// Quantity is the amount as written and QuantityMin and QuantityMax its
+// value, and Unit is one of the canonical ingredient.Unit values.
 type RecipeIngredient struct {
-	Original   string `json:"original"`
-	Quantity   string `json:"quantity,omitempty"`
-	Unit       string `json:"unit,omitempty"`
-	Ingredient string `json:"ingredient"`
-	Notes      string `json:"notes,omitempty"`
+	Original    string  `json:"original"`
+	Quantity    string  `json:"quantity,omitempty"`
+	QuantityMin float64 `json:"quantity_min,omitempty"`
+	QuantityMax float64 `json:"quantity_max,omitempty"`
+	Unit        string  `json:"unit,omitempty"`
+	Ingredient  string  `json:"ingredient"`
+	Preparation string  `json:"preparation,omitempty"`
+	Notes       string  `json:"notes,omitempty"`
+	Optional    bool    `json:"optional,omitempty"`
+
+	// Name is the normalized ingredient, e.g. "chicken thigh" for
+	// "boneless skinless chicken thighs, trimmed"
+	Name string `json:"name,omitempty"`
+
+	// Group is the heading the ingredient is listed under, e.g. "For the
+	// sauce", when a recipe plugin's markup gives one
+	Group string `json:"group,omitempty"`
 }
 
 // ParsedRecipe represents a recipe with parsed ingredients and instructions
@@ -143,53 +237,196 @@ func ParseIngredients(ingredients string) []RecipeIngredient {
 			continue
 		}
 
-		// Create a basic ingredient structure
-		parsed := RecipeIngredient{
-			Original:   ing,
-			Ingredient: ing,
+		result = append(result, newRecipeIngredient(ing, ingredient.Parse(ing)))
+	}
+
+	return result
+}
+
+// newRecipeIngredient makes a RecipeIngredient of a parsed line
+func newRecipeIngredient(original string, parsed ingredient.Parsed) RecipeIngredient {
+	return RecipeIngredient{
+		Original:    original,
+		Quantity:    parsed.Quantity,
+		QuantityMin: parsed.Min,
+		QuantityMax: parsed.Max,
+		Unit:        string(parsed.Unit),
+		Ingredient:  parsed.Ingredient,
+		Preparation: parsed.Preparation,
+		Notes:       parsed.Notes,
+		Optional:    parsed.Optional,
+		Name:        parsed.Name,
+	}
+}
+
+// SetIngredientItems splits the ingredients string into IngredientItems,
+// leaving it empty for the placeholder stored when none were found. Items
+// read from a recipe plugin's markup are kept while they still match the
+// ingredients.
+func (p *Page) SetIngredientItems() {
+	if p.IngredientPlugin != "" && p.pluginItemsMatch() {
+		return
+	}
+
+	p.IngredientItems = nil
+	p.IngredientPlugin = ""
+	if IsPlaceholder(p.Ingredients, PlaceholderIngredients) {
+		return
+	}
+	if items := ParseIngredients(p.Ingredients); len(items) > 0 {
+		p.IngredientItems = items
+	}
+}
+
+// pluginItemsMatch reports whether IngredientItems still holds one item
+// per line of Ingredients
+func (p *Page) pluginItemsMatch() bool {
+	i := 0
+	for _, line := range strings.Split(p.Ingredients, ";") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		if i >= len(p.IngredientItems) || p.IngredientItems[i].Original != line {
+			return false
 		}
+		i++
+	}
+	return i > 0 && i == len(p.IngredientItems)
+}
 
-		// Try to extract quantity
-		if quantityRegex := regexp.MustCompile(`^([\d\s./]+)`); quantityRegex.MatchString(ing) {
-			matches := quantityRegex.FindStringSubmatch(ing)
-			if len(matches) > 1 {
-				parsed.Quantity = strings.TrimSpace(matches[1])
-				parsed.Ingredient = strings.TrimSpace(ing[len(matches[1]):])
-			}
+// SetPluginIngredients sets IngredientItems from the parts a recipe
+// plugin's markup splits each ingredient into, keyed as in GetRecipeData.
+// Lines the plugin didn't split are parsed as usual. On pages without a
+// plugin it leaves both fields empty for SetIngredientItems to fill.
+func (p *Page) SetPluginIngredients(data map[string]string) {
+	p.IngredientItems = nil
+	p.IngredientPlugin = data["ingredient_plugin"]
+	if p.IngredientPlugin == "" {
+		return
+	}
+
+	amounts := strings.Split(data["ingredient_amounts"], ";")
+	units := strings.Split(data["ingredient_units"], ";")
+	names := strings.Split(data["ingredient_names"], ";")
+	notes := strings.Split(data["ingredient_notes"], ";")
+	groups := strings.Split(data["ingredient_groups"], ";")
+
+	// The part lists line up with every line, empty ones included
+	for i, line := range strings.Split(data["ingredients"], ";") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
 		}
 
-		// Try to extract unit
-		unitPattern := `^\s*([\d\s./]+)?\s*(cup|cups|tbsp|tsp|tablespoon|tablespoons|teaspoon|teaspoons|oz|ounce|ounces|lb|pound|pounds|g|gram|grams|kg|kilogram|kilograms|ml|milliliter|milliliters|l|liter|liters|pinch|dash|can|cans|clove|cloves)\s*`
-		if unitRegex := regexp.MustCompile(unitPattern); unitRegex.MatchString(parsed.Ingredient) {
-			matches := unitRegex.FindStringSubmatch(parsed.Ingredient)
-			if len(matches) > 2 {
-				if parsed.Quantity == "" && matches[1] != "" {
-					parsed.Quantity = strings.TrimSpace(matches[1])
-				}
-				parsed.Unit = strings.TrimSpace(matches[2])
-				parsed.Ingredient = strings.TrimSpace(parsed.Ingredient[len(matches[0]):])
-			}
+		parsed := ingredient.Parse(line)
+		if name := listItem(names, i); name != "" {
+			parsed = ingredient.FromParts(listItem(amounts, i), listItem(units, i), name, listItem(notes, i))
 		}
 
-		// Check for notes in parentheses
-		if notesRegex := regexp.MustCompile(`(.*?)\s*\((.*?)\)\s*(.*)`); notesRegex.MatchString(parsed.Ingredient) {
-			matches := notesRegex.FindStringSubmatch(parsed.Ingredient)
-			if len(matches) > 3 {
-				parsed.Notes = strings.TrimSpace(matches[2])
-				parsed.Ingredient = strings.TrimSpace(matches[1] + " " + matches[3])
-			}
+		item := newRecipeIngredient(line, parsed)
+		item.Group = listItem(groups, i)
+		p.IngredientItems = append(p.IngredientItems, item)
+	}
+
+	if len(p.IngredientItems) == 0 {
+		p.IngredientPlugin = ""
+	}
+}
+
+// listItem returns the i-th item of a split list, or "" past its end
+func listItem(list []string, i int) string {
+	if i < len(list) {
+		return strings.TrimSpace(list[i])
+	}
+	return ""
+}
+
+// SetRecipeDetails copies the schema.org and recipe card details
+// extracted from a page, keyed as in GetRecipeData, leaving out those the
+// page didn't have
+func (p *Page) SetRecipeDetails(data map[string]string) {
+	p.Categories = data["categories"]
+	p.Cuisines = detailList(data["cuisines"])
+	p.Keywords = detailList(data["keywords"])
+	p.Diets = detailList(data["diets"])
+	p.Author = data["author"]
+	p.VideoURL = data["video_url"]
+	p.Notes = data["notes"]
+
+	p.RatingValue, p.RatingCount = nil, nil
+	if value, err := strconv.ParseFloat(data["rating_value"], 64); err == nil {
+		p.RatingValue = &value
+		if count, err := strconv.Atoi(data["rating_count"]); err == nil {
+			p.RatingCount = &count
 		}
+	}
+
+	nutrition := Nutrition{
+		ServingSize:   data["nutrition_serving_size"],
+		Fat:           data["nutrition_fat"],
+		SaturatedFat:  data["nutrition_saturated_fat"],
+		Cholesterol:   data["nutrition_cholesterol"],
+		Sodium:        data["nutrition_sodium"],
+		Carbohydrates: data["nutrition_carbohydrates"],
+		Fiber:         data["nutrition_fiber"],
+		Sugar:         data["nutrition_sugar"],
+		Protein:       data["nutrition_protein"],
+	}
+	p.Nutrition = nil
+	if nutrition != (Nutrition{}) {
+		p.Nutrition = &nutrition
+	}
+}
 
-		// Do some final cleanup
-		parsed.Ingredient = strings.TrimSpace(parsed.Ingredient)
+// requiredFields are the fields every recipe needs, which set its overall
+// confidence
+var requiredFields = []string{"name", "ingredients", "instructions"}
+
+// SetExtraction records where each field came from and sets Confidence.
+// A required field with no source, such as placeholder ingredients,
+// counts as confidence 0.
+func (p *Page) SetExtraction(extraction map[string]FieldExtraction) {
+	p.Extraction = nil
+	if len(extraction) > 0 {
+		p.Extraction = extraction
+	}
 
-		// Remove trailing commas, semicolons, etc.
-		parsed.Ingredient = regexp.MustCompile(`[,;.]+$`).ReplaceAllString(parsed.Ingredient, "")
+	confidence := 1.0
+	for _, field := range requiredFields {
+		confidence = math.Min(confidence, extraction[field].Confidence)
+	}
+	p.Confidence = &confidence
+}
 
-		result = append(result, parsed)
+// QuarantineReason says why the page shouldn't be indexed as a recipe, or
+// returns "" when it should. A page fails without a name, without real
+// ingredients or instructions, or with Confidence below minConfidence.
+// Reasons are short fixed phrases, such as "no ingredients", so they can
+// be filtered on.
+func (p *Page) QuarantineReason(minConfidence float64) string {
+	switch {
+	case p.Name == "" && p.Title == "":
+		return "no name"
+	case strings.TrimSpace(p.Ingredients) == "":
+		return "no ingredients"
+	case IsPlaceholder(p.Ingredients, PlaceholderIngredients):
+		return "placeholder ingredients"
+	case strings.TrimSpace(p.Instructions) == "":
+		return "no instructions"
+	case IsPlaceholder(p.Instructions, PlaceholderInstructions):
+		return "placeholder instructions"
+	case p.Confidence != nil && *p.Confidence < minConfidence:
+		return "low confidence"
 	}
+	return ""
+}
 
-	return result
+// detailList splits a semicolon-separated list, returning nil when it's
+// empty so the field is left out
+func detailList(list string) []string {
+	if values := ParseCategories(list); len(values) > 0 {
+		return values
+	}
+	return nil
 }
 
 // ParseInstructions parses a semicolon-separated instruction string into a slice of strings
@@ -299,29 +536,169 @@ func FromPageStruct(page Page) ParsedRecipe {
 	}
 }
 
-// EstimateTimeInMinutes converts time strings like "1 hr 30 min" to minutes
+// EstimateTimeInMinutes converts time strings like "1 hr 30 min" to
+// minutes, returning 0 if the string can't be parsed
 func EstimateTimeInMinutes(timeStr string) int {
+	minutes, _ := ParseMinutes(timeStr)
+	return minutes
+}
+
+// ParseMinutes converts time strings like "1 hr 30 min", "1 1/2 hours" or
+// ISO 8601 durations like "PT1H30M" to minutes. Ranges such as "20-25
+// minutes" count as their upper bound. ok is false if there is no time in
+// the string.
+func ParseMinutes(timeStr string) (int, bool) {
+	timeStr = strings.TrimSpace(timeStr)
 	if timeStr == "" {
-		return 0
+		return 0, false
 	}
 
-	total := 0
+	// ISO 8601 durations, as used by schema.org
+	if matches := isoDurationRegex.FindStringSubmatch(strings.ToUpper(timeStr)); matches != nil {
+		total, found := 0.0, false
+		for i, perUnit := range []float64{24 * 60, 60, 1, 1.0 / 60} {
+			if matches[i+1] != "" {
+				value, _ := strconv.ParseFloat(matches[i+1], 64)
+				total += value * perUnit
+				found = true
+			}
+		}
+		return int(math.Round(total)), found
+	}
 
-	// Extract hours
-	hourRegex := regexp.MustCompile(`(\d+)\s*(?:hr|hour|h)`)
-	if matches := hourRegex.FindStringSubmatch(timeStr); len(matches) > 1 {
-		hours := 0
-		fmt.Sscanf(matches[1], "%d", &hours)
-		total += hours * 60
+	// Split run-together units such as "1h30m"
+	text := replaceUnicodeFractions(strings.ToLower(timeStr))
+	text = unitThenDigitRegex.ReplaceAllString(text, "$1 $2")
+
+	matches := durationRegex.FindAllStringSubmatch(text, -1)
+	total := 0.0
+	for _, match := range matches {
+		value := parseNumber(match[1])
+		switch unit := match[2]; {
+		case strings.HasPrefix(unit, "d"):
+			total += value * 24 * 60
+		case strings.HasPrefix(unit, "h"):
+			total += value * 60
+		case strings.HasPrefix(unit, "s"):
+			total += value / 60
+		default:
+			total += value
+		}
+	}
+	if len(matches) > 0 {
+		return int(math.Round(total)), true
 	}
 
-	// Extract minutes
-	minRegex := regexp.MustCompile(`(\d+)\s*(?:min|minute|m)`)
-	if matches := minRegex.FindStringSubmatch(timeStr); len(matches) > 1 {
-		minutes := 0
-		fmt.Sscanf(matches[1], "%d", &minutes)
-		total += minutes
+	// A bare number is taken as minutes
+	if bareNumberRegex.MatchString(text) {
+		return int(math.Round(parseNumber(text))), true
 	}
 
+	return 0, false
+}
+
+// ParseCalories returns the number of kilocalories in strings like "250
+// kcal", "Calories: 1,250" or "1046 kJ". ok is false if there is no number.
+func ParseCalories(calories string) (int, bool) {
+	text := strings.ReplaceAll(strings.ToLower(calories), ",", "")
+	matches := caloriesRegex.FindStringSubmatch(text)
+	if matches == nil {
+		return 0, false
+	}
+
+	value := parseNumber(matches[1])
+	if matches[2] == "kj" {
+		value /= 4.184
+	}
+	return int(math.Round(value)), true
+}
+
+// ParseServings returns the yield range in strings like "4 servings",
+// "Serves 4 to 6" or "Makes 2 dozen". A single number gives the same min
+// and max; both are 0 if there is no number.
+func ParseServings(servings string) (int, int) {
+	text := replaceUnicodeFractions(strings.ToLower(servings))
+	text = parentheticalRegex.ReplaceAllString(text, " ")
+
+	matches := servingsRegex.FindStringSubmatch(text)
+	if matches == nil {
+		return 0, 0
+	}
+
+	min := parseNumber(matches[1])
+	max := min
+	if matches[2] != "" {
+		max = parseNumber(matches[2])
+	}
+	if matches[3] != "" {
+		min, max = min*12, max*12
+	}
+	if max < min {
+		min, max = max, min
+	}
+	if min <= 0 || max > 1000 {
+		return 0, 0
+	}
+
+	return int(math.Round(min)), int(math.Round(max))
+}
+
+// SetNumericFields fills the numeric time, calorie and servings fields from
+// the strings they're parsed from, leaving nil any that can't be parsed
+func (p *Page) SetNumericFields() {
+	p.PrepMinutes = intOrNil(ParseMinutes(p.PrepTime))
+	p.CookMinutes = intOrNil(ParseMinutes(p.CookTime))
+	p.TotalMinutes = intOrNil(ParseMinutes(p.TotalTime))
+	p.CaloriesKcal = intOrNil(ParseCalories(p.Calories))
+
+	min, max := ParseServings(p.Servings)
+	p.ServingsMin = intOrNil(min, min > 0)
+	p.ServingsMax = intOrNil(max, max > 0)
+}
+
+// intOrNil returns a pointer to value, or nil if it isn't ok
+func intOrNil(value int, ok bool) *int {
+	if !ok {
+		return nil
+	}
+	return &value
+}
+
+var (
+	isoDurationRegex   = regexp.MustCompile(`^P(?:(\d+(?:\.\d+)?)D)?(?:T(?:(\d+(?:\.\d+)?)H)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+	durationRegex      = regexp.MustCompile(`(\d+(?:\.\d+)?(?:\s+\d+/\d+)?|\d+/\d+)\s*(days?|d|hours?|hrs?|h|minutes?|mins?|m|seconds?|secs?|s)\b`)
+	unitThenDigitRegex = regexp.MustCompile(`([a-z])(\d)`)
+	bareNumberRegex    = regexp.MustCompile(`^\s*\d+(?:\.\d+)?\s*$`)
+	caloriesRegex      = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*(kj)?`)
+	servingsRegex      = regexp.MustCompile(`(\d+(?:\.\d+)?(?:\s+\d+/\d+)?|\d+/\d+)(?:\s*(?:-|–|to|or)\s*(\d+(?:\.\d+)?))?\s*(dozen)?`)
+	parentheticalRegex = regexp.MustCompile(`\([^)]*\)`)
+
+	unicodeFractions = strings.NewReplacer(
+		"½", " 1/2", "⅓", " 1/3", "⅔", " 2/3", "¼", " 1/4", "¾", " 3/4",
+		"⅕", " 1/5", "⅛", " 1/8", "⅜", " 3/8", "⅝", " 5/8", "⅞", " 7/8",
+	)
+)
+
+// replaceUnicodeFractions spells out fraction characters, so "1½" becomes
+// "1 1/2"
+func replaceUnicodeFractions(text string) string {
+	return strings.TrimSpace(unicodeFractions.Replace(text))
+}
+
+// parseNumber reads "2", "1.5", "1/2" or "1 1/2"
+func parseNumber(text string) float64 {
+	total := 0.0
+	for _, field := range strings.Fields(text) {
+		if numerator, denominator, ok := strings.Cut(field, "/"); ok {
+			n, err1 := strconv.ParseFloat(numerator, 64)
+			d, err2 := strconv.ParseFloat(denominator, 64)
+			if err1 == nil && err2 == nil && d != 0 {
+				total += n / d
+			}
+			continue
+		}
+		value, _ := strconv.ParseFloat(field, 64)
+		total += value
+	}
 	return total
 }
@@ -5,12 +5,12 @@ go 1.18
 require (
 	github.com/gorilla/mux v1.8.0
 	github.com/olivere/elastic/v7 v7.0.32
+	github.com/sirupsen/logrus v1.9.3
 )
 
 require (
 	github.com/josharian/intern v1.0.0 // indirect
 	github.com/mailru/easyjson v0.7.7 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
-	github.com/sirupsen/logrus v1.9.3 // indirect
 	golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
 )
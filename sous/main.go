@@ -52,7 +52,7 @@ func main() {
 	exists := elasticsearch.ExistsIndex(elasticsearch.IndexName)
 
 	if !exists {
-		elasticsearch.CreateIndex(elasticsearch.IndexName)
+		log.Printf("Index %s doesn't exist yet, run pantry to create it", elasticsearch.IndexName)
 	}
 
 	mux := mux.NewRouter()
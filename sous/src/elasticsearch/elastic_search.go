@@ -13,31 +13,9 @@ import (
 	elastic "github.com/olivere/elastic/v7"
 )
 
-const (
-	IndexName    = "recipes"
-	IndexMapping = `{
-		"settings":{
-			"number_of_shards":1,
-			"number_of_replicas":0
-		},
-		"mappings":{
-			"properties":{
-				"title": {
-					"type":"text"
-				},
-				"description": {
-					"type":"text"
-				},
-				"body": {
-					"type":"text"
-				},
-				"url": {
-					"type":"text"
-				}
-			}
-		}
-	}`
-)
+// IndexName is the alias pantry keeps pointed at the current recipe index.
+// Pantry owns the mapping and creates the index; sous only reads from it.
+const IndexName = "recipes"
 
 var client *elastic.Client
 
@@ -87,21 +65,6 @@ func ExistsIndex(i string) bool {
 	return exists
 }
 
-// CreateIndex creates a new index
-func CreateIndex(i string) {
-	createIndex, err := client.CreateIndex(IndexName).
-		Body(IndexMapping).
-		Do(context.Background())
-
-	if err != nil {
-		fmt.Println(err)
-		return
-	}
-	if !createIndex.Acknowledged {
-		log.Println("CreateIndex was not acknowledged. Check that timeout value is correct.")
-	}
-}
-
 // SearchContent returns the results for a given query
 func SearchContent(input string) []structs.Page {
 	pages := []structs.Page{}